@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+// APIProbes is returned by the /api/probes handler.
+type APIProbes struct {
+	Probes []xfer.ProbeInfo `json:"probes"`
+}
+
+// prober lists the probes that have sent reports.
+type prober interface {
+	Probes() []xfer.ProbeInfo
+}
+
+// makeProbesHandler returns a handler that yields an APIProbes.
+func makeProbesHandler(p prober) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWith(w, http.StatusOK, APIProbes{Probes: p.Probes()})
+	}
+}
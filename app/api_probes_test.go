@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
+)
+
+func TestAPIProbes(t *testing.T) {
+	ts := httptest.NewServer(Router(xfer.NewCollector(time.Minute), nil))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	ok(t, xfer.BinaryCodec.Encode(&buf, xfer.Envelope{
+		ProbeID:      "probe1",
+		ProbeVersion: "0.7.0",
+		Hostname:     "host1",
+		Sequence:     1,
+		Timestamp:    time.Now(),
+		Report:       report.MakeReport(),
+	}))
+	req, err := http.NewRequest("POST", ts.URL+"/api/report", &buf)
+	ok(t, err)
+	req.Header.Set("Content-Type", xfer.BinaryContentType)
+	res, err := http.DefaultClient.Do(req)
+	ok(t, err)
+	res.Body.Close()
+	equals(t, http.StatusOK, res.StatusCode)
+
+	var probes APIProbes
+	ok(t, json.Unmarshal(getRawJSON(t, ts, "/api/probes"), &probes))
+	equals(t, 1, len(probes.Probes))
+	probe := probes.Probes[0]
+	equals(t, "probe1", probe.ID)
+	equals(t, "0.7.0", probe.Version)
+	equals(t, "host1", probe.Hostname)
+	equals(t, false, probe.Silent)
+	assert(t, probe.ReportSize > 0, "report size should be positive, is %d", probe.ReportSize)
+}
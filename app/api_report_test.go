@@ -2,14 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
 )
 
 func TestAPIReport(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 
 	is404(t, ts, "/api/report/foobar")
@@ -22,3 +25,39 @@ func TestAPIReport(t *testing.T) {
 		t.Fatalf("JSON parse error: %s", err)
 	}
 }
+
+func TestAPIReportRequireClientCert(t *testing.T) {
+	ts := httptest.NewServer(Router(StaticReport{}, nil, requireClientCert))
+	defer ts.Close()
+
+	res, _ := checkRequest(t, ts, "POST", "/api/report", []byte("report"))
+	equals(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAPIReportWebsocket(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	ts := httptest.NewServer(Router(c, nil))
+	defer ts.Close()
+
+	p, err := xfer.NewWebsocketPublisher(ts.URL, "token", "probe1", nil)
+	ok(t, err)
+	rpt := report.MakeReport()
+	rpt.Endpoint.Nodes["foo"] = report.MakeNode()
+	ok(t, p.PublishEnvelope(xfer.Envelope{Sequence: 1, Report: rpt}))
+	equals(t, 1, len(c.Report().Endpoint.Nodes))
+
+	probes := c.Probes()
+	equals(t, 1, len(probes))
+	equals(t, "probe1", probes[0].ID)
+	equals(t, true, probes[0].Connected)
+	equals(t, false, probes[0].Silent)
+
+	// The app notices as soon as the connection goes away.
+	p.Stop()
+	for deadline := time.Now().Add(time.Second); c.Probes()[0].Connected && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	probes = c.Probes()
+	equals(t, false, probes[0].Connected)
+	equals(t, true, probes[0].Silent)
+}
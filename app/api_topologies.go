@@ -4,7 +4,6 @@ import (
 	"net/http"
 
 	"github.com/weaveworks/scope/render"
-	"github.com/weaveworks/scope/xfer"
 )
 
 // APITopologyDesc is returned in a list by the /api/topology handler.
@@ -22,12 +21,14 @@ type topologyStats struct {
 }
 
 // makeTopologyList returns a handler that yields an APITopologyList.
-func makeTopologyList(rep xfer.Reporter) func(w http.ResponseWriter, r *http.Request) {
+func makeTopologyList(rep reporter) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var (
-			rpt        = rep.Report()
-			topologies = []APITopologyDesc{}
-		)
+		rpt, code, err := reportForRequest(rep, r)
+		if err != nil {
+			respondWith(w, code, err.Error())
+			return
+		}
+		topologies := []APITopologyDesc{}
 		for name, def := range topologyRegistry {
 			if def.parent != "" {
 				continue // subtopology, don't show at top level
@@ -7,7 +7,7 @@ import (
 )
 
 func TestAPITopology(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 
 	body := getRawJSON(t, ts, "/api/topology")
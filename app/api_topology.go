@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -15,8 +16,59 @@ import (
 const (
 	websocketLoop    = 1 * time.Second
 	websocketTimeout = 10 * time.Second
+
+	// defaultReportWindow is the period of time covered by a report from the
+	// past, if the request doesn't specify a window.
+	defaultReportWindow = 15 * time.Second
 )
 
+// reporter yields the current report, and merged reports for ranges of
+// time in the past.
+type reporter interface {
+	xfer.Reporter
+	xfer.RangeReporter
+}
+
+// reportForRequest returns the report that the request wants rendered. By
+// default that's the current report. The at (RFC3339) and window (duration)
+// query parameters select the merged report for the window of time ending at
+// that moment instead.
+func reportForRequest(rep reporter, r *http.Request) (report.Report, int, error) {
+	var (
+		at     = r.URL.Query().Get("at")
+		window = r.URL.Query().Get("window")
+	)
+	if at == "" && window == "" {
+		return rep.Report(), http.StatusOK, nil
+	}
+	return reportAt(rep, at, window)
+}
+
+// reportAt returns the merged report for the window of time ending at the
+// moment given by atStr. An empty atStr means now, and an empty windowStr
+// means the default window.
+func reportAt(rep reporter, atStr, windowStr string) (report.Report, int, error) {
+	at, window := time.Now(), defaultReportWindow
+	if atStr != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, atStr); err != nil {
+			return report.Report{}, http.StatusBadRequest, fmt.Errorf("invalid time %q: %v", atStr, err)
+		}
+	}
+	if windowStr != "" {
+		var err error
+		if window, err = time.ParseDuration(windowStr); err != nil || window <= 0 {
+			return report.Report{}, http.StatusBadRequest, fmt.Errorf("invalid window %q", windowStr)
+		}
+	}
+
+	rpt, err := rep.ReportRange(at.Add(-window), at)
+	if err != nil {
+		return report.Report{}, http.StatusInternalServerError, err
+	}
+	return rpt, http.StatusOK, nil
+}
+
 // APITopology is returned by the /api/topology/{name} handler.
 type APITopology struct {
 	Nodes render.RenderableNodes `json:"nodes"`
@@ -32,15 +84,64 @@ type APIEdge struct {
 	Metadata report.EdgeMetadata `json:"metadata"`
 }
 
+// APITopologyDiff is returned by the /api/topology/{name}/diff handler.
+type APITopologyDiff struct {
+	render.Diff
+	AddedEdges      []render.Edge                      `json:"added_edges"`
+	RemovedEdges    []render.Edge                      `json:"removed_edges"`
+	MetadataChanges map[string][]render.MetadataChange `json:"metadata_changes"`
+}
+
 // Full topology.
-func handleTopology(rep xfer.Reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+func handleTopology(rep reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+	rpt, code, err := reportForRequest(rep, r)
+	if err != nil {
+		respondWith(w, code, err.Error())
+		return
+	}
 	respondWith(w, http.StatusOK, APITopology{
-		Nodes: t.renderer.Render(rep.Report()),
+		Nodes: t.renderer.Render(rpt),
+	})
+}
+
+// Differences in the topology between two points in time. The from (required)
+// and to (default now) query parameters are RFC3339 times, and the window
+// query parameter applies to both of them.
+func handleDiff(rep reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+	var (
+		query  = r.URL.Query()
+		from   = query.Get("from")
+		window = query.Get("window")
+	)
+	if from == "" {
+		respondWith(w, http.StatusBadRequest, "from is required")
+		return
+	}
+	before, code, err := reportAt(rep, from, window)
+	if err != nil {
+		respondWith(w, code, err.Error())
+		return
+	}
+	after, code, err := reportAt(rep, query.Get("to"), window)
+	if err != nil {
+		respondWith(w, code, err.Error())
+		return
+	}
+
+	var (
+		a, b           = t.renderer.Render(before), t.renderer.Render(after)
+		added, removed = render.EdgeDiff(a, b)
+	)
+	respondWith(w, http.StatusOK, APITopologyDiff{
+		Diff:            render.TopoDiff(a, b),
+		AddedEdges:      added,
+		RemovedEdges:    removed,
+		MetadataChanges: render.MetadataDiff(a, b),
 	})
 }
 
 // Websocket for the full topology. This route overlaps with the next.
-func handleWs(rep xfer.Reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+func handleWs(rep reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		respondWith(w, http.StatusInternalServerError, err.Error())
 		return
@@ -57,12 +158,16 @@ func handleWs(rep xfer.Reporter, t topologyView, w http.ResponseWriter, r *http.
 }
 
 // Individual nodes.
-func handleNode(rep xfer.Reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+func handleNode(rep reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+	rpt, code, err := reportForRequest(rep, r)
+	if err != nil {
+		respondWith(w, code, err.Error())
+		return
+	}
 	var (
 		vars     = mux.Vars(r)
 		nodeID   = vars["id"]
-		rpt      = rep.Report()
-		node, ok = t.renderer.Render(rep.Report())[nodeID]
+		node, ok = t.renderer.Render(rpt)[nodeID]
 	)
 	if !ok {
 		http.NotFound(w, r)
@@ -72,12 +177,16 @@ func handleNode(rep xfer.Reporter, t topologyView, w http.ResponseWriter, r *htt
 }
 
 // Individual edges.
-func handleEdge(rep xfer.Reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+func handleEdge(rep reporter, t topologyView, w http.ResponseWriter, r *http.Request) {
+	rpt, code, err := reportForRequest(rep, r)
+	if err != nil {
+		respondWith(w, code, err.Error())
+		return
+	}
 	var (
 		vars     = mux.Vars(r)
 		localID  = vars["local"]
 		remoteID = vars["remote"]
-		rpt      = rep.Report()
 		metadata = t.renderer.EdgeMetadata(rpt, localID, remoteID)
 	)
 
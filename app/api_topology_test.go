@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -14,10 +15,11 @@ import (
 	"github.com/weaveworks/scope/render/expected"
 	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test"
+	"github.com/weaveworks/scope/xfer"
 )
 
 func TestAll(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 
 	body := getRawJSON(t, ts, "/api/topology")
@@ -52,7 +54,7 @@ func TestAll(t *testing.T) {
 }
 
 func TestAPITopologyContainers(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	{
 		body := getRawJSON(t, ts, "/api/topology/containers")
 		var topo APITopology
@@ -67,7 +69,7 @@ func TestAPITopologyContainers(t *testing.T) {
 }
 
 func TestAPITopologyApplications(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 	is404(t, ts, "/api/topology/applications/foobar")
 	{
@@ -98,7 +100,7 @@ func TestAPITopologyApplications(t *testing.T) {
 }
 
 func TestAPITopologyHosts(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 	is404(t, ts, "/api/topology/hosts/foobar")
 	{
@@ -138,9 +140,80 @@ func TestAPITopologyHosts(t *testing.T) {
 	}
 }
 
+func TestAPITopologyAt(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	c.Add(test.Report)
+	ts := httptest.NewServer(Router(c, nil))
+	defer ts.Close()
+
+	getHosts := func(query string) render.RenderableNodes {
+		body := getRawJSON(t, ts, "/api/topology/hosts?"+query)
+		var topo APITopology
+		if err := json.Unmarshal(body, &topo); err != nil {
+			t.Fatal(err)
+		}
+		return topo.Nodes
+	}
+
+	now := time.Now()
+	if want, have := expected.RenderedHosts, expected.Sterilize(getHosts("at="+now.Add(time.Second).Format(time.RFC3339))); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if want, have := 0, len(getHosts("at="+now.Add(-time.Hour).Format(time.RFC3339)+"&window=1m")); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	is200(t, ts, "/api/topology?at="+now.Add(-time.Hour).Format(time.RFC3339))
+	is400(t, ts, "/api/topology/hosts?at=yesterday")
+	is400(t, ts, "/api/topology/hosts?window=-1s")
+}
+
+// historicReport yields an empty report for any range ending before pivot,
+// and the test report otherwise.
+type historicReport struct {
+	StaticReport
+	pivot time.Time
+}
+
+func (h historicReport) ReportRange(from, to time.Time) (report.Report, error) {
+	if to.Before(h.pivot) {
+		return report.MakeReport(), nil
+	}
+	return test.Report, nil
+}
+
+func TestAPITopologyDiff(t *testing.T) {
+	now := time.Now()
+	ts := httptest.NewServer(Router(historicReport{pivot: now.Add(-time.Minute)}, nil))
+	defer ts.Close()
+
+	is400(t, ts, "/api/topology/hosts/diff")
+	is400(t, ts, "/api/topology/hosts/diff?from=yesterday")
+	is404(t, ts, "/api/topology/foobar/diff?from="+now.Format(time.RFC3339))
+
+	body := getRawJSON(t, ts, "/api/topology/hosts/diff?from="+now.Add(-time.Hour).Format(time.RFC3339))
+	var diff APITopologyDiff
+	if err := json.Unmarshal(body, &diff); err != nil {
+		t.Fatal(err)
+	}
+	equals(t, len(expected.RenderedHosts), len(diff.Add))
+	equals(t, 0, len(diff.Update))
+	equals(t, 0, len(diff.Remove))
+	equals(t, []render.Edge{}, diff.RemovedEdges)
+
+	var found bool
+	for _, e := range diff.AddedEdges {
+		if e.Source == expected.ClientHostRenderedID && e.Target == expected.ServerHostRenderedID {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("client -> server host edge missing from added edges: %v", diff.AddedEdges)
+	}
+}
+
 // Basic websocket test
 func TestAPITopologyWebsocket(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 	url := "/api/topology/applications/ws"
 
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+// Roles a token can have. Probe tokens may only publish reports; user tokens
+// may use the UI, the API, and the controls, but not publish. So a token
+// taken from a probe can't be used to exec into containers.
+const (
+	probeRole = "probe"
+	userRole  = "user"
+)
+
+// Verifier checks tokens, presented by probes and by users, and yields the
+// tenant each token belongs to, if the token has the role.
+type Verifier interface {
+	Verify(token, role string) (tenant string, ok bool)
+}
+
+// credential is the tenant and role a token belongs to.
+type credential struct {
+	tenant string
+	role   string
+}
+
+// staticVerifier is a Verifier backed by a fixed map of token to credential.
+type staticVerifier map[string]credential
+
+func (v staticVerifier) Verify(token, role string) (string, bool) {
+	c, ok := v[token]
+	return c.tenant, ok && token != "" && c.role == role
+}
+
+// loadTokenFile reads a Verifier from a file with one "token tenant [role]"
+// line per token. The role is probe or user, and defaults to probe. Blank
+// lines, and lines starting with #, are ignored. A tenant may have many
+// tokens. Tenants name directories, so they can't contain path separators.
+func loadTokenFile(path string) (Verifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		v       = staticVerifier{}
+		scanner = bufio.NewScanner(f)
+		line    = 0
+	)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want \"token tenant [role]\"", path, line)
+		}
+		if tenant := fields[1]; strings.ContainsAny(tenant, `/\`) || tenant == "." || tenant == ".." {
+			return nil, fmt.Errorf("%s:%d: invalid tenant %q", path, line, tenant)
+		}
+		c := credential{tenant: fields[1], role: probeRole}
+		if len(fields) == 3 {
+			c.role = fields[2]
+		}
+		if c.role != probeRole && c.role != userRole {
+			return nil, fmt.Errorf("%s:%d: invalid role %q", path, line, c.role)
+		}
+		v[fields[0]] = c
+	}
+	return v, scanner.Err()
+}
+
+// tokenCookie holds a user's token, once they've logged in to the UI.
+const tokenCookie = "scope_token"
+
+// requestToken returns the token presented with the request. Probes and API
+// clients send it in the Authorization header; browsers send the cookie set
+// when logging in, which they also send with websockets.
+func requestToken(r *http.Request) string {
+	if token, ok := xfer.ParseAuthorizationHeader(r.Header.Get("Authorization")); ok {
+		return token
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// requireToken rejects requests which don't present a token the verifier
+// accepts for the role.
+func requireToken(v Verifier, role string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := v.Verify(requestToken(r), role); !ok {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+}
+
+// denyControls refuses every request. It guards the controls when there's
+// no way to authenticate their callers.
+func denyControls(http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "controls are disabled; start the app with -auth.tokens to enable them", http.StatusForbidden)
+	}
+}
+
+// sameOrigin rejects requests made by browsers on behalf of pages from
+// another origin, i.e. cross-site request forgery.
+func sameOrigin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkSameOrigin(r) {
+			http.Error(w, "cross-origin request refused", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// checkSameOrigin returns true if the request's Origin matches its Host.
+// Browsers send an Origin with every websocket and cross-origin POST, so
+// requests without one come from other clients, and are allowed.
+func checkSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Weave Scope</title></head>
+<body>
+<form method="POST" action="/login">
+{{if .}}<p>{{.}}</p>{{end}}
+<label>Token <input type="password" name="token" autofocus></label>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+// makeLoginHandler serves a form asking for a user token, and sets the token
+// cookie once a valid one is given. The cookie can't be read by scripts, and
+// isn't sent with requests from other sites.
+func makeLoginHandler(v Verifier) http.HandlerFunc {
+	return sameOrigin(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			loginPage.Execute(w, "")
+			return
+		}
+		token := r.PostFormValue("token")
+		if _, ok := v.Verify(token, userRole); !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			loginPage.Execute(w, "Invalid token.")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookie,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+}
+
+// probeRequest returns true if the request is from a probe, publishing a
+// report.
+func probeRequest(r *http.Request) bool {
+	return r.URL.Path == xfer.ReportWebsocketPath || (r.URL.Path == "/api/report" && r.Method == "POST")
+}
+
+// tenantRouter authenticates API requests, and dispatches them to a separate
+// router, with a separate collector, for each tenant. So tenants only ever
+// see the reports sent by their own probes. Probes need a probe token, and
+// everything else a user token. The UI's static resources don't need a
+// token, but users are sent to log in first.
+type tenantRouter struct {
+	mtx        sync.Mutex
+	verifier   Verifier
+	factory    func(tenant string) (collector, error)
+	middleware []func(http.HandlerFunc) http.HandlerFunc
+	routers    map[string]http.Handler
+	static     http.Handler
+	login      http.HandlerFunc
+}
+
+func newTenantRouter(verifier Verifier, factory func(string) (collector, error), middleware ...func(http.HandlerFunc) http.HandlerFunc) *tenantRouter {
+	return &tenantRouter{
+		verifier:   verifier,
+		factory:    factory,
+		middleware: middleware,
+		routers:    map[string]http.Handler{},
+		static:     http.FileServer(FS(false)),
+		login:      makeLoginHandler(verifier),
+	}
+}
+
+func (t *tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/login":
+		t.login(w, r)
+		return
+	case !strings.HasPrefix(r.URL.Path, "/api"):
+		if _, ok := t.verifier.Verify(requestToken(r), userRole); !ok && r.URL.Path == "/" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		t.static.ServeHTTP(w, r)
+		return
+	}
+
+	role := userRole
+	if probeRequest(r) {
+		role = probeRole
+	} else if !checkSameOrigin(r) {
+		// The token may be the cookie, which browsers send for any page.
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	tenant, ok := t.verifier.Verify(requestToken(r), role)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	router, err := t.router(tenant)
+	if err != nil {
+		respondWith(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	router.ServeHTTP(w, r)
+}
+
+func (t *tenantRouter) router(tenant string) (http.Handler, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if router, ok := t.routers[tenant]; ok {
+		return router, nil
+	}
+	c, err := t.factory(tenant)
+	if err != nil {
+		return nil, err
+	}
+	router := Router(c, requireToken(t.verifier, userRole), t.middleware...)
+	t.routers[tenant] = router
+	return router, nil
+}
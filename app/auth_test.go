@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
+)
+
+func TestLoadTokenFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "scope-tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# token tenant [role]\nabc team-a\n\n  def team-a probe\nghi team-b user\n")
+	f.Close()
+
+	v, err := loadTokenFile(f.Name())
+	ok(t, err)
+	for _, tc := range []struct{ token, role, tenant string }{
+		{"abc", probeRole, "team-a"},
+		{"def", probeRole, "team-a"},
+		{"ghi", userRole, "team-b"},
+	} {
+		have, valid := v.Verify(tc.token, tc.role)
+		equals(t, true, valid)
+		equals(t, tc.tenant, have)
+	}
+	for _, tc := range []struct{ token, role string }{
+		{"", probeRole},
+		{"team-a", probeRole},
+		{"#", probeRole},
+		{"abc", userRole}, // probe tokens aren't user tokens
+		{"ghi", probeRole},
+	} {
+		_, valid := v.Verify(tc.token, tc.role)
+		equals(t, false, valid)
+	}
+}
+
+func TestTenantRouter(t *testing.T) {
+	verifier := staticVerifier{
+		"abc": {tenant: "team-a", role: probeRole},
+		"uvw": {tenant: "team-a", role: userRole},
+		"xyz": {tenant: "team-b", role: userRole},
+	}
+	router := newTenantRouter(verifier, func(string) (collector, error) {
+		return xfer.NewCollector(time.Minute), nil
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	do := func(method, path, token string, body []byte, cookies ...*http.Cookie) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+		ok(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", xfer.AuthorizationHeader(token))
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		req.Header.Set("Content-Type", xfer.BinaryContentType)
+		res, err := client.Do(req)
+		ok(t, err)
+		return res
+	}
+	nodes := func(token string) int {
+		res := do("GET", "/api/report", token, nil)
+		defer res.Body.Close()
+		equals(t, http.StatusOK, res.StatusCode)
+		var rpt report.Report
+		ok(t, json.NewDecoder(res.Body).Decode(&rpt))
+		return len(rpt.Endpoint.Nodes)
+	}
+
+	rpt := report.MakeReport()
+	rpt.Endpoint.Nodes["foo"] = report.MakeNode()
+	var buf bytes.Buffer
+	ok(t, xfer.BinaryCodec.Encode(&buf, xfer.Envelope{Report: rpt}))
+
+	// Only probe tokens publish, and only user tokens read.
+	equals(t, http.StatusUnauthorized, do("POST", "/api/report", "", buf.Bytes()).StatusCode)
+	equals(t, http.StatusUnauthorized, do("POST", "/api/report", "uvw", buf.Bytes()).StatusCode)
+	equals(t, http.StatusOK, do("POST", "/api/report", "abc", buf.Bytes()).StatusCode)
+	equals(t, 1, nodes("uvw"))
+	equals(t, 0, nodes("xyz"))
+	equals(t, http.StatusUnauthorized, do("GET", "/api/report", "abc", nil).StatusCode)
+	equals(t, http.StatusUnauthorized, do("GET", "/api/topology", "", nil).StatusCode)
+	equals(t, http.StatusUnauthorized, do("GET", "/api/topology?token=uvw", "", nil).StatusCode)
+
+	// The UI sends users to log in, which sets a cookie with their token.
+	res := do("GET", "/", "", nil)
+	equals(t, http.StatusFound, res.StatusCode)
+	equals(t, "/login", res.Header.Get("Location"))
+	equals(t, http.StatusOK, do("GET", "/login", "", nil).StatusCode)
+
+	login := func(token string) *http.Response {
+		res, err := client.PostForm(ts.URL+"/login", url.Values{"token": {token}})
+		ok(t, err)
+		res.Body.Close()
+		return res
+	}
+	equals(t, http.StatusUnauthorized, login("abc").StatusCode)
+	res = login("uvw")
+	equals(t, http.StatusSeeOther, res.StatusCode)
+	cookies := res.Cookies()
+	equals(t, 1, len(cookies))
+	assert(t, cookies[0].HttpOnly, "want an HttpOnly cookie")
+	equals(t, http.StatusOK, do("GET", "/", "", nil, cookies...).StatusCode)
+	equals(t, http.StatusOK, do("GET", "/api/topology", "", nil, cookies...).StatusCode)
+
+	// Pages from other sites can't use the cookie.
+	req, err := http.NewRequest("GET", ts.URL+"/api/topology", nil)
+	ok(t, err)
+	req.AddCookie(cookies[0])
+	req.Header.Set("Origin", "http://evil.example.com")
+	res, err = client.Do(req)
+	ok(t, err)
+	res.Body.Close()
+	equals(t, http.StatusForbidden, res.StatusCode)
+}
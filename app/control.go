@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
+)
+
+// controlTimeout is how long to wait for a probe to answer a control
+// request.
+const controlTimeout = 30 * time.Second
+
+// controlRouter tracks the probes with persistent connections open, so
+// control requests can be sent to them, and matches up their responses.
+type controlRouter struct {
+	mtx    sync.Mutex
+	probes map[string]*probeConn
+}
+
+// probeConn is the persistent connection from a single probe, the control
+// requests awaiting a response on it, and the pipes open along it.
+type probeConn struct {
+	conn     *websocket.Conn
+	writeMtx sync.Mutex
+	mtx      sync.Mutex
+	nextID   uint64
+	pending  map[uint64]chan xfer.ControlResponse
+	pipes    xfer.Pipes
+	closed   chan struct{} // closed when the connection is gone
+}
+
+func newControlRouter() *controlRouter {
+	return &controlRouter{probes: map[string]*probeConn{}}
+}
+
+// connect registers the probe's connection, replacing any previous one.
+func (cr *controlRouter) connect(probeID string, conn *websocket.Conn) *probeConn {
+	pc := &probeConn{
+		conn:    conn,
+		pending: map[uint64]chan xfer.ControlResponse{},
+		closed:  make(chan struct{}),
+	}
+	cr.mtx.Lock()
+	defer cr.mtx.Unlock()
+	cr.probes[probeID] = pc
+	return pc
+}
+
+// disconnect deregisters the probe's connection, unless it's already been
+// replaced, and fails the requests waiting on it.
+func (cr *controlRouter) disconnect(probeID string, pc *probeConn) {
+	close(pc.closed)
+	cr.mtx.Lock()
+	defer cr.mtx.Unlock()
+	if cr.probes[probeID] == pc {
+		delete(cr.probes, probeID)
+	}
+}
+
+func (cr *controlRouter) probe(probeID string) (*probeConn, bool) {
+	cr.mtx.Lock()
+	defer cr.mtx.Unlock()
+	pc, ok := cr.probes[probeID]
+	return pc, ok
+}
+
+func (pc *probeConn) write(msg xfer.WebsocketMessage) error {
+	pc.writeMtx.Lock()
+	defer pc.writeMtx.Unlock()
+	pc.conn.SetWriteDeadline(time.Now().Add(xfer.WebsocketTimeout))
+	return pc.conn.WriteJSON(msg)
+}
+
+// request sends a control request to the probe, and waits for the response.
+func (pc *probeConn) request(action string, args map[string]string) (xfer.ControlResponse, error) {
+	return pc.send(xfer.ControlRequest{Action: action, Args: args})
+}
+
+// requestPipe sends a control request to the probe which opens a pipe, and
+// waits for the response. If the probe reports an error, the pipe is closed.
+func (pc *probeConn) requestPipe(action string, args map[string]string) (*xfer.Pipe, xfer.ControlResponse, error) {
+	pc.mtx.Lock()
+	pc.nextID++
+	pipeID := strconv.FormatUint(pc.nextID, 10)
+	pc.mtx.Unlock()
+
+	pipe := pc.pipes.Open(pipeID, func(msg xfer.PipeMessage) error {
+		return pc.write(xfer.WebsocketMessage{Pipe: &msg})
+	})
+	res, err := pc.send(xfer.ControlRequest{Action: action, Args: args, PipeID: pipeID})
+	if err != nil || res.Error != "" {
+		pipe.Close()
+		return nil, res, err
+	}
+	return pipe, res, nil
+}
+
+func (pc *probeConn) send(req xfer.ControlRequest) (xfer.ControlResponse, error) {
+	responses := make(chan xfer.ControlResponse, 1)
+	pc.mtx.Lock()
+	pc.nextID++
+	id := pc.nextID
+	pc.pending[id] = responses
+	pc.mtx.Unlock()
+	defer func() {
+		pc.mtx.Lock()
+		delete(pc.pending, id)
+		pc.mtx.Unlock()
+	}()
+
+	req.ID = id
+	if err := pc.write(xfer.WebsocketMessage{Request: &req}); err != nil {
+		return xfer.ControlResponse{}, err
+	}
+	select {
+	case res := <-responses:
+		return res, nil
+	case <-pc.closed:
+		return xfer.ControlResponse{}, errProbeDisconnected
+	case <-time.After(controlTimeout):
+		return xfer.ControlResponse{}, errControlTimeout
+	}
+}
+
+// respond delivers a response from the probe to the waiting request.
+func (pc *probeConn) respond(res xfer.ControlResponse) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	if responses, ok := pc.pending[res.ID]; ok {
+		select {
+		case responses <- res:
+		default:
+		}
+	}
+}
+
+var (
+	errControlTimeout    = errors.New("timeout waiting for probe")
+	errProbeDisconnected = errors.New("probe disconnected")
+)
+
+// pipeUpgrader upgrades the connections for logs and exec, which only the
+// app's own pages may open from a browser.
+var pipeUpgrader = websocket.Upgrader{
+	CheckOrigin: checkSameOrigin,
+}
+
+// makeControlHandler returns a handler which sends the action, with the
+// request's form values as arguments, to the probe, and yields the result.
+func makeControlHandler(cr *controlRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			vars    = mux.Vars(r)
+			probeID = vars["probeID"]
+			action  = vars["action"]
+		)
+		if err := r.ParseForm(); err != nil {
+			respondWith(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		args := map[string]string{}
+		for k := range r.Form {
+			args[k] = r.Form.Get(k)
+		}
+		cr.forward(w, probeID, action, args)
+	}
+}
+
+// containerActions maps the actions on container nodes to the probes'
+// control actions.
+var containerActions = map[string]string{
+	"stop":    docker.StopAction,
+	"start":   docker.StartAction,
+	"restart": docker.RestartAction,
+	"pause":   docker.PauseAction,
+	"unpause": docker.UnpauseAction,
+}
+
+// makeContainerControlHandler returns a handler which performs an action on
+// a container node, by sending it to the probe which reported the container.
+func makeContainerControlHandler(rep reporter, cr *controlRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			vars   = mux.Vars(r)
+			id     = vars["id"]
+			action = vars["action"]
+		)
+		controlAction, ok := containerActions[action]
+		if !ok {
+			respondWith(w, http.StatusNotFound, "unknown action: "+action)
+			return
+		}
+		probeID, ok := containerProbe(rep.Report(), id)
+		if !ok {
+			respondWith(w, http.StatusNotFound, "unknown container: "+id)
+			return
+		}
+		cr.forward(w, probeID, controlAction, map[string]string{docker.ContainerIDArg: id})
+	}
+}
+
+// makeContainerLogsHandler returns a handler which streams a container's
+// logs, from the probe which reported the container, over a websocket.
+func makeContainerLogsHandler(rep reporter, cr *controlRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		probeID, ok := containerProbe(rep.Report(), id)
+		if !ok {
+			respondWith(w, http.StatusNotFound, "unknown container: "+id)
+			return
+		}
+		cr.forwardPipe(w, r, probeID, docker.LogsAction, map[string]string{docker.ContainerIDArg: id}, nil)
+	}
+}
+
+// execResize is sent by clients, as a text message, when their terminal
+// changes size.
+type execResize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// makeContainerExecHandler returns a handler which runs a command (by
+// default, a shell) in a container, with its TTY piped over a websocket.
+// Clients send input as binary messages, and terminal size changes as
+// execResize text messages. The command to run may be given as the cmd
+// query parameter.
+func makeContainerExecHandler(rep reporter, cr *controlRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		probeID, ok := containerProbe(rep.Report(), id)
+		if !ok {
+			respondWith(w, http.StatusNotFound, "unknown container: "+id)
+			return
+		}
+		args := map[string]string{docker.ContainerIDArg: id}
+		if cmd := r.URL.Query().Get("cmd"); cmd != "" {
+			args[docker.CmdArg] = cmd
+		}
+		cr.forwardPipe(w, r, probeID, docker.ExecAction, args, resizeExec)
+	}
+}
+
+func resizeExec(pc *probeConn, res xfer.ControlResponse, msg []byte) {
+	var resize execResize
+	if err := json.Unmarshal(msg, &resize); err != nil {
+		log.Printf("exec: invalid resize: %v", err)
+		return
+	}
+	execID, _ := res.Value.(string)
+	resized, err := pc.request(docker.ResizeExecAction, map[string]string{
+		docker.ExecIDArg: execID,
+		docker.WidthArg:  strconv.Itoa(resize.Width),
+		docker.HeightArg: strconv.Itoa(resize.Height),
+	})
+	if err != nil {
+		log.Printf("exec: resize: %v", err)
+	} else if resized.Error != "" {
+		log.Printf("exec: resize: %s", resized.Error)
+	}
+}
+
+// containerProbe returns the ID of the probe which reported the container.
+func containerProbe(rpt report.Report, containerID string) (string, bool) {
+	for _, n := range rpt.Container.Nodes {
+		if n.Metadata[docker.ContainerID] != containerID {
+			continue
+		}
+		if probeID, ok := n.Metadata[report.ProbeID]; ok {
+			return probeID, true
+		}
+	}
+	return "", false
+}
+
+// forward sends the control request to the probe, and responds with the
+// result.
+func (cr *controlRouter) forward(w http.ResponseWriter, probeID, action string, args map[string]string) {
+	pc, ok := cr.probe(probeID)
+	if !ok {
+		respondWith(w, http.StatusNotFound, "probe not connected: "+probeID)
+		return
+	}
+	res, err := pc.request(action, args)
+	if !controlSucceeded(w, res, err) {
+		return
+	}
+	respondWith(w, http.StatusOK, res.Value)
+}
+
+// forwardPipe upgrades the client's connection to a websocket, sends the
+// control request to the probe, opening a pipe, and then pipes data between
+// the two. The request is only sent once the upgrade has succeeded, so
+// nothing is started on the probe for a client which can't use it; if the
+// request fails, the websocket is closed with the error. Data the client
+// sends is written to the pipe, and data from the probe is sent to the
+// client as binary messages. If onText is set, it's given text messages from
+// the client, along with the probe's response to the request, instead of them
+// being written to the pipe. It's called from a goroutine of its own, and
+// only the latest message is kept while it's busy.
+func (cr *controlRouter) forwardPipe(
+	w http.ResponseWriter,
+	r *http.Request,
+	probeID, action string,
+	args map[string]string,
+	onText func(pc *probeConn, res xfer.ControlResponse, msg []byte),
+) {
+	pc, ok := cr.probe(probeID)
+	if !ok {
+		respondWith(w, http.StatusNotFound, "probe not connected: "+probeID)
+		return
+	}
+	conn, err := pipeUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader has responded
+	}
+	defer conn.Close()
+
+	pipe, res, err := pc.requestPipe(action, args)
+	if err == nil && res.Error != "" {
+		err = errors.New(res.Error)
+	}
+	if err != nil {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()), time.Now().Add(websocketTimeout))
+		return
+	}
+	defer pipe.Close()
+
+	texts := make(chan []byte, 1)
+	if onText != nil {
+		go func() {
+			for msg := range texts {
+				onText(pc, res, msg)
+			}
+		}()
+	}
+	go func() {
+		defer pipe.Close()
+		defer close(texts)
+		for {
+			messageType, buf, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType == websocket.TextMessage && onText != nil {
+				for sent := false; !sent; {
+					select {
+					case texts <- buf:
+						sent = true
+					case <-texts: // superseded
+					}
+				}
+				continue
+			}
+			if _, err := pipe.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := pipe.Read(buf)
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(websocketTimeout))
+			return
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(websocketTimeout)); err != nil {
+			return
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// controlSucceeded responds with an error, and returns false, if the control
+// request failed.
+func controlSucceeded(w http.ResponseWriter, res xfer.ControlResponse, err error) bool {
+	if err == errControlTimeout {
+		respondWith(w, http.StatusGatewayTimeout, err.Error())
+		return false
+	} else if err != nil {
+		respondWith(w, http.StatusBadGateway, err.Error())
+		return false
+	}
+	if res.Error != "" {
+		respondWith(w, http.StatusInternalServerError, res.Error)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
+)
+
+// controlToken is the token testControlAuth accepts.
+const controlToken = "secret"
+
+var testControlAuth = requireToken(staticVerifier{controlToken: {tenant: "tenant", role: userRole}}, userRole)
+
+// controlRequest is checkRequest, presenting controlToken.
+func controlRequest(t *testing.T, ts *httptest.Server, method, path string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	ok(t, err)
+	req.Header.Set("Authorization", xfer.AuthorizationHeader(controlToken))
+	res, err := http.DefaultClient.Do(req)
+	ok(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	ok(t, err)
+	return res, body
+}
+
+// controlHeader returns headers presenting controlToken, from the origin.
+func controlHeader(origin string) http.Header {
+	header := http.Header{}
+	header.Set("Authorization", xfer.AuthorizationHeader(controlToken))
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	return header
+}
+
+func TestAPIControl(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	ts := httptest.NewServer(Router(c, testControlAuth))
+	defer ts.Close()
+
+	res, _ := controlRequest(t, ts, "POST", "/api/control/probe1/echo")
+	equals(t, http.StatusNotFound, res.StatusCode)
+
+	p, err := xfer.NewWebsocketPublisher(ts.URL, "token", "probe1", nil)
+	ok(t, err)
+	defer p.Stop()
+	p.HandleControl(func(req xfer.ControlRequest) xfer.ControlResponse {
+		if req.Action != "echo" {
+			return xfer.ControlResponse{Error: fmt.Sprintf("unknown action %q", req.Action)}
+		}
+		return xfer.ControlResponse{Value: req.Args}
+	})
+	ok(t, p.Publish(report.MakeReport()))
+
+	res, body := controlRequest(t, ts, "POST", "/api/control/probe1/echo?foo=bar")
+	equals(t, http.StatusOK, res.StatusCode)
+	var args map[string]string
+	ok(t, json.Unmarshal(body, &args))
+	equals(t, map[string]string{"foo": "bar"}, args)
+
+	res, _ = controlRequest(t, ts, "POST", "/api/control/probe1/unknown")
+	equals(t, http.StatusInternalServerError, res.StatusCode)
+
+	res, _ = checkRequest(t, ts, "POST", "/api/control/probe1/echo", nil)
+	equals(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestAPIControlDisconnect(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	ts := httptest.NewServer(Router(c, testControlAuth))
+	defer ts.Close()
+
+	p, err := xfer.NewWebsocketPublisher(ts.URL, "token", "probe1", nil)
+	ok(t, err)
+	defer p.Stop()
+	var (
+		received = make(chan struct{}, 1)
+		release  = make(chan struct{})
+	)
+	defer close(release)
+	p.HandleControl(func(req xfer.ControlRequest) xfer.ControlResponse {
+		received <- struct{}{}
+		<-release
+		return xfer.ControlResponse{}
+	})
+	ok(t, p.Publish(report.MakeReport()))
+
+	// A request waiting on a probe which goes away fails straight away.
+	done := make(chan int)
+	go func() {
+		res, _ := controlRequest(t, ts, "POST", "/api/control/probe1/hang")
+		done <- res.StatusCode
+	}()
+	<-received
+	p.Stop()
+	select {
+	case status := <-done:
+		equals(t, http.StatusBadGateway, status)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request still waiting")
+	}
+}
+
+func TestAPIControlRefused(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+
+	// Without a way to authenticate callers, controls are refused.
+	ts := httptest.NewServer(Router(c, nil))
+	defer ts.Close()
+	res, _ := checkRequest(t, ts, "POST", "/api/control/probe1/echo", nil)
+	equals(t, http.StatusForbidden, res.StatusCode)
+
+	// Pages from other origins can't use them, even with a token.
+	authed := httptest.NewServer(Router(c, testControlAuth))
+	defer authed.Close()
+	req, err := http.NewRequest("POST", authed.URL+"/api/control/probe1/echo", nil)
+	ok(t, err)
+	req.Header = controlHeader("http://evil.example.com")
+	res, err = http.DefaultClient.Do(req)
+	ok(t, err)
+	res.Body.Close()
+	equals(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestAPIContainerControl(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	ts := httptest.NewServer(Router(c, testControlAuth))
+	defer ts.Close()
+
+	p, err := xfer.NewWebsocketPublisher(ts.URL, "token", "probe1", nil)
+	ok(t, err)
+	defer p.Stop()
+	var requests []xfer.ControlRequest
+	p.HandleControl(func(req xfer.ControlRequest) xfer.ControlResponse {
+		requests = append(requests, req)
+		return xfer.ControlResponse{}
+	})
+
+	rpt := report.MakeReport()
+	rpt.Container.Nodes[report.MakeContainerNodeID("host1", "abc")] = report.MakeNodeWith(map[string]string{
+		docker.ContainerID: "abc",
+		report.ProbeID:     "probe1",
+	})
+	ok(t, p.Publish(rpt))
+
+	res, _ := checkRequest(t, ts, "POST", "/api/topology/containers/abc/restart", nil)
+	equals(t, http.StatusUnauthorized, res.StatusCode)
+	equals(t, 0, len(requests))
+
+	res, _ = controlRequest(t, ts, "POST", "/api/topology/containers/abc/restart")
+	equals(t, http.StatusOK, res.StatusCode)
+	equals(t, 1, len(requests))
+	equals(t, docker.RestartAction, requests[0].Action)
+	equals(t, map[string]string{docker.ContainerIDArg: "abc"}, requests[0].Args)
+
+	res, _ = controlRequest(t, ts, "POST", "/api/topology/containers/abc/explode")
+	equals(t, http.StatusNotFound, res.StatusCode)
+	res, _ = controlRequest(t, ts, "POST", "/api/topology/containers/def/stop")
+	equals(t, http.StatusNotFound, res.StatusCode)
+
+	// Without a way to authenticate callers, container controls are refused.
+	unauthed := httptest.NewServer(Router(c, nil))
+	defer unauthed.Close()
+	res, _ = checkRequest(t, unauthed, "POST", "/api/topology/containers/abc/stop", nil)
+	equals(t, http.StatusForbidden, res.StatusCode)
+	equals(t, 1, len(requests))
+}
+
+func TestAPIContainerLogs(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	ts := httptest.NewServer(Router(c, testControlAuth))
+	defer ts.Close()
+
+	p, err := xfer.NewWebsocketPublisher(ts.URL, "token", "probe1", nil)
+	ok(t, err)
+	defer p.Stop()
+	p.HandleControl(func(req xfer.ControlRequest) xfer.ControlResponse {
+		if req.Action != docker.LogsAction || req.Pipe == nil {
+			return xfer.ControlResponse{Error: "unexpected request"}
+		}
+		go func() {
+			defer req.Pipe.Close()
+			fmt.Fprintf(req.Pipe, "logs for %s\n", req.Args[docker.ContainerIDArg])
+		}()
+		return xfer.ControlResponse{}
+	})
+
+	rpt := report.MakeReport()
+	rpt.Container.Nodes[report.MakeContainerNodeID("host1", "abc")] = report.MakeNodeWith(map[string]string{
+		docker.ContainerID: "abc",
+		report.ProbeID:     "probe1",
+	})
+	ok(t, p.Publish(rpt))
+
+	res, _ := controlRequest(t, ts, "GET", "/api/topology/containers/def/logs")
+	equals(t, http.StatusNotFound, res.StatusCode)
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/topology/containers/abc/logs"
+	conn, _, err := websocket.DefaultDialer.Dial(url, controlHeader(""))
+	ok(t, err)
+	defer conn.Close()
+	_, buf, err := conn.ReadMessage()
+	ok(t, err)
+	equals(t, "logs for abc\n", string(buf))
+	_, _, err = conn.ReadMessage()
+	assert(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "expected close, got %v", err)
+}
+
+func TestAPIContainerExec(t *testing.T) {
+	c := xfer.NewCollector(time.Minute)
+	ts := httptest.NewServer(Router(c, testControlAuth))
+	defer ts.Close()
+
+	p, err := xfer.NewWebsocketPublisher(ts.URL, "token", "probe1", nil)
+	ok(t, err)
+	defer p.Stop()
+	resized := make(chan map[string]string, 1)
+	execs := make(chan struct{}, 10)
+	p.HandleControl(func(req xfer.ControlRequest) xfer.ControlResponse {
+		switch {
+		case req.Action == docker.ExecAction && req.Pipe != nil:
+			execs <- struct{}{}
+			go func() {
+				defer req.Pipe.Close()
+				io.Copy(req.Pipe, req.Pipe) // like cat
+			}()
+			return xfer.ControlResponse{Value: "exec1"}
+		case req.Action == docker.ResizeExecAction:
+			resized <- req.Args
+			return xfer.ControlResponse{}
+		}
+		return xfer.ControlResponse{Error: "unexpected request"}
+	})
+
+	rpt := report.MakeReport()
+	rpt.Container.Nodes[report.MakeContainerNodeID("host1", "abc")] = report.MakeNodeWith(map[string]string{
+		docker.ContainerID: "abc",
+		report.ProbeID:     "probe1",
+	})
+	ok(t, p.Publish(rpt))
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/topology/containers/abc/exec"
+	for _, tc := range []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{}, http.StatusUnauthorized},
+		{controlHeader("http://evil.example.com"), http.StatusForbidden},
+	} {
+		_, res, err := websocket.DefaultDialer.Dial(url, tc.header)
+		assert(t, err != nil, "%v: expected an error", tc.header)
+		equals(t, tc.status, res.StatusCode)
+	}
+
+	// Without a way to authenticate callers, exec is refused outright.
+	unauthed := httptest.NewServer(Router(c, nil))
+	defer unauthed.Close()
+	_, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(unauthed.URL, "http")+"/api/topology/containers/abc/exec", nil)
+	assert(t, err != nil, "expected an error")
+	equals(t, http.StatusForbidden, res.StatusCode)
+
+	// Nothing is started for a client which can't speak websocket.
+	res, _ = controlRequest(t, ts, "GET", "/api/topology/containers/abc/exec")
+	equals(t, http.StatusBadRequest, res.StatusCode)
+	equals(t, 0, len(execs))
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, controlHeader(ts.URL))
+	ok(t, err)
+	defer conn.Close()
+
+	ok(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"width": 80, "height": 24}`)))
+	select {
+	case args := <-resized:
+		equals(t, map[string]string{
+			docker.ExecIDArg: "exec1",
+			docker.WidthArg:  "80",
+			docker.HeightArg: "24",
+		}, args)
+	case <-time.After(time.Second):
+		t.Fatal("no resize request")
+	}
+
+	ok(t, conn.WriteMessage(websocket.BinaryMessage, []byte("ls\n")))
+	_, buf, err := conn.ReadMessage()
+	ok(t, err)
+	equals(t, "ls\n", string(buf))
+	equals(t, 1, len(execs))
+}
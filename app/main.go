@@ -9,6 +9,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"syscall"
 	"time"
@@ -21,9 +22,18 @@ var version = "dev"
 
 func main() {
 	var (
-		window       = flag.Duration("window", 15*time.Second, "window")
-		listen       = flag.String("http.address", ":"+strconv.Itoa(xfer.AppPort), "webserver listen address")
-		printVersion = flag.Bool("version", false, "print version number and exit")
+		window           = flag.Duration("window", defaultReportWindow, "window")
+		listen           = flag.String("http.address", ":"+strconv.Itoa(xfer.AppPort), "webserver listen address")
+		printVersion     = flag.Bool("version", false, "print version number and exit")
+		historyDir       = flag.String("history.dir", "", "directory to persist reports in, for querying the past (disabled if empty)")
+		historySegment   = flag.Duration("history.segment", 10*time.Minute, "period of time covered by each history segment file")
+		historyRetention = flag.Duration("history.retention", 24*time.Hour, "how long to keep persisted reports (0 for no limit)")
+		historyMaxBytes  = flag.Int64("history.max.bytes", 1<<30, "maximum size of persisted reports, in bytes (0 for no limit)")
+		authTokens       = flag.String("auth.tokens", "", "file of \"token tenant [probe|user]\" lines; if set, probes must present a probe token, and users a user token, and each tenant sees only its own reports")
+		tlsCert          = flag.String("tls.cert", "", "certificate to serve HTTPS with (HTTP if empty)")
+		tlsKey           = flag.String("tls.key", "", "private key for -tls.cert")
+		tlsClientCA      = flag.String("tls.client.ca", "", "CA bundle to verify probes' client certificates with")
+		tlsVerifyProbes  = flag.Bool("tls.verify.probes", false, "only accept reports from probes presenting a certificate signed by -tls.client.ca")
 	)
 	flag.Parse()
 
@@ -36,12 +46,67 @@ func main() {
 	id := strconv.FormatInt(rand.Int63(), 16)
 	log.Printf("app starting, version %s, ID %s", version, id)
 
-	c := xfer.NewCollector(*window)
-	http.Handle("/", Router(c))
+	var stores []xfer.Store
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+	newCollector := func(dir string) (collector, error) {
+		if *historyDir == "" {
+			return xfer.NewCollector(*window), nil
+		}
+		store, err := xfer.NewDiskStore(dir, *historySegment, *historyRetention, *historyMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+		log.Printf("persisting reports to %s", dir)
+		return xfer.NewStoringCollector(*window, store), nil
+	}
+
+	var middleware []func(http.HandlerFunc) http.HandlerFunc
+	if *tlsVerifyProbes {
+		if *tlsCert == "" || *tlsClientCA == "" {
+			log.Fatal("-tls.verify.probes requires -tls.cert and -tls.client.ca")
+		}
+		middleware = append(middleware, requireClientCert)
+	}
+
+	if *authTokens != "" {
+		verifier, err := loadTokenFile(*authTokens)
+		if err != nil {
+			log.Fatalf("failed to load tokens: %v", err)
+		}
+		http.Handle("/", newTenantRouter(verifier, func(tenant string) (collector, error) {
+			return newCollector(filepath.Join(*historyDir, tenant))
+		}, middleware...))
+	} else {
+		c, err := newCollector(*historyDir)
+		if err != nil {
+			log.Fatalf("failed to open history: %v", err)
+		}
+		log.Printf("controls are disabled, as they can't be authenticated without -auth.tokens")
+		http.Handle("/", Router(c, nil, middleware...))
+	}
+
+	server := &http.Server{Addr: *listen}
+	if *tlsCert != "" {
+		tlsConfig, err := xfer.ServerTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			log.Fatalf("failed to load TLS configuration: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+	}
+
 	irq := interrupt()
 	go func() {
 		log.Printf("listening on %s", *listen)
-		log.Print(http.ListenAndServe(*listen, nil))
+		if server.TLSConfig != nil {
+			log.Print(server.ListenAndServeTLS("", ""))
+		} else {
+			log.Print(server.ListenAndServe())
+		}
 		irq <- syscall.SIGINT
 	}()
 	<-irq
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"time"
+
 	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test"
+	"github.com/weaveworks/scope/xfer"
 )
 
 // StaticReport is used as a fixture in tests. It emulates an xfer.Collector.
@@ -10,4 +13,12 @@ type StaticReport struct{}
 
 func (s StaticReport) Report() report.Report { return test.Report }
 
+func (s StaticReport) ReportRange(from, to time.Time) (report.Report, error) { return test.Report, nil }
+
 func (s StaticReport) Add(report.Report) {}
+
+func (s StaticReport) AddEnvelope(xfer.Envelope) (bool, error) { return true, nil }
+
+func (s StaticReport) Probes() []xfer.ProbeInfo { return nil }
+
+func (s StaticReport) SetConnected(string, bool) {}
@@ -10,7 +10,7 @@ import (
 )
 
 func TestAPIOriginHost(t *testing.T) {
-	ts := httptest.NewServer(Router(StaticReport{}))
+	ts := httptest.NewServer(Router(StaticReport{}, nil))
 	defer ts.Close()
 
 	is404(t, ts, "/api/origin/foobar")
@@ -2,17 +2,18 @@ package main
 
 import (
 	"compress/gzip"
-	"encoding/gob"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/ghost/handlers"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 
 	"github.com/weaveworks/scope/render"
-	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/xfer"
 )
 
@@ -48,8 +49,17 @@ func URLMatcher(pattern string) mux.MatcherFunc {
 }
 
 type collector interface {
-	xfer.Reporter
+	reporter
+	prober
 	xfer.Adder
+	streamingAdder
+}
+
+// streamingAdder accepts reports from probes, and tracks which probes have a
+// persistent connection open.
+type streamingAdder interface {
+	xfer.EnvelopeAdder
+	SetConnected(probeID string, connected bool)
 }
 
 func gzipHandler(h http.HandlerFunc) http.HandlerFunc {
@@ -58,29 +68,54 @@ func gzipHandler(h http.HandlerFunc) http.HandlerFunc {
 
 // Router returns the HTTP dispatcher, managing API and UI requests, and
 // accepting reports from probes.. It will always use the embedded HTML
-// resources for the UI.
-func Router(c collector) *mux.Router {
+// resources for the UI. Any middleware is applied, in order, to the handler
+// accepting reports, e.g. to authenticate probes.
+//
+// The controls act on probes, so controlAuth is applied to their handlers,
+// to authenticate the caller. If it's nil, the controls are refused.
+// Browsers may only use them from the app's own pages.
+func Router(c collector, controlAuth func(http.HandlerFunc) http.HandlerFunc, middleware ...func(http.HandlerFunc) http.HandlerFunc) *mux.Router {
+	if controlAuth == nil {
+		controlAuth = denyControls
+	}
+	control := func(h http.HandlerFunc) http.HandlerFunc {
+		return controlAuth(sameOrigin(h))
+	}
+
+	controls := newControlRouter()
+	reportHandler := makeReportPostHandler(c)
+	reportWsHandler := makeReportWsHandler(c, controls)
+	for _, m := range middleware {
+		reportHandler = m(reportHandler)
+		reportWsHandler = m(reportWsHandler)
+	}
+
 	router := mux.NewRouter()
-	router.HandleFunc("/api/report", makeReportPostHandler(c)).Methods("POST")
+	router.HandleFunc("/api/report", reportHandler).Methods("POST")
+	router.HandleFunc(xfer.ReportWebsocketPath, reportWsHandler).Methods("GET")
+	router.HandleFunc("/api/control/{probeID}/{action}", control(makeControlHandler(controls))).Methods("POST")
+	router.HandleFunc("/api/topology/containers/{id}/{action}", control(makeContainerControlHandler(c, controls))).Methods("POST")
+	router.HandleFunc("/api/topology/containers/{id}/logs", control(makeContainerLogsHandler(c, controls))).Methods("GET") // NB not gzip!
+	router.HandleFunc("/api/topology/containers/{id}/exec", control(makeContainerExecHandler(c, controls))).Methods("GET") // NB not gzip!
 
 	get := router.Methods("GET").Subrouter()
 	get.HandleFunc("/api", gzipHandler(apiHandler))
 	get.HandleFunc("/api/topology", gzipHandler(makeTopologyList(c)))
 	get.HandleFunc("/api/topology/{topology}", gzipHandler(captureTopology(c, handleTopology)))
 	get.HandleFunc("/api/topology/{topology}/ws", captureTopology(c, handleWs)) // NB not gzip!
+	get.HandleFunc("/api/topology/{topology}/diff", gzipHandler(captureTopology(c, handleDiff)))
 	get.MatcherFunc(URLMatcher("/api/topology/{topology}/{id}")).HandlerFunc(gzipHandler(captureTopology(c, handleNode)))
 	get.MatcherFunc(URLMatcher("/api/topology/{topology}/{local}/{remote}")).HandlerFunc(gzipHandler(captureTopology(c, handleEdge)))
 	get.MatcherFunc(URLMatcher("/api/origin/host/{id}")).HandlerFunc(gzipHandler(makeOriginHostHandler(c)))
 	get.HandleFunc("/api/report", gzipHandler(makeRawReportHandler(c)))
+	get.HandleFunc("/api/probes", gzipHandler(makeProbesHandler(c)))
 	get.PathPrefix("/").Handler(http.FileServer(FS(false))) // everything else is static
 
 	return router
 }
 
-func makeReportPostHandler(a xfer.Adder) http.HandlerFunc {
+func makeReportPostHandler(a xfer.EnvelopeAdder) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var rpt report.Report
-
 		var reader io.ReadCloser
 		defer func() { reader.Close() }()
 
@@ -94,16 +129,108 @@ func makeReportPostHandler(a xfer.Adder) http.HandlerFunc {
 			}
 		}
 
-		if err := gob.NewDecoder(reader).Decode(&rpt); err != nil {
+		env, err := xfer.DecodeEnvelope(r.Header.Get("Content-Type"), reader)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if env.ProbeID == "" {
+			env.ProbeID = r.Header.Get(xfer.ScopeProbeIDHeader)
+		}
+		if _, err := a.AddEnvelope(env); err == xfer.ErrResync {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		a.Add(rpt)
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func captureTopology(rep xfer.Reporter, f func(xfer.Reporter, topologyView, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+// makeReportWsHandler accepts reports over a persistent websocket connection,
+// as sent by an xfer.WebsocketPublisher. The probe is marked as connected for
+// as long as the connection lasts, so the app notices as soon as it dies.
+// Meanwhile, control requests can be sent to the probe over the connection.
+func makeReportWsHandler(a streamingAdder, controls *controlRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		probeID := r.Header.Get(xfer.ScopeProbeIDHeader)
+		if probeID == "" {
+			http.Error(w, "probe ID required", http.StatusBadRequest)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		a.SetConnected(probeID, true)
+		defer a.SetConnected(probeID, false)
+		pc := controls.connect(probeID, conn)
+		defer controls.disconnect(probeID, pc)
+		defer pc.pipes.CloseAll()
+
+		conn.SetReadDeadline(time.Now().Add(xfer.WebsocketTimeout))
+		conn.SetPingHandler(func(data string) error {
+			conn.SetReadDeadline(time.Now().Add(xfer.WebsocketTimeout))
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(xfer.WebsocketTimeout))
+		})
+		for {
+			messageType, reader, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(xfer.WebsocketTimeout))
+			if messageType == websocket.TextMessage {
+				var msg xfer.WebsocketMessage
+				if err := json.NewDecoder(reader).Decode(&msg); err != nil {
+					return
+				}
+				switch {
+				case msg.Response != nil:
+					pc.respond(*msg.Response)
+				case msg.Pipe != nil:
+					pc.pipes.Deliver(*msg.Pipe)
+				}
+				continue
+			}
+
+			ack := xfer.ReportAck{Status: http.StatusOK}
+			id, env, err := xfer.DecodeReportMessage(reader)
+			ack.ID = id
+			if err == nil {
+				if env.ProbeID == "" {
+					env.ProbeID = probeID
+				}
+				_, err = a.AddEnvelope(env)
+			}
+			if err == xfer.ErrResync {
+				ack.Status, ack.Error = http.StatusConflict, err.Error()
+			} else if err != nil {
+				ack.Status, ack.Error = http.StatusBadRequest, err.Error()
+			}
+
+			if err := pc.write(xfer.WebsocketMessage{Ack: &ack}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// requireClientCert rejects requests that weren't made over TLS with a
+// verified client certificate.
+func requireClientCert(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func captureTopology(rep reporter, f func(reporter, topologyView, http.ResponseWriter, *http.Request)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		topology, ok := topologyRegistry[mux.Vars(r)["topology"]]
 		if !ok {
@@ -129,6 +256,11 @@ var topologyRegistry = map[string]topologyView{
 		parent:   "",
 		renderer: render.FilterUnconnected(render.ProcessWithContainerNameRenderer{}),
 	},
+	"applications-listening": {
+		human:    "with idle servers",
+		parent:   "applications",
+		renderer: render.FilterUnconnectedExceptListening(render.ProcessWithContainerNameRenderer{}),
+	},
 	"applications-by-name": {
 		human:    "by name",
 		parent:   "applications",
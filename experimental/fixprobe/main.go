@@ -34,7 +34,7 @@ func main() {
 	}
 	f.Close()
 
-	publisher, err := xfer.NewHTTPPublisher(*publish, "fixprobe", "fixprobe")
+	publisher, err := xfer.NewHTTPPublisher(*publish, "fixprobe", "fixprobe", nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+// controlRegistry dispatches control requests from apps to the handler for
+// each action.
+type controlRegistry struct {
+	mtx          sync.RWMutex
+	handlers     map[string]xfer.ControlHandler
+	pipeHandlers map[string]xfer.PipeHandler
+}
+
+func newControlRegistry() *controlRegistry {
+	return &controlRegistry{
+		handlers:     map[string]xfer.ControlHandler{},
+		pipeHandlers: map[string]xfer.PipeHandler{},
+	}
+}
+
+// Register adds the handlers for all the controller's actions, replacing any
+// already registered under the same names.
+func (r *controlRegistry) Register(c Controller) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for action, handler := range c.Controls() {
+		r.handlers[action] = handler
+	}
+	if pc, ok := c.(PipeController); ok {
+		for action, handler := range pc.PipeControls() {
+			r.pipeHandlers[action] = handler
+		}
+	}
+}
+
+// Handle performs the requested action. Requests with a pipe go to the
+// action's PipeHandler, and others to its ControlHandler.
+func (r *controlRegistry) Handle(req xfer.ControlRequest) xfer.ControlResponse {
+	r.mtx.RLock()
+	handler, ok := r.handlers[req.Action]
+	pipeHandler, pipeOK := r.pipeHandlers[req.Action]
+	r.mtx.RUnlock()
+
+	if req.Pipe != nil {
+		if !pipeOK {
+			return xfer.ControlResponse{ID: req.ID, Error: fmt.Sprintf("unknown pipe action %q", req.Action)}
+		}
+		value, err := pipeHandler(req.Args, req.Pipe)
+		if err != nil {
+			return xfer.ControlResponse{ID: req.ID, Error: err.Error()}
+		}
+		return xfer.ControlResponse{ID: req.ID, Value: value}
+	}
+
+	if !ok {
+		return xfer.ControlResponse{ID: req.ID, Error: fmt.Sprintf("unknown action %q", req.Action)}
+	}
+	value, err := handler(req.Args)
+	if err != nil {
+		return xfer.ControlResponse{ID: req.ID, Error: err.Error()}
+	}
+	return xfer.ControlResponse{ID: req.ID, Value: value}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+type mockController map[string]xfer.ControlHandler
+
+func (c mockController) Controls() map[string]xfer.ControlHandler { return c }
+
+func TestControlRegistry(t *testing.T) {
+	r := newControlRegistry()
+	r.Register(mockController{
+		"echo": func(args map[string]string) (interface{}, error) { return args["value"], nil },
+		"fail": func(map[string]string) (interface{}, error) { return nil, errors.New("failed") },
+	})
+
+	for _, tc := range []struct {
+		req  xfer.ControlRequest
+		want xfer.ControlResponse
+	}{
+		{
+			xfer.ControlRequest{ID: 1, Action: "echo", Args: map[string]string{"value": "foo"}},
+			xfer.ControlResponse{ID: 1, Value: "foo"},
+		},
+		{
+			xfer.ControlRequest{ID: 2, Action: "fail"},
+			xfer.ControlResponse{ID: 2, Error: "failed"},
+		},
+		{
+			xfer.ControlRequest{ID: 3, Action: "unknown"},
+			xfer.ControlResponse{ID: 3, Error: `unknown action "unknown"`},
+		},
+	} {
+		if have := r.Handle(tc.req); !reflect.DeepEqual(tc.want, have) {
+			t.Errorf("%s: want %v, have %v", tc.req.Action, tc.want, have)
+		}
+	}
+}
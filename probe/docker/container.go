@@ -49,6 +49,12 @@ const (
 	CPUSystemCPUUsage    = "cpu_system_cpu_usage"
 )
 
+// These constants are keys used in node metrics. MemoryUsage is also a
+// metric, in bytes.
+const (
+	CPUUsagePercent = "cpu_usage_percent"
+)
+
 // Exported for testing
 var (
 	DialStub          = net.Dial
@@ -81,11 +87,12 @@ type container struct {
 	container   *docker.Container
 	statsConn   ClientConn
 	latestStats *docker.Stats
+	metrics     report.Metrics
 }
 
 // NewContainer creates a new Container
 func NewContainer(c *docker.Container) Container {
-	return &container{container: c}
+	return &container{container: c, metrics: report.Metrics{}}
 }
 
 func (c *container) ID() string {
@@ -147,6 +154,7 @@ func (c *container) StartGatheringStats() error {
 			log.Printf("docker container: stopped collecting stats for %s", c.container.ID)
 			c.statsConn = nil
 			c.latestStats = nil
+			c.metrics = report.Metrics{}
 		}()
 
 		stats := &docker.Stats{}
@@ -159,6 +167,7 @@ func (c *container) StartGatheringStats() error {
 			}
 
 			c.Lock()
+			c.addMetrics(c.latestStats, stats)
 			c.latestStats = stats
 			c.Unlock()
 
@@ -180,9 +189,29 @@ func (c *container) StopGatheringStats() {
 	c.statsConn.Close()
 	c.statsConn = nil
 	c.latestStats = nil
+	c.metrics = report.Metrics{}
 	return
 }
 
+// addMetrics adds samples from the latest stats to the container's history.
+// CPU usage is only known from the difference between two sets of stats, as
+// a percentage of a single CPU. Must be called with the lock held.
+func (c *container) addMetrics(prev, stats *docker.Stats) {
+	c.metrics[MemoryUsage] = c.metrics[MemoryUsage].Add(stats.Read, float64(stats.MemoryStats.Usage))
+
+	if prev == nil ||
+		stats.CPUStats.CPUUsage.TotalUsage < prev.CPUStats.CPUUsage.TotalUsage ||
+		stats.CPUStats.SystemCPUUsage <= prev.CPUStats.SystemCPUUsage {
+		return
+	}
+	var (
+		cpuDelta    = float64(stats.CPUStats.CPUUsage.TotalUsage - prev.CPUStats.CPUUsage.TotalUsage)
+		systemDelta = float64(stats.CPUStats.SystemCPUUsage - prev.CPUStats.SystemCPUUsage)
+		cpus        = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	)
+	c.metrics[CPUUsagePercent] = c.metrics[CPUUsagePercent].Add(stats.Read, cpuDelta/systemDelta*cpus*100)
+}
+
 func (c *container) ports() string {
 	if c.container.NetworkSettings == nil {
 		return ""
@@ -213,10 +242,10 @@ func (c *container) GetNode() report.Node {
 		ContainerCreated: c.container.Created.Format(time.RFC822),
 		ContainerCommand: c.container.Path + " " + strings.Join(c.container.Args, " "),
 		ImageID:          c.container.Image,
-		ContainerIPs: strings.Join(append(c.container.NetworkSettings.SecondaryIPAddresses,
-			c.container.NetworkSettings.IPAddress), " "),
+		ContainerIPs:     strings.Join(c.ips(), " "),
 	})
 	AddLabels(result, c.container.Config.Labels)
+	result = result.WithMetrics(c.metrics.Copy())
 
 	if c.latestStats == nil {
 		return result
@@ -246,6 +275,25 @@ func (c *container) GetNode() report.Node {
 	return result
 }
 
+// ips returns the container's IPv4 and IPv6 addresses.
+func (c *container) ips() []string {
+	var (
+		settings   = c.container.NetworkSettings
+		candidates = []string{}
+		ips        = []string{}
+	)
+	candidates = append(candidates, settings.SecondaryIPAddresses...)
+	candidates = append(candidates, settings.IPAddress)
+	candidates = append(candidates, settings.SecondaryIPv6Addresses...)
+	candidates = append(candidates, settings.GlobalIPv6Address)
+	for _, ip := range candidates {
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
 // ExtractContainerIPs returns the list of container IPs given a Node from the Container topology.
 func ExtractContainerIPs(nmd report.Node) []string {
 	return strings.Fields(nmd.Metadata[ContainerIPs])
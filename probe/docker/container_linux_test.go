@@ -8,6 +8,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"reflect"
 	"testing"
 	"time"
 
@@ -49,6 +50,10 @@ func TestContainer(t *testing.T) {
 	}
 
 	c := docker.NewContainer(container1)
+	if want, have := []string{"1.2.3.4", "2001:db8::4"}, docker.ExtractContainerIPs(c.GetNode()); !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
 	err := c.StartGatheringStats()
 	if err != nil {
 		t.Errorf("%v", err)
@@ -66,4 +71,30 @@ func TestContainer(t *testing.T) {
 	test.Poll(t, 100*time.Millisecond, "12345", func() interface{} {
 		return c.GetNode().Metadata[docker.MemoryUsage]
 	})
+
+	// CPU usage needs two sets of stats
+	now := time.Now().UTC()
+	stats = &client.Stats{Read: now}
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{0, 0}
+	stats.CPUStats.CPUUsage.TotalUsage = 100
+	stats.CPUStats.SystemCPUUsage = 1000
+	if err = json.NewEncoder(writer).Encode(&stats); err != nil {
+		t.Error(err)
+	}
+	stats = &client.Stats{Read: now.Add(time.Second)}
+	stats.MemoryStats.Usage = 23456
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{0, 0}
+	stats.CPUStats.CPUUsage.TotalUsage = 200
+	stats.CPUStats.SystemCPUUsage = 2000
+	if err = json.NewEncoder(writer).Encode(&stats); err != nil {
+		t.Error(err)
+	}
+
+	test.Poll(t, 100*time.Millisecond, 20.0, func() interface{} {
+		sample, _ := c.GetNode().Metrics[docker.CPUUsagePercent].Last()
+		return sample.Value
+	})
+	if want, have := 3, len(c.GetNode().Metrics[docker.MemoryUsage]); want != have {
+		t.Errorf("want %d memory samples, have %d", want, have)
+	}
 }
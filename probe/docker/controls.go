@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	docker_client "github.com/fsouza/go-dockerclient"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+// Control actions. All but RefreshAction and ResizeExecAction act on a
+// single container, and take its ID as the container_id argument.
+//
+// LogsAction streams the container's logs over a pipe. ExecAction runs a
+// command (by default, a shell) in the container, with a TTY piped to the
+// app; it yields the exec ID, which ResizeExecAction takes as the exec_id
+// argument, along with the new width and height of the TTY.
+const (
+	RefreshAction    = "docker.refresh"
+	StopAction       = "docker.stop"
+	StartAction      = "docker.start"
+	RestartAction    = "docker.restart"
+	PauseAction      = "docker.pause"
+	UnpauseAction    = "docker.unpause"
+	LogsAction       = "docker.logs"
+	ExecAction       = "docker.exec"
+	ResizeExecAction = "docker.resize_exec"
+
+	// Arguments to control actions.
+	ContainerIDArg = "container_id"
+	CmdArg         = "cmd"
+	ExecIDArg      = "exec_id"
+	WidthArg       = "width"
+	HeightArg      = "height"
+
+	// stopTimeout is how long, in seconds, Docker waits for a container to
+	// stop before killing it.
+	stopTimeout = 10
+
+	// logsTail is how many lines of history to send before following a
+	// container's logs.
+	logsTail = "100"
+
+	defaultExecCmd = "/bin/sh"
+)
+
+// Controller performs actions on Docker.
+type Controller struct {
+	registry Registry
+	client   Client
+}
+
+// NewController returns a Controller using the given registry.
+func NewController(registry Registry) (*Controller, error) {
+	client, err := NewDockerClientStub(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Controller{registry: registry, client: client}, nil
+}
+
+// Controls implements Controller.
+func (c *Controller) Controls() map[string]xfer.ControlHandler {
+	return map[string]xfer.ControlHandler{
+		RefreshAction: func(map[string]string) (interface{}, error) {
+			return nil, c.registry.Refresh()
+		},
+		StopAction: c.containerControl(func(id string) error {
+			return c.client.StopContainer(id, stopTimeout)
+		}),
+		StartAction: c.containerControl(func(id string) error {
+			return c.client.StartContainer(id, nil)
+		}),
+		RestartAction: c.containerControl(func(id string) error {
+			return c.client.RestartContainer(id, stopTimeout)
+		}),
+		PauseAction:      c.containerControl(c.client.PauseContainer),
+		UnpauseAction:    c.containerControl(c.client.UnpauseContainer),
+		ResizeExecAction: c.resizeExec,
+	}
+}
+
+// PipeControls implements PipeController.
+func (c *Controller) PipeControls() map[string]xfer.PipeHandler {
+	return map[string]xfer.PipeHandler{
+		LogsAction: c.logs,
+		ExecAction: c.exec,
+	}
+}
+
+// exec runs a command in the container, attached to a TTY, until the command
+// exits, or the app closes the pipe.
+func (c *Controller) exec(args map[string]string, pipe *xfer.Pipe) (interface{}, error) {
+	id, ok := args[ContainerIDArg]
+	if !ok || id == "" {
+		return nil, fmt.Errorf("%s required", ContainerIDArg)
+	}
+	cmd := strings.Fields(args[CmdArg])
+	if len(cmd) == 0 {
+		cmd = []string{defaultExecCmd}
+	}
+	exec, err := c.client.CreateExec(docker_client.CreateExecOptions{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Cmd:          cmd,
+		Container:    id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer pipe.Close()
+		if err := c.client.StartExec(exec.ID, docker_client.StartExecOptions{
+			Tty:          true,
+			RawTerminal:  true,
+			InputStream:  pipe,
+			OutputStream: pipe,
+			ErrorStream:  pipe,
+		}); err != nil {
+			log.Printf("docker exec: %s: %v", id, err)
+		}
+	}()
+	return exec.ID, nil
+}
+
+func (c *Controller) resizeExec(args map[string]string) (interface{}, error) {
+	execID, ok := args[ExecIDArg]
+	if !ok || execID == "" {
+		return nil, fmt.Errorf("%s required", ExecIDArg)
+	}
+	width, err := strconv.Atoi(args[WidthArg])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", WidthArg, args[WidthArg])
+	}
+	height, err := strconv.Atoi(args[HeightArg])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", HeightArg, args[HeightArg])
+	}
+	return nil, c.client.ResizeExecTTY(execID, height, width)
+}
+
+// logs follows the container's logs until the container stops, or the app
+// closes the pipe. Docker gives us no way to stop following logs, so the
+// latter is only noticed when the next line of logs can't be written.
+func (c *Controller) logs(args map[string]string, pipe *xfer.Pipe) (interface{}, error) {
+	id, ok := args[ContainerIDArg]
+	if !ok || id == "" {
+		return nil, fmt.Errorf("%s required", ContainerIDArg)
+	}
+	if _, err := c.client.InspectContainer(id); err != nil {
+		return nil, err
+	}
+	go func() {
+		defer pipe.Close()
+		if err := c.client.Logs(docker_client.LogsOptions{
+			Container:    id,
+			OutputStream: pipe,
+			ErrorStream:  pipe,
+			Follow:       true,
+			Stdout:       true,
+			Stderr:       true,
+			Tail:         logsTail,
+		}); err != nil {
+			log.Printf("docker logs: %s: %v", id, err)
+		}
+	}()
+	return nil, nil
+}
+
+func (c *Controller) containerControl(f func(id string) error) xfer.ControlHandler {
+	return func(args map[string]string) (interface{}, error) {
+		id, ok := args[ContainerIDArg]
+		if !ok || id == "" {
+			return nil, fmt.Errorf("%s required", ContainerIDArg)
+		}
+		return nil, f(id)
+	}
+}
@@ -0,0 +1,133 @@
+package docker_test
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/test"
+	"github.com/weaveworks/scope/xfer"
+)
+
+func TestControls(t *testing.T) {
+	mdc := mockClient // take a copy
+	setupStubs(&mdc, func() {
+		registry, _ := docker.NewRegistry(10 * time.Second)
+		defer registry.Stop()
+
+		controller, err := docker.NewController(registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		controls := controller.Controls()
+		for _, action := range []string{
+			docker.StopAction,
+			docker.StartAction,
+			docker.RestartAction,
+			docker.PauseAction,
+			docker.UnpauseAction,
+		} {
+			if _, err := controls[action](map[string]string{docker.ContainerIDArg: "ping"}); err != nil {
+				t.Errorf("%s: %v", action, err)
+			}
+		}
+		if _, err := controls[docker.StopAction](map[string]string{}); err == nil {
+			t.Error("expected error without container ID")
+		}
+		if _, err := controls[docker.StopAction](map[string]string{docker.ContainerIDArg: "nonexistent"}); err == nil {
+			t.Error("expected error for nonexistent container")
+		}
+
+		mdc.RLock()
+		defer mdc.RUnlock()
+		want := []string{"stop ping", "start ping", "restart ping", "pause ping", "unpause ping"}
+		if !reflect.DeepEqual(want, mdc.actions) {
+			t.Error(test.Diff(want, mdc.actions))
+		}
+	})
+}
+
+func TestLogsControl(t *testing.T) {
+	mdc := mockClient // take a copy
+	setupStubs(&mdc, func() {
+		registry, _ := docker.NewRegistry(10 * time.Second)
+		defer registry.Stop()
+
+		controller, err := docker.NewController(registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			ps   xfer.Pipes
+			sent = make(chan xfer.PipeMessage, 10)
+			pipe = ps.Open("pipe", func(msg xfer.PipeMessage) error { sent <- msg; return nil })
+		)
+		logs := controller.PipeControls()[docker.LogsAction]
+		if _, err := logs(map[string]string{docker.ContainerIDArg: "ping"}, pipe); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := (xfer.PipeMessage{ID: "pipe", Data: []byte("hello from ping\n")}), <-sent; !reflect.DeepEqual(want, have) {
+			t.Error(test.Diff(want, have))
+		}
+		if want, have := (xfer.PipeMessage{ID: "pipe", EOF: true}), <-sent; !reflect.DeepEqual(want, have) {
+			t.Error(test.Diff(want, have))
+		}
+	})
+}
+
+func TestExecControl(t *testing.T) {
+	mdc := mockClient // take a copy
+	setupStubs(&mdc, func() {
+		registry, _ := docker.NewRegistry(10 * time.Second)
+		defer registry.Stop()
+
+		controller, err := docker.NewController(registry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Join the probe's end of the pipe to the app's.
+		var probeEnd, appEnd *xfer.Pipe
+		probeEnd = xfer.NewPipe("pipe", func(msg xfer.PipeMessage) error { appEnd.Deliver(msg); return nil }, nil)
+		appEnd = xfer.NewPipe("pipe", func(msg xfer.PipeMessage) error { probeEnd.Deliver(msg); return nil }, nil)
+
+		exec := controller.PipeControls()[docker.ExecAction]
+		execID, err := exec(map[string]string{docker.ContainerIDArg: "ping", docker.CmdArg: "bash -l"}, probeEnd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "exec-ping", execID; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+
+		resize := controller.Controls()[docker.ResizeExecAction]
+		if _, err := resize(map[string]string{docker.ExecIDArg: "exec-ping", docker.WidthArg: "80", docker.HeightArg: "24"}); err != nil {
+			t.Error(err)
+		}
+		if _, err := resize(map[string]string{docker.ExecIDArg: "exec-ping", docker.WidthArg: "wide"}); err == nil {
+			t.Error("expected error for invalid width")
+		}
+
+		appEnd.Write([]byte("ls\n"))
+		output := make([]byte, 3)
+		if _, err := io.ReadFull(appEnd, output); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := "ls\n", string(output); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+		appEnd.Close()
+
+		mdc.RLock()
+		defer mdc.RUnlock()
+		have := append([]string{}, mdc.actions...)
+		sort.Strings(have)
+		want := []string{"create_exec bash -l ping", "resize_exec 80x24 exec-ping", "start_exec exec-ping"}
+		if !reflect.DeepEqual(want, have) {
+			t.Error(test.Diff(want, have))
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -13,6 +14,8 @@ const (
 	StartEvent = "start"
 	DieEvent   = "die"
 	endpoint   = "unix:///var/run/docker.sock"
+
+	refreshTimeout = 5 * time.Second
 )
 
 // Vars exported for testing.
@@ -24,6 +27,7 @@ var (
 // Registry keeps track of running docker containers and their images
 type Registry interface {
 	Stop()
+	Refresh() error
 	LockedPIDLookup(f func(func(int) Container))
 	WalkContainers(f func(Container))
 	WalkImages(f func(*docker_client.APIImages))
@@ -32,6 +36,7 @@ type Registry interface {
 type registry struct {
 	sync.RWMutex
 	quit     chan chan struct{}
+	refresh  chan chan error
 	interval time.Duration
 	client   Client
 
@@ -47,6 +52,15 @@ type Client interface {
 	ListImages(docker_client.ListImagesOptions) ([]docker_client.APIImages, error)
 	AddEventListener(chan<- *docker_client.APIEvents) error
 	RemoveEventListener(chan *docker_client.APIEvents) error
+	StopContainer(string, uint) error
+	StartContainer(string, *docker_client.HostConfig) error
+	RestartContainer(string, uint) error
+	PauseContainer(string) error
+	UnpauseContainer(string) error
+	Logs(docker_client.LogsOptions) error
+	CreateExec(docker_client.CreateExecOptions) (*docker_client.Exec, error)
+	StartExec(string, docker_client.StartExecOptions) error
+	ResizeExecTTY(string, int, int) error
 }
 
 func newDockerClient(endpoint string) (Client, error) {
@@ -68,6 +82,7 @@ func NewRegistry(interval time.Duration) (Registry, error) {
 		client:   client,
 		interval: interval,
 		quit:     make(chan chan struct{}),
+		refresh:  make(chan chan error),
 	}
 
 	go r.loop()
@@ -81,6 +96,18 @@ func (r *registry) Stop() {
 	<-ch
 }
 
+// Refresh lists all containers and images again, in case the registry has
+// missed any events. It fails if the registry isn't connected to Docker.
+func (r *registry) Refresh() error {
+	ch := make(chan error)
+	select {
+	case r.refresh <- ch:
+		return <-ch
+	case <-time.After(refreshTimeout):
+		return errors.New("not connected to docker")
+	}
+}
+
 func (r *registry) loop() {
 	for {
 		// NB listenForEvents blocks.
@@ -137,6 +164,18 @@ func (r *registry) listenForEvents() bool {
 				return true
 			}
 
+		case ch := <-r.refresh:
+			r.reset()
+			err := r.updateContainers()
+			if err == nil {
+				err = r.updateImages()
+			}
+			ch <- err
+			if err != nil {
+				log.Printf("docker registry: %s", err)
+				return true
+			}
+
 		case ch := <-r.quit:
 			r.Lock()
 			defer r.Unlock()
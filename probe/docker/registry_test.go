@@ -1,9 +1,12 @@
 package docker_test
 
 import (
+	"fmt"
+	"io"
 	"reflect"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -51,6 +54,7 @@ type mockDockerClient struct {
 	containers    map[string]*client.Container
 	apiImages     []client.APIImages
 	events        []chan<- *client.APIEvents
+	actions       []string
 }
 
 func (m *mockDockerClient) ListContainers(client.ListContainersOptions) ([]client.APIContainers, error) {
@@ -89,6 +93,57 @@ func (m *mockDockerClient) RemoveEventListener(events chan *client.APIEvents) er
 	return nil
 }
 
+func (m *mockDockerClient) StopContainer(id string, _ uint) error { return m.do("stop", id) }
+
+func (m *mockDockerClient) StartContainer(id string, _ *client.HostConfig) error {
+	return m.do("start", id)
+}
+
+func (m *mockDockerClient) RestartContainer(id string, _ uint) error { return m.do("restart", id) }
+
+func (m *mockDockerClient) PauseContainer(id string) error { return m.do("pause", id) }
+
+func (m *mockDockerClient) UnpauseContainer(id string) error { return m.do("unpause", id) }
+
+func (m *mockDockerClient) Logs(opts client.LogsOptions) error {
+	if err := m.do("logs", opts.Container); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(opts.OutputStream, "hello from %s\n", opts.Container)
+	return err
+}
+
+func (m *mockDockerClient) CreateExec(opts client.CreateExecOptions) (*client.Exec, error) {
+	if err := m.do("create_exec "+strings.Join(opts.Cmd, " "), opts.Container); err != nil {
+		return nil, err
+	}
+	return &client.Exec{ID: "exec-" + opts.Container}, nil
+}
+
+// StartExec echoes its input, like cat.
+func (m *mockDockerClient) StartExec(id string, opts client.StartExecOptions) error {
+	if err := m.do("start_exec", id); err != nil {
+		return err
+	}
+	_, err := io.Copy(opts.OutputStream, opts.InputStream)
+	return err
+}
+
+func (m *mockDockerClient) ResizeExecTTY(id string, height, width int) error {
+	return m.do(fmt.Sprintf("resize_exec %dx%d", width, height), id)
+}
+
+// do records an action on a container, failing if there is no such container.
+func (m *mockDockerClient) do(action, id string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.containers[strings.TrimPrefix(id, "exec-")]; !ok {
+		return &client.NoSuchContainer{ID: id}
+	}
+	m.actions = append(m.actions, action+" "+id)
+	return nil
+}
+
 func (m *mockDockerClient) send(event *client.APIEvents) {
 	m.RLock()
 	defer m.RUnlock()
@@ -104,7 +159,8 @@ var (
 		Image: "baz",
 		State: client.State{Pid: 1, Running: true},
 		NetworkSettings: &client.NetworkSettings{
-			IPAddress: "1.2.3.4",
+			IPAddress:         "1.2.3.4",
+			GlobalIPv6Address: "2001:db8::4",
 		},
 		Config: &client.Config{
 			Labels: map[string]string{
@@ -251,3 +307,30 @@ func TestRegistryEvents(t *testing.T) {
 		}
 	})
 }
+
+func TestRegistryRefresh(t *testing.T) {
+	mdc := mockClient // take a copy
+	mdc.containers = map[string]*client.Container{"ping": container1}
+	setupStubs(&mdc, func() {
+		registry, _ := docker.NewRegistry(10 * time.Second)
+		defer registry.Stop()
+
+		test.Poll(t, 100*time.Millisecond, []docker.Container{&mockContainer{container1}}, func() interface{} {
+			return allContainers(registry)
+		})
+
+		// Swap the containers without sending any events.
+		mdc.Lock()
+		mdc.apiContainers = []client.APIContainers{{ID: "wiff"}}
+		mdc.containers = map[string]*client.Container{"wiff": container2}
+		mdc.Unlock()
+
+		if err := registry.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		want := []docker.Container{&mockContainer{container2}}
+		if have := allContainers(registry); !reflect.DeepEqual(want, have) {
+			t.Errorf("%s", test.Diff(want, have))
+		}
+	})
+}
@@ -16,13 +16,16 @@ const (
 type Reporter struct {
 	registry Registry
 	scope    string
+	probeID  string
 }
 
-// NewReporter makes a new Reporter
-func NewReporter(registry Registry, scope string) *Reporter {
+// NewReporter makes a new Reporter. Container nodes are tagged with the
+// probe ID, so apps can send control requests about them to the probe.
+func NewReporter(registry Registry, scope, probeID string) *Reporter {
 	return &Reporter{
 		registry: registry,
 		scope:    scope,
+		probeID:  probeID,
 	}
 }
 
@@ -39,7 +42,9 @@ func (r *Reporter) containerTopology() report.Topology {
 
 	r.registry.WalkContainers(func(c Container) {
 		nodeID := report.MakeContainerNodeID(r.scope, c.ID())
-		result.Nodes[nodeID] = c.GetNode()
+		node := c.GetNode()
+		node.Metadata[report.ProbeID] = r.probeID
+		result.Nodes[nodeID] = node
 	})
 
 	return result
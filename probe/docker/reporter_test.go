@@ -18,6 +18,8 @@ type mockRegistry struct {
 
 func (r *mockRegistry) Stop() {}
 
+func (r *mockRegistry) Refresh() error { return nil }
+
 func (r *mockRegistry) LockedPIDLookup(f func(func(int) docker.Container)) {
 	f(func(pid int) docker.Container {
 		return r.containersByPID[pid]
@@ -55,6 +57,7 @@ func TestReporter(t *testing.T) {
 				docker.ContainerID:   "ping",
 				docker.ContainerName: "pong",
 				docker.ImageID:       "baz",
+				report.ProbeID:       "probe",
 			}),
 		},
 	}
@@ -67,7 +70,7 @@ func TestReporter(t *testing.T) {
 		},
 	}
 
-	reporter := docker.NewReporter(mockRegistryInstance, "")
+	reporter := docker.NewReporter(mockRegistryInstance, "", "probe")
 	have, _ := reporter.Report()
 	if !reflect.DeepEqual(want, have) {
 		t.Errorf("%s", test.Diff(want, have))
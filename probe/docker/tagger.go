@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"regexp"
 	"strconv"
 
 	"github.com/weaveworks/scope/probe/process"
@@ -14,13 +15,30 @@ const (
 	Name        = "name"   // TODO this is ambiguous, be more specific
 )
 
+// cgroupContainerID matches the container ID in the cgroups Docker creates,
+// e.g. /docker/<id> with the cgroupfs driver, and
+// /system.slice/docker-<id>.scope with the systemd driver.
+var cgroupContainerID = regexp.MustCompile(`docker[-/]([0-9a-f]{64})(\.scope)?$`)
+
+// ContainerIDFromCgroup returns the ID of the Docker container a process is
+// in, given its cgroup.
+func ContainerIDFromCgroup(cgroup string) (string, bool) {
+	matches := cgroupContainerID.FindStringSubmatch(cgroup)
+	if matches == nil {
+		return "", false
+	}
+	return matches[1], true
+}
+
 // These vars are exported for testing.
 var (
 	NewProcessTreeStub = process.NewTree
 )
 
 // Tagger is a tagger that tags Docker container information to process
-// nodes that have a PID.
+// nodes that have a PID. Processes are attributed to containers by their
+// cgroup where it's known, and otherwise by walking up the process tree to a
+// container's init process.
 type Tagger struct {
 	registry   Registry
 	procWalker process.Walker
@@ -45,7 +63,21 @@ func (t *Tagger) Tag(r report.Report) (report.Report, error) {
 }
 
 func (t *Tagger) tag(tree process.Tree, topology *report.Topology) {
+	containers := map[string]struct{}{}
+	t.registry.WalkContainers(func(c Container) {
+		containers[c.ID()] = struct{}{}
+	})
+
 	for nodeID, nodeMetadata := range topology.Nodes {
+		if id, ok := ContainerIDFromCgroup(nodeMetadata.Metadata[process.Cgroup]); ok {
+			if _, ok := containers[id]; ok {
+				topology.Nodes[nodeID] = nodeMetadata.Merge(report.MakeNodeWith(map[string]string{
+					ContainerID: id,
+				}))
+				continue
+			}
+		}
+
 		pidStr, ok := nodeMetadata.Metadata[process.PID]
 		if !ok {
 			continue
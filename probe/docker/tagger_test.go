@@ -3,8 +3,11 @@ package docker_test
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
+	client "github.com/fsouza/go-dockerclient"
+
 	"github.com/weaveworks/scope/probe/docker"
 	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/report"
@@ -54,3 +57,45 @@ func TestTagger(t *testing.T) {
 		t.Errorf("%s", test.Diff(want, have))
 	}
 }
+
+func TestTaggerCgroup(t *testing.T) {
+	oldProcessTree := docker.NewProcessTreeStub
+	defer func() { docker.NewProcessTreeStub = oldProcessTree }()
+
+	docker.NewProcessTreeStub = func(_ process.Walker) (process.Tree, error) {
+		return &mockProcessTree{map[int]int{}}, nil
+	}
+
+	var (
+		containerID = strings.Repeat("ab", 32)
+		registry    = &mockRegistry{
+			containersByPID: map[int]docker.Container{
+				1: &mockContainer{&client.Container{ID: containerID}},
+			},
+		}
+		systemdNodeID  = report.MakeProcessNodeID("somehost.com", "5")
+		cgroupfsNodeID = report.MakeProcessNodeID("somehost.com", "6")
+		unknownNodeID  = report.MakeProcessNodeID("somehost.com", "7")
+		systemdCgroup  = "/system.slice/docker-" + containerID + ".scope"
+		cgroupfsCgroup = "/docker/" + containerID
+		unknownCgroup  = "/docker/" + strings.Repeat("cd", 32)
+	)
+
+	input := report.MakeReport()
+	input.Process.Nodes[systemdNodeID] = report.MakeNodeWith(map[string]string{"pid": "5", process.Cgroup: systemdCgroup})
+	input.Process.Nodes[cgroupfsNodeID] = report.MakeNodeWith(map[string]string{"pid": "6", process.Cgroup: cgroupfsCgroup})
+	input.Process.Nodes[unknownNodeID] = report.MakeNodeWith(map[string]string{"pid": "7", process.Cgroup: unknownCgroup})
+
+	want := report.MakeReport()
+	want.Process.Nodes[systemdNodeID] = report.MakeNodeWith(map[string]string{"pid": "5", process.Cgroup: systemdCgroup, docker.ContainerID: containerID})
+	want.Process.Nodes[cgroupfsNodeID] = report.MakeNodeWith(map[string]string{"pid": "6", process.Cgroup: cgroupfsCgroup, docker.ContainerID: containerID})
+	want.Process.Nodes[unknownNodeID] = report.MakeNodeWith(map[string]string{"pid": "7", process.Cgroup: unknownCgroup})
+
+	have, err := docker.NewTagger(registry, nil).Tag(input)
+	if err != nil {
+		t.Errorf("%v", err)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("%s", test.Diff(want, have))
+	}
+}
@@ -2,80 +2,133 @@ package endpoint
 
 import (
 	"bufio"
-	"encoding/xml"
-	"fmt"
+	"errors"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 	"sync"
-
-	"github.com/weaveworks/scope/common/exec"
+	"time"
 )
 
 // Constants exported for testing
 const (
-	modules          = "/proc/modules"
-	conntrackModule  = "nf_conntrack"
-	XMLHeader        = "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"
-	ConntrackOpenTag = "<conntrack>\n"
-	TimeWait         = "TIME_WAIT"
-	TCP              = "tcp"
-	New              = "new"
-	Update           = "update"
-	Destroy          = "destroy"
+	modules         = "/proc/modules"
+	conntrackAcct   = "/proc/sys/net/netfilter/nf_conntrack_acct"
+	conntrackModule = "nf_conntrack"
+	TimeWait        = "TIME_WAIT"
+	TCP             = "tcp"
+	UDP             = "udp"
+	New             = "new"
+	Update          = "update"
+	Destroy         = "destroy"
+
+	// Flow status bits, from linux/netfilter/nf_conntrack_common.h
+	IPSSrcNAT = 1 << 4
+	IPSDstNAT = 1 << 5
 )
 
-// Layer3 - these structs are for the parsed conntrack output
+// Layer3 is the addresses of one direction of a flow.
 type Layer3 struct {
-	XMLName xml.Name `xml:"layer3"`
-	SrcIP   string   `xml:"src"`
-	DstIP   string   `xml:"dst"`
+	SrcIP string
+	DstIP string
 }
 
-// Layer4 - these structs are for the parsed conntrack output
+// Layer4 is the ports and protocol of one direction of a flow.
 type Layer4 struct {
-	XMLName xml.Name `xml:"layer4"`
-	SrcPort int      `xml:"sport"`
-	DstPort int      `xml:"dport"`
-	Proto   string   `xml:"protoname,attr"`
+	SrcPort int
+	DstPort int
+	Proto   string
 }
 
-// Meta - these structs are for the parsed conntrack output
+// Meta is one direction of a flow ("original" or "reply"), or the
+// direction-independent state of the flow ("independent").
 type Meta struct {
-	XMLName   xml.Name `xml:"meta"`
-	Direction string   `xml:"direction,attr"`
-	Layer3    Layer3   `xml:"layer3"`
-	Layer4    Layer4   `xml:"layer4"`
-	ID        int64    `xml:"id"`
-	State     string   `xml:"state"`
+	Direction string
+	Layer3    Layer3
+	Layer4    Layer4
+	ID        int64
+	State     string
+	Packets   uint64 // sent in this direction, if accounting is enabled
+	Bytes     uint64
 }
 
-// Flow - these structs are for the parsed conntrack output
+// Flow is a flow in the kernel's connection tracking table, and the event
+// (New, Update or Destroy) it was reported with.
 type Flow struct {
-	XMLName xml.Name `xml:"flow"`
-	Metas   []Meta   `xml:"meta"`
-	Type    string   `xml:"type,attr"`
+	Metas  []Meta
+	Type   string
+	Status uint32
+
+	Original, Reply, Independent *Meta
+}
+
+// ConntrackConn is a connection to the kernel's connection tracking
+// subsystem. It is an interface for mocking.
+type ConntrackConn interface {
+	// Dump returns all the flows in the connection tracking table, as New
+	// flows.
+	Dump() ([]Flow, error)
+
+	// Events blocks until flow events arrive, or a short timeout expires,
+	// and returns them. An error means events may have been lost, and the
+	// connection must be closed.
+	Events() ([]Flow, error)
+
+	Close() error
+}
+
+// DialConntrack opens a connection to the kernel's connection tracking
+// subsystem, subscribed to flow events. It is a hook for mocking.
+var DialConntrack = dialNetlink
 
-	Original, Reply, Independent *Meta `xml:"-"`
+// Backoff bounds for reconnecting to conntrack, and how often to dump the
+// table, to refresh flows' counters (which don't generate events) and catch
+// any events we missed. Exported for testing.
+var (
+	ConntrackInitialBackoff = 1 * time.Second
+	ConntrackMaxBackoff     = 60 * time.Second
+	ConntrackResyncInterval = 10 * time.Second
+)
+
+// UDPPolicy says which UDP flows to track. There is a lot of UDP traffic
+// going on (every container talking to weave dns, for example), so tracking
+// it is optional, and short-lived flows can be ignored.
+type UDPPolicy struct {
+	Track       bool
+	MinDuration time.Duration // flows are only walked once they've lasted this long
 }
 
-// Conntracker uses the conntrack command to track network connections
+// Conntracker uses the kernel's connection tracking table, over netlink,
+// to track network connections.
 type Conntracker struct {
 	sync.Mutex
-	cmd           exec.Cmd
-	activeFlows   map[int64]Flow // active flows in state != TIME_WAIT
-	bufferedFlows []Flow         // flows coming out of activeFlows spend 1 walk cycle here
+	quit          chan struct{}
+	udp           UDPPolicy
+	natOnly       bool
+	activeFlows   map[int64]Flow      // active flows in state != TIME_WAIT
+	firstSeen     map[int64]time.Time // when each active flow was first seen
+	bufferedFlows []Flow              // flows coming out of activeFlows spend 1 walk cycle here
 }
 
-// NewConntracker creates and starts a new Conntracter
-func NewConntracker(args ...string) (*Conntracker, error) {
+// NewConntracker creates and starts a new Conntracter, which tracks TCP
+// flows, and UDP flows according to the policy.
+func NewConntracker(udp UDPPolicy) (*Conntracker, error) {
+	return newConntracker(udp, false)
+}
+
+func newConntracker(udp UDPPolicy, natOnly bool) (*Conntracker, error) {
 	if !ConntrackModulePresent() {
-		return nil, fmt.Errorf("No conntrack module")
+		return nil, errors.New("no conntrack module")
 	}
 	result := &Conntracker{
+		quit:        make(chan struct{}),
+		udp:         udp,
+		natOnly:     natOnly,
 		activeFlows: map[int64]Flow{},
+		firstSeen:   map[int64]time.Time{},
 	}
-	go result.run(args...)
+	go result.loop(DialConntrack, ConntrackInitialBackoff, ConntrackMaxBackoff, ConntrackResyncInterval)
 	return result, nil
 }
 
@@ -103,54 +156,120 @@ var ConntrackModulePresent = func() bool {
 	return false
 }
 
-// NB this is not re-entrant!
-func (c *Conntracker) run(args ...string) {
-	args = append([]string{"-E", "-o", "xml"}, args...)
-	cmd := exec.Command("conntrack", args...)
-	stdout, err := cmd.StdoutPipe()
+// EnableConntrackAccounting turns on conntrack's per-flow packet and byte
+// counters, for flows created from now on. It is made public for mocking.
+var EnableConntrackAccounting = func() error {
+	return ioutil.WriteFile(conntrackAcct, []byte("1\n"), 0644)
+}
+
+// ConntrackAccountingEnabled returns true if conntrack's per-flow packet and
+// byte counters are on. It is made public for mocking.
+var ConntrackAccountingEnabled = func() bool {
+	b, err := ioutil.ReadFile(conntrackAcct)
 	if err != nil {
 		log.Printf("conntrack error: %v", err)
-		return
-	}
-	if err := cmd.Start(); err != nil {
-		log.Printf("conntrack error: %v", err)
-		return
+		return false
 	}
+	return strings.TrimSpace(string(b)) == "1"
+}
 
-	c.Lock()
-	c.cmd = cmd
-	c.Unlock()
-	defer func() {
-		if err := cmd.Wait(); err != nil {
+// loop runs the conntracker, reconnecting with backoff if the connection
+// fails, until it's stopped.
+func (c *Conntracker) loop(dial func() (ConntrackConn, error), initialBackoff, maxBackoff, resyncInterval time.Duration) {
+	backoff := initialBackoff
+	for {
+		if err := c.run(dial, resyncInterval); err != nil {
 			log.Printf("conntrack error: %v", err)
+		} else {
+			backoff = initialBackoff
 		}
-	}()
 
-	// Swallow the first two lines
-	reader := bufio.NewReader(stdout)
-	if line, err := reader.ReadString('\n'); err != nil {
-		log.Printf("conntrack error: %v", err)
-		return
-	} else if line != XMLHeader {
-		log.Printf("conntrack invalid output: '%s'", line)
-		return
+		select {
+		case <-time.After(backoff):
+		case <-c.quit:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
-	if line, err := reader.ReadString('\n'); err != nil {
-		log.Printf("conntrack error: %v", err)
-		return
-	} else if line != ConntrackOpenTag {
-		log.Printf("conntrack invalid output: '%s'", line)
-		return
+}
+
+// run connects to conntrack, and handles flow events, syncing the active
+// flows with the table every resyncInterval, until the connection fails or
+// the conntracker is stopped. Events are read by a goroutine of their own,
+// so a quiet table doesn't hold up the resync.
+func (c *Conntracker) run(dial func() (ConntrackConn, error), resyncInterval time.Duration) error {
+	conn, err := dial()
+	if err != nil {
+		return err
 	}
+	defer conn.Close()
 
-	// Now loop on the output stream
-	decoder := xml.NewDecoder(reader)
+	var (
+		events = make(chan []Flow)
+		errc   = make(chan error, 1)
+		done   = make(chan struct{})
+		wg     sync.WaitGroup
+	)
+	// The reader must have finished with the connection before it's closed.
+	defer wg.Wait()
+	defer close(done)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			flows, err := conn.Events()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if len(flows) == 0 {
+				continue
+			}
+			select {
+			case events <- flows:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// We're subscribed to events before dumping the table, so no flows are
+	// missed in between.
+	resync := func() error {
+		flows, err := conn.Dump()
+		if err != nil {
+			return err
+		}
+		c.sync(flows)
+		return nil
+	}
+	if err := resync(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
 	for {
-		var f Flow
-		if err := decoder.Decode(&f); err != nil {
-			log.Printf("conntrack error: %v", err)
+		select {
+		case <-c.quit:
+			return nil
+		case <-ticker.C:
+			if err := resync(); err != nil {
+				return err
+			}
+		case flows := <-events:
+			for _, f := range flows {
+				c.handleFlow(f)
+			}
+		case err := <-errc:
+			return err
 		}
-		c.handleFlow(f)
 	}
 }
 
@@ -158,20 +277,46 @@ func (c *Conntracker) run(args ...string) {
 func (c *Conntracker) Stop() {
 	c.Lock()
 	defer c.Unlock()
-	if c.cmd == nil {
-		return
+	select {
+	case <-c.quit:
+	default:
+		close(c.quit)
+	}
+}
+
+// sync replaces the active flows with the flows in a dump of the table.
+// Active flows missing from the dump (say, because they were destroyed while
+// we were disconnected, or we missed the event) are finished.
+func (c *Conntracker) sync(flows []Flow) {
+	var (
+		accepted = make([]Flow, 0, len(flows))
+		dumped   = map[int64]struct{}{}
+	)
+	for _, f := range flows {
+		if f = c.accept(f); f.Independent != nil {
+			accepted = append(accepted, f)
+			dumped[f.Independent.ID] = struct{}{}
+		}
 	}
 
-	if p := c.cmd.Process(); p != nil {
-		p.Kill()
+	c.Lock()
+	defer c.Unlock()
+	for id, f := range c.activeFlows {
+		if _, ok := dumped[id]; !ok {
+			c.finish(f)
+		}
+	}
+	for _, f := range accepted {
+		c.update(f)
 	}
 }
 
-func (c *Conntracker) handleFlow(f Flow) {
+// accept finds a flow's metas, which are identified by their Direction,
+// and returns the flow if the conntracker is interested in it, or the zero
+// Flow if not.
+func (c *Conntracker) accept(f Flow) Flow {
 	// A flow consists of 3 'metas' - the 'original' 4 tuple (as seen by this
 	// host) and the 'reply' 4 tuple, which is what it has been rewritten to.
-	// This code finds those metas, which are identified by a Direction
-	// attribute.
 	for i := range f.Metas {
 		meta := &f.Metas[i]
 		switch meta.Direction {
@@ -183,40 +328,87 @@ func (c *Conntracker) handleFlow(f Flow) {
 			f.Independent = meta
 		}
 	}
+	if f.Original == nil || f.Independent == nil {
+		return Flow{}
+	}
 
-	// For not, I'm only interested in tcp connections - there is too much udp
-	// traffic going on (every container talking to weave dns, for example) to
-	// render nicely. TODO: revisit this.
-	if f.Original.Layer4.Proto != TCP {
+	if c.natOnly && f.Status&(IPSSrcNAT|IPSDstNAT) == 0 {
+		return Flow{}
+	}
+
+	switch f.Original.Layer4.Proto {
+	case TCP:
+	case UDP:
+		if !c.udp.Track {
+			return Flow{}
+		}
+	default:
+		return Flow{}
+	}
+	return f
+}
+
+func (c *Conntracker) handleFlow(f Flow) {
+	if f = c.accept(f); f.Independent == nil {
 		return
 	}
 
 	c.Lock()
 	defer c.Unlock()
+	c.update(f)
+}
 
+// update updates the active flows with an accepted flow. Must be called with
+// the lock held.
+func (c *Conntracker) update(f Flow) {
 	switch f.Type {
 	case New, Update:
 		if f.Independent.State != TimeWait {
 			c.activeFlows[f.Independent.ID] = f
+			if _, ok := c.firstSeen[f.Independent.ID]; !ok {
+				c.firstSeen[f.Independent.ID] = time.Now()
+			}
 		} else if _, ok := c.activeFlows[f.Independent.ID]; ok {
-			delete(c.activeFlows, f.Independent.ID)
-			c.bufferedFlows = append(c.bufferedFlows, f)
+			c.finish(f)
 		}
 	case Destroy:
 		if _, ok := c.activeFlows[f.Independent.ID]; ok {
-			delete(c.activeFlows, f.Independent.ID)
-			c.bufferedFlows = append(c.bufferedFlows, f)
+			c.finish(f)
 		}
 	}
 }
 
+// finish moves an active flow to the buffer, so it's walked one last time,
+// unless it's a UDP flow which didn't last long enough to be walked at all.
+// Must be called with the lock held.
+func (c *Conntracker) finish(f Flow) {
+	short := c.tooShort(f, time.Now())
+	delete(c.activeFlows, f.Independent.ID)
+	delete(c.firstSeen, f.Independent.ID)
+	if !short {
+		c.bufferedFlows = append(c.bufferedFlows, f)
+	}
+}
+
+// tooShort says whether the flow is a UDP flow which hasn't yet lasted the
+// policy's minimum duration. Must be called with the lock held.
+func (c *Conntracker) tooShort(f Flow, now time.Time) bool {
+	if f.Original.Layer4.Proto != UDP {
+		return false
+	}
+	return now.Sub(c.firstSeen[f.Independent.ID]) < c.udp.MinDuration
+}
+
 // WalkFlows calls f with all active flows and flows that have come and gone
 // since the last call to WalkFlows
 func (c *Conntracker) WalkFlows(f func(Flow)) {
 	c.Lock()
 	defer c.Unlock()
+	now := time.Now()
 	for _, flow := range c.activeFlows {
-		f(flow)
+		if !c.tooShort(flow, now) {
+			f(flow)
+		}
 	}
 	for _, flow := range c.bufferedFlows {
 		f(flow)
@@ -0,0 +1,9 @@
+package endpoint
+
+import (
+	"errors"
+)
+
+func dialNetlink() (ConntrackConn, error) {
+	return nil, errors.New("conntrack not supported")
+}
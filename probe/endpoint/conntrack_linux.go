@@ -0,0 +1,381 @@
+package endpoint
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Netlink constants, from linux/netfilter/nfnetlink.h and
+// linux/netfilter/nfnetlink_conntrack.h.
+const (
+	nfnlSubsysCTNetlink = 1
+	nfnetlinkV0         = 0
+
+	ipctnlMsgCtNew    = 0
+	ipctnlMsgCtGet    = 1
+	ipctnlMsgCtDelete = 2
+
+	nfnlgrpConntrackNew     = 1
+	nfnlgrpConntrackUpdate  = 2
+	nfnlgrpConntrackDestroy = 3
+
+	ctaTupleOrig     = 1
+	ctaTupleReply    = 2
+	ctaStatus        = 3
+	ctaProtoinfo     = 4
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+	ctaID            = 12
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+
+	ctaCountersPackets   = 1
+	ctaCountersBytes     = 2
+	ctaCounters32Packets = 3
+	ctaCounters32Bytes   = 4
+
+	ctaProtoinfoTCP      = 1
+	ctaProtoinfoTCPState = 1
+
+	nlaHdrLen   = 4
+	nlaAlignTo  = 4
+	nlaTypeMask = 0x3fff // masks off NLA_F_NESTED and NLA_F_NET_BYTEORDER
+
+	nfgenmsgLen = 4
+)
+
+const (
+	conntrackRecvBuffer  = 4 << 20
+	conntrackRecvTimeout = 1 * time.Second
+)
+
+// Netlink headers are in host byte order; attribute payloads are in network
+// byte order.
+var nativeEndian = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// tcpStates are the names of TCP conntrack states, as used by the conntrack
+// command.
+var tcpStates = []string{
+	"NONE",
+	"SYN_SENT",
+	"SYN_RECV",
+	"ESTABLISHED",
+	"FIN_WAIT",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	TimeWait,
+	"CLOSE",
+	"SYN_SENT2",
+}
+
+var protoNames = map[uint8]string{
+	syscall.IPPROTO_ICMP:   "icmp",
+	syscall.IPPROTO_TCP:    TCP,
+	syscall.IPPROTO_UDP:    UDP,
+	syscall.IPPROTO_ICMPV6: "icmpv6",
+}
+
+// netlinkConn is a ConntrackConn using a netlink socket subscribed to
+// conntrack events.
+type netlinkConn struct {
+	fd  int
+	buf []byte
+}
+
+func dialNetlink() (ConntrackConn, error) {
+	groups := uint32(1<<(nfnlgrpConntrackNew-1) | 1<<(nfnlgrpConntrackUpdate-1) | 1<<(nfnlgrpConntrackDestroy-1))
+	fd, err := openNetlink(groups)
+	if err != nil {
+		return nil, err
+	}
+	// We'd rather have a big buffer than lose events, and have to resync.
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, conntrackRecvBuffer); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &netlinkConn{fd: fd, buf: make([]byte, syscall.Getpagesize()*16)}, nil
+}
+
+func openNetlink(groups uint32) (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return -1, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	// Time out receives, so the conntracker can be stopped, and doesn't wait
+	// forever for a dump.
+	tv := syscall.NsecToTimeval(conntrackRecvTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// Dump uses a separate socket, so events aren't interleaved with the dump.
+func (c *netlinkConn) Dump() ([]Flow, error) {
+	fd, err := openNetlink(0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+nfgenmsgLen)
+	nativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	nativeEndian.PutUint16(req[4:6], nfnlSubsysCTNetlink<<8|ipctnlMsgCtGet)
+	nativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	nativeEndian.PutUint32(req[8:12], 1)          // sequence number
+	req[syscall.NLMSG_HDRLEN] = syscall.AF_UNSPEC // all address families
+	req[syscall.NLMSG_HDRLEN+1] = nfnetlinkV0
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var result []Flow
+	buf := make([]byte, len(c.buf))
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		flows, done, err := parseConntrackMessages(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, flows...)
+		if done {
+			return result, nil
+		}
+	}
+}
+
+func (c *netlinkConn) Events() ([]Flow, error) {
+	n, _, err := syscall.Recvfrom(c.fd, c.buf, 0)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return nil, nil
+	} else if err != nil {
+		return nil, err // ENOBUFS means we've lost events
+	}
+	flows, _, err := parseConntrackMessages(c.buf[:n])
+	return flows, err
+}
+
+func (c *netlinkConn) Close() error {
+	return syscall.Close(c.fd)
+}
+
+// ParseConntrackMessages parses conntrack netlink messages into flows. It is
+// exported for testing.
+func ParseConntrackMessages(b []byte) ([]Flow, error) {
+	flows, _, err := parseConntrackMessages(b)
+	return flows, err
+}
+
+// parseConntrackMessages parses conntrack netlink messages into flows, and
+// says whether the end of a dump has been reached.
+func parseConntrackMessages(b []byte) ([]Flow, bool, error) {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return nil, false, err
+	}
+	var flows []Flow
+	for _, msg := range msgs {
+		switch msg.Header.Type {
+		case syscall.NLMSG_DONE:
+			return flows, true, nil
+		case syscall.NLMSG_ERROR:
+			if len(msg.Data) < 4 {
+				return nil, false, fmt.Errorf("conntrack: short error message")
+			}
+			if errno := int32(nativeEndian.Uint32(msg.Data[0:4])); errno != 0 {
+				return nil, false, syscall.Errno(-errno)
+			}
+			continue
+		}
+		if msg.Header.Type>>8 != nfnlSubsysCTNetlink {
+			continue
+		}
+
+		var ty string
+		switch msg.Header.Type & 0xff {
+		case ipctnlMsgCtNew:
+			// Events about new flows are flagged as creating them; dumped
+			// flows are parts of a multipart message.
+			ty = Update
+			if msg.Header.Flags&(syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_MULTI) != 0 {
+				ty = New
+			}
+		case ipctnlMsgCtDelete:
+			ty = Destroy
+		default:
+			continue
+		}
+
+		if len(msg.Data) < nfgenmsgLen {
+			return nil, false, fmt.Errorf("conntrack: short message")
+		}
+		f, err := parseFlow(msg.Data[nfgenmsgLen:])
+		if err != nil {
+			return nil, false, err
+		}
+		f.Type = ty
+		flows = append(flows, f)
+	}
+	return flows, false, nil
+}
+
+func parseFlow(b []byte) (Flow, error) {
+	var (
+		original    = Meta{Direction: "original"}
+		reply       = Meta{Direction: "reply"}
+		independent = Meta{Direction: "independent"}
+		f           Flow
+	)
+	err := walkAttrs(b, func(ty uint16, data []byte) error {
+		switch ty {
+		case ctaTupleOrig:
+			return parseTuple(data, &original)
+		case ctaTupleReply:
+			return parseTuple(data, &reply)
+		case ctaCountersOrig:
+			return parseCounters(data, &original)
+		case ctaCountersReply:
+			return parseCounters(data, &reply)
+		case ctaStatus:
+			if len(data) < 4 {
+				return fmt.Errorf("conntrack: short status")
+			}
+			f.Status = binary.BigEndian.Uint32(data)
+		case ctaID:
+			if len(data) < 4 {
+				return fmt.Errorf("conntrack: short id")
+			}
+			independent.ID = int64(binary.BigEndian.Uint32(data))
+		case ctaProtoinfo:
+			return walkAttrs(data, func(ty uint16, data []byte) error {
+				if ty != ctaProtoinfoTCP {
+					return nil
+				}
+				return walkAttrs(data, func(ty uint16, data []byte) error {
+					if ty == ctaProtoinfoTCPState && len(data) > 0 && int(data[0]) < len(tcpStates) {
+						independent.State = tcpStates[data[0]]
+					}
+					return nil
+				})
+			})
+		}
+		return nil
+	})
+	f.Metas = []Meta{original, reply, independent}
+	return f, err
+}
+
+func parseTuple(b []byte, meta *Meta) error {
+	return walkAttrs(b, func(ty uint16, data []byte) error {
+		switch ty {
+		case ctaTupleIP:
+			return walkAttrs(data, func(ty uint16, data []byte) error {
+				switch ty {
+				case ctaIPv4Src, ctaIPv6Src:
+					meta.Layer3.SrcIP = net.IP(data).String()
+				case ctaIPv4Dst, ctaIPv6Dst:
+					meta.Layer3.DstIP = net.IP(data).String()
+				}
+				return nil
+			})
+		case ctaTupleProto:
+			return walkAttrs(data, func(ty uint16, data []byte) error {
+				switch ty {
+				case ctaProtoNum:
+					if len(data) < 1 {
+						return fmt.Errorf("conntrack: short protocol")
+					}
+					name, ok := protoNames[data[0]]
+					if !ok {
+						name = strconv.Itoa(int(data[0]))
+					}
+					meta.Layer4.Proto = name
+				case ctaProtoSrcPort, ctaProtoDstPort:
+					if len(data) < 2 {
+						return fmt.Errorf("conntrack: short port")
+					}
+					port := int(binary.BigEndian.Uint16(data))
+					if ty == ctaProtoSrcPort {
+						meta.Layer4.SrcPort = port
+					} else {
+						meta.Layer4.DstPort = port
+					}
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+func parseCounters(b []byte, meta *Meta) error {
+	return walkAttrs(b, func(ty uint16, data []byte) error {
+		var value uint64
+		switch {
+		case (ty == ctaCountersPackets || ty == ctaCountersBytes) && len(data) >= 8:
+			value = binary.BigEndian.Uint64(data)
+		case (ty == ctaCounters32Packets || ty == ctaCounters32Bytes) && len(data) >= 4:
+			value = uint64(binary.BigEndian.Uint32(data))
+		default:
+			return nil
+		}
+		switch ty {
+		case ctaCountersPackets, ctaCounters32Packets:
+			meta.Packets = value
+		case ctaCountersBytes, ctaCounters32Bytes:
+			meta.Bytes = value
+		}
+		return nil
+	})
+}
+
+// walkAttrs calls f with the type and data of each netlink attribute in b.
+func walkAttrs(b []byte, f func(ty uint16, data []byte) error) error {
+	for len(b) >= nlaHdrLen {
+		var (
+			length = int(nativeEndian.Uint16(b[0:2]))
+			ty     = nativeEndian.Uint16(b[2:4]) & nlaTypeMask
+		)
+		if length < nlaHdrLen || length > len(b) {
+			return fmt.Errorf("conntrack: invalid attribute length %d", length)
+		}
+		if err := f(ty, b[nlaHdrLen:length]); err != nil {
+			return err
+		}
+		if aligned := (length + nlaAlignTo - 1) &^ (nlaAlignTo - 1); aligned < len(b) {
+			b = b[aligned:]
+		} else {
+			break
+		}
+	}
+	return nil
+}
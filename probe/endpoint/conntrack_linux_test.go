@@ -0,0 +1,142 @@
+package endpoint_test
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/weaveworks/scope/probe/endpoint"
+	"github.com/weaveworks/scope/test"
+)
+
+// Netlink headers are in host byte order.
+func putNative16(b []byte, v uint16) { *(*uint16)(unsafe.Pointer(&b[0])) = v }
+func putNative32(b []byte, v uint32) { *(*uint32)(unsafe.Pointer(&b[0])) = v }
+
+func attr(ty uint16, data ...[]byte) []byte {
+	var payload []byte
+	for _, d := range data {
+		payload = append(payload, d...)
+	}
+	b := make([]byte, 4, 4+len(payload)+3)
+	putNative16(b[0:2], uint16(4+len(payload)))
+	putNative16(b[2:4], ty)
+	b = append(b, payload...)
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func nested(ty uint16, data ...[]byte) []byte {
+	return attr(ty|0x8000, data...)
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func tuple(ty uint16, src, dst string, proto uint8, sport, dport uint16) []byte {
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	ipAttrs := nested(1, attr(3, srcIP.To16()), attr(4, dstIP.To16()))
+	if srcIP.To4() != nil {
+		ipAttrs = nested(1, attr(1, srcIP.To4()), attr(2, dstIP.To4()))
+	}
+	return nested(ty,
+		ipAttrs,
+		nested(2, attr(1, []byte{proto}), attr(2, be16(sport)), attr(3, be16(dport))),
+	)
+}
+
+func message(msgType, flags uint16, attrs ...[]byte) []byte {
+	b := make([]byte, syscall.NLMSG_HDRLEN+4)
+	b[syscall.NLMSG_HDRLEN] = syscall.AF_INET
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	putNative32(b[0:4], uint32(len(b)))
+	putNative16(b[4:6], 1<<8|msgType)
+	putNative16(b[6:8], flags)
+	return b
+}
+
+func TestParseConntrackMessages(t *testing.T) {
+	var msgs []byte
+	msgs = append(msgs, message(0, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL,
+		tuple(1, "10.0.0.1", "10.0.0.2", syscall.IPPROTO_TCP, 34567, 80),
+		tuple(2, "10.0.0.2", "192.168.0.1", syscall.IPPROTO_TCP, 80, 34567),
+		attr(3, be32(endpoint.IPSDstNAT)),
+		nested(4, nested(1, attr(1, []byte{3}))),
+		nested(9, attr(1, be64(10)), attr(2, be64(1000))),
+		nested(10, attr(1, be64(5)), attr(2, be64(500))),
+		attr(12, be32(42)),
+	)...)
+	msgs = append(msgs, message(0, 0,
+		tuple(1, "fe80::1", "fe80::2", syscall.IPPROTO_UDP, 5353, 53),
+		tuple(2, "fe80::2", "fe80::1", syscall.IPPROTO_UDP, 53, 5353),
+		nested(9, attr(3, be32(1)), attr(4, be32(64))),
+		attr(12, be32(43)),
+	)...)
+	msgs = append(msgs, message(2, 0,
+		tuple(1, "10.0.0.1", "10.0.0.2", syscall.IPPROTO_TCP, 34567, 80),
+		tuple(2, "10.0.0.2", "10.0.0.1", syscall.IPPROTO_TCP, 80, 34567),
+		nested(4, nested(1, attr(1, []byte{7}))),
+		attr(12, be32(44)),
+	)...)
+
+	have, err := endpoint.ParseConntrackMessages(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []endpoint.Flow{
+		{
+			Type:   endpoint.New,
+			Status: endpoint.IPSDstNAT,
+			Metas: []endpoint.Meta{
+				{Direction: "original", Layer3: endpoint.Layer3{SrcIP: "10.0.0.1", DstIP: "10.0.0.2"}, Layer4: endpoint.Layer4{SrcPort: 34567, DstPort: 80, Proto: endpoint.TCP}, Packets: 10, Bytes: 1000},
+				{Direction: "reply", Layer3: endpoint.Layer3{SrcIP: "10.0.0.2", DstIP: "192.168.0.1"}, Layer4: endpoint.Layer4{SrcPort: 80, DstPort: 34567, Proto: endpoint.TCP}, Packets: 5, Bytes: 500},
+				{Direction: "independent", ID: 42, State: "ESTABLISHED"},
+			},
+		},
+		{
+			Type: endpoint.Update,
+			Metas: []endpoint.Meta{
+				{Direction: "original", Layer3: endpoint.Layer3{SrcIP: "fe80::1", DstIP: "fe80::2"}, Layer4: endpoint.Layer4{SrcPort: 5353, DstPort: 53, Proto: endpoint.UDP}, Packets: 1, Bytes: 64},
+				{Direction: "reply", Layer3: endpoint.Layer3{SrcIP: "fe80::2", DstIP: "fe80::1"}, Layer4: endpoint.Layer4{SrcPort: 53, DstPort: 5353, Proto: endpoint.UDP}},
+				{Direction: "independent", ID: 43},
+			},
+		},
+		{
+			Type: endpoint.Destroy,
+			Metas: []endpoint.Meta{
+				{Direction: "original", Layer3: endpoint.Layer3{SrcIP: "10.0.0.1", DstIP: "10.0.0.2"}, Layer4: endpoint.Layer4{SrcPort: 34567, DstPort: 80, Proto: endpoint.TCP}},
+				{Direction: "reply", Layer3: endpoint.Layer3{SrcIP: "10.0.0.2", DstIP: "10.0.0.1"}, Layer4: endpoint.Layer4{SrcPort: 80, DstPort: 34567, Proto: endpoint.TCP}},
+				{Direction: "independent", ID: 44, State: endpoint.TimeWait},
+			},
+		},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	if _, err := endpoint.ParseConntrackMessages(message(0, 0, []byte{0xff, 0, 1, 0})); err == nil {
+		t.Error("expected an error for an invalid attribute")
+	}
+}
@@ -1,98 +1,104 @@
 package endpoint_test
 
 import (
-	"bufio"
-	"encoding/xml"
-	"io"
+	"errors"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/weaveworks/scope/common/exec"
 	. "github.com/weaveworks/scope/probe/endpoint"
 	"github.com/weaveworks/scope/test"
-	testExec "github.com/weaveworks/scope/test/exec"
 )
 
 func makeFlow(id int64, srcIP, dstIP string, srcPort, dstPort int, ty, state string) Flow {
 	return Flow{
-		XMLName: xml.Name{
-			Local: "flow",
-		},
 		Type: ty,
 		Metas: []Meta{
 			{
-				XMLName: xml.Name{
-					Local: "meta",
-				},
 				Direction: "original",
 				Layer3: Layer3{
-					XMLName: xml.Name{
-						Local: "layer3",
-					},
 					SrcIP: srcIP,
 					DstIP: dstIP,
 				},
 				Layer4: Layer4{
-					XMLName: xml.Name{
-						Local: "layer4",
-					},
 					SrcPort: srcPort,
 					DstPort: dstPort,
 					Proto:   TCP,
 				},
 			},
 			{
-				XMLName: xml.Name{
-					Local: "meta",
-				},
 				Direction: "independent",
 				ID:        id,
 				State:     state,
-				Layer3: Layer3{
-					XMLName: xml.Name{
-						Local: "layer3",
-					},
-				},
-				Layer4: Layer4{
-					XMLName: xml.Name{
-						Local: "layer4",
-					},
-				},
 			},
 		},
 	}
 }
 
-func TestConntracker(t *testing.T) {
-	oldExecCmd, oldConntrackPresent := exec.Command, ConntrackModulePresent
-	defer func() { exec.Command, ConntrackModulePresent = oldExecCmd, oldConntrackPresent }()
+// mockConntrackConn yields its dump, and the flows sent on events, until
+// events is closed, when it fails. Waiting for events times out after wait.
+type mockConntrackConn struct {
+	dump   func() []Flow
+	events chan []Flow
+	wait   time.Duration
+}
 
-	ConntrackModulePresent = func() bool {
-		return true
+func newMockConntrackConn(dump ...Flow) *mockConntrackConn {
+	return &mockConntrackConn{
+		dump:   func() []Flow { return dump },
+		events: make(chan []Flow),
+		wait:   10 * time.Millisecond,
 	}
+}
 
-	reader, writer := io.Pipe()
-	exec.Command = func(name string, args ...string) exec.Cmd {
-		return testExec.NewMockCmd(reader)
-	}
+func (c *mockConntrackConn) Dump() ([]Flow, error) {
+	return c.dump(), nil
+}
 
-	conntracker, err := NewConntracker()
-	if err != nil {
-		t.Fatal(err)
+func (c *mockConntrackConn) Events() ([]Flow, error) {
+	select {
+	case flows, ok := <-c.events:
+		if !ok {
+			return nil, errors.New("connection lost")
+		}
+		return flows, nil
+	case <-time.After(c.wait):
+		return nil, nil
 	}
+}
 
-	bw := bufio.NewWriter(writer)
-	if _, err := bw.WriteString(XMLHeader); err != nil {
-		t.Fatal(err)
+func (c *mockConntrackConn) Close() error {
+	return nil
+}
+
+// mockConntrack makes DialConntrack yield the given connections, in order,
+// and returns a function to undo the mocking.
+func mockConntrack(conns ...*mockConntrackConn) func() {
+	oldDial, oldConntrackPresent, oldBackoff := DialConntrack, ConntrackModulePresent, ConntrackInitialBackoff
+
+	var mtx sync.Mutex
+	DialConntrack = func() (ConntrackConn, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if len(conns) == 0 {
+			return nil, errors.New("no more connections")
+		}
+		conn := conns[0]
+		conns = conns[1:]
+		return conn, nil
 	}
-	if _, err := bw.WriteString(ConntrackOpenTag); err != nil {
-		t.Fatal(err)
+	ConntrackModulePresent = func() bool {
+		return true
 	}
-	if err := bw.Flush(); err != nil {
-		t.Fatal(err)
+	ConntrackInitialBackoff = time.Millisecond
+
+	return func() {
+		DialConntrack, ConntrackModulePresent, ConntrackInitialBackoff = oldDial, oldConntrackPresent, oldBackoff
 	}
+}
 
-	have := func() interface{} {
+func walkFlows(conntracker *Conntracker) func() interface{} {
+	return func() interface{} {
 		result := []Flow{}
 		conntracker.WalkFlows(func(f Flow) {
 			f.Original = nil
@@ -102,42 +108,149 @@ func TestConntracker(t *testing.T) {
 		})
 		return result
 	}
+}
+
+func TestConntracker(t *testing.T) {
+	conn := newMockConntrackConn()
+	defer mockConntrack(conn)()
+
+	conntracker, err := NewConntracker(UDPPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conntracker.Stop()
+
+	have := walkFlows(conntracker)
 	ts := 100 * time.Millisecond
 
 	// First, assert we have no flows
 	test.Poll(t, ts, []Flow{}, have)
 
 	// Now add some flows
-	xmlEncoder := xml.NewEncoder(bw)
-	writeFlow := func(f Flow) {
-		if err := xmlEncoder.Encode(f); err != nil {
-			t.Fatal(err)
-		}
-		if _, err := bw.WriteString("\n"); err != nil {
-			t.Fatal(err)
-		}
-		if err := bw.Flush(); err != nil {
-			t.Fatal(err)
-		}
-	}
-
 	flow1 := makeFlow(1, "1.2.3.4", "2.3.4.5", 2, 3, New, "")
-	writeFlow(flow1)
+	conn.events <- []Flow{flow1}
 	test.Poll(t, ts, []Flow{flow1}, have)
 
 	// Now check when we remove the flow, we still get it in the next Walk
 	flow1.Type = Destroy
-	writeFlow(flow1)
+	conn.events <- []Flow{flow1}
 	test.Poll(t, ts, []Flow{flow1}, have)
 	test.Poll(t, ts, []Flow{}, have)
 
 	// This time we're not going to remove it, but put it in state TIME_WAIT
 	flow1.Type = New
-	writeFlow(flow1)
+	conn.events <- []Flow{flow1}
 	test.Poll(t, ts, []Flow{flow1}, have)
 
 	flow1.Metas[1].State = TimeWait
-	writeFlow(flow1)
+	conn.events <- []Flow{flow1}
 	test.Poll(t, ts, []Flow{flow1}, have)
 	test.Poll(t, ts, []Flow{}, have)
 }
+
+func TestConntrackerResync(t *testing.T) {
+	var (
+		flow1 = makeFlow(1, "1.2.3.4", "2.3.4.5", 2, 3, New, "")
+		flow2 = makeFlow(2, "1.2.3.4", "2.3.4.5", 4, 5, New, "")
+		conn1 = newMockConntrackConn(flow1)
+		conn2 = newMockConntrackConn(flow2)
+	)
+	defer mockConntrack(conn1, conn2)()
+
+	conntracker, err := NewConntracker(UDPPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conntracker.Stop()
+
+	have := walkFlows(conntracker)
+	ts := 100 * time.Millisecond
+
+	// The initial dump's flows are active
+	test.Poll(t, ts, []Flow{flow1}, have)
+
+	// When the connection fails, we reconnect, and flows missing from the
+	// new dump are walked one last time.
+	close(conn1.events)
+	test.Poll(t, ts, 2, func() interface{} { return len(have().([]Flow)) })
+	test.Poll(t, ts, []Flow{flow2}, have)
+}
+
+func TestConntrackerResyncQuiet(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		flow1 = makeFlow(1, "1.2.3.4", "2.3.4.5", 2, 3, New, "")
+		flow2 = makeFlow(2, "1.2.3.4", "2.3.4.5", 4, 5, New, "")
+		table = []Flow{flow1}
+		conn  = newMockConntrackConn()
+	)
+	conn.dump = func() []Flow {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return table
+	}
+	conn.wait = time.Second // much longer than the polls below
+	defer mockConntrack(conn)()
+	oldResyncInterval := ConntrackResyncInterval
+	defer func() { ConntrackResyncInterval = oldResyncInterval }()
+	ConntrackResyncInterval = time.Millisecond
+
+	conntracker, err := NewConntracker(UDPPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conntracker.Stop()
+
+	have := walkFlows(conntracker)
+	ts := 100 * time.Millisecond
+	test.Poll(t, ts, []Flow{flow1}, have)
+
+	// The table is resynced while waiting for events.
+	mtx.Lock()
+	table = []Flow{flow2}
+	mtx.Unlock()
+	test.Poll(t, ts, 2, func() interface{} { return len(have().([]Flow)) })
+	test.Poll(t, ts, []Flow{flow2}, have)
+}
+
+func TestConntrackerUDP(t *testing.T) {
+	for _, tc := range []struct {
+		policy UDPPolicy
+		want   int
+	}{
+		{UDPPolicy{}, 0},
+		{UDPPolicy{Track: true}, 1},
+		{UDPPolicy{Track: true, MinDuration: time.Hour}, 0},
+	} {
+		udpFlow := makeFlow(1, "1.2.3.4", "2.3.4.5", 34567, 53, New, "")
+		udpFlow.Metas[0].Layer4.Proto = UDP
+		// A TCP flow, always tracked, so we know the UDP flow has been
+		// handled.
+		tcpFlow := makeFlow(2, "1.2.3.4", "2.3.4.5", 2, 3, New, "")
+
+		undo := mockConntrack(newMockConntrackConn(udpFlow, tcpFlow))
+		conntracker, err := NewConntracker(tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		udpFlows := func() interface{} {
+			tcp, udp := 0, 0
+			conntracker.WalkFlows(func(f Flow) {
+				switch f.Original.Layer4.Proto {
+				case TCP:
+					tcp++
+				case UDP:
+					udp++
+				}
+			})
+			if tcp == 0 {
+				return -1
+			}
+			return udp
+		}
+		test.Poll(t, 100*time.Millisecond, tc.want, udpFlows)
+		conntracker.Stop()
+		undo()
+	}
+}
@@ -0,0 +1,15 @@
+package endpoint
+
+// Listener is a socket waiting for connections (TCP) or datagrams (UDP).
+type Listener struct {
+	Proto string // TCP or UDP
+	Addr  string
+	Port  uint16
+	Inode uint64
+	PID   uint // zero if the owning process wasn't found
+}
+
+// ListeningSockets returns the sockets listening in the probe's network
+// namespace, as found in the proc filesystem at procRoot. It is made public
+// for mocking.
+var ListeningSockets = listeningSockets
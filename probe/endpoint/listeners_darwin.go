@@ -0,0 +1,6 @@
+package endpoint
+
+// Listening sockets aren't reported on darwin.
+func listeningSockets(procRoot string) ([]Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,214 @@
+package endpoint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Socket states, from include/net/tcp_states.h. Unconnected UDP sockets are
+// in the close state.
+const (
+	tcpListen = 0x0a
+	tcpClose  = 0x07
+)
+
+// ownersRefresh is how long socket owners are cached for. A listening socket
+// missing from the cache causes a fresh walk sooner.
+const ownersRefresh = time.Minute
+
+// owners caches the owners of listening sockets between spy ticks, as
+// walking every process's fds is by far the most expensive part of listing
+// them.
+var owners ownerCache
+
+type ownerCache struct {
+	sync.Mutex
+	procRoot string
+	walked   time.Time
+	pids     map[uint64]uint // socket inode -> PID, zero if the owner wasn't found
+}
+
+// procNetFiles are the socket tables, relative to the proc root.
+var procNetFiles = []struct{ proto, file string }{
+	{TCP, "net/tcp"},
+	{TCP, "net/tcp6"},
+	{UDP, "net/udp"},
+	{UDP, "net/udp6"},
+}
+
+func listeningSockets(procRoot string) ([]Listener, error) {
+	var listeners []Listener
+	for _, f := range procNetFiles {
+		b, err := ioutil.ReadFile(path.Join(procRoot, f.file))
+		if os.IsNotExist(err) {
+			continue // e.g. IPv6 is disabled
+		} else if err != nil {
+			return nil, err
+		}
+		ls, err := ParseProcNet(f.proto, b)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, ls...)
+	}
+
+	if len(listeners) == 0 {
+		return listeners, nil
+	}
+	owners.lookup(procRoot, listeners)
+	return listeners, nil
+}
+
+// lookup sets the PIDs of the listeners from the cache, walking the proc
+// filesystem again if the cache is stale, or lacks any of the sockets.
+func (c *ownerCache) lookup(procRoot string, listeners []Listener) {
+	c.Lock()
+	defer c.Unlock()
+
+	stale := procRoot != c.procRoot || time.Since(c.walked) > ownersRefresh
+	for _, l := range listeners {
+		if _, ok := c.pids[l.Inode]; !ok {
+			stale = true
+		}
+	}
+	if stale {
+		all := socketOwners(procRoot)
+		c.pids = make(map[uint64]uint, len(listeners))
+		for _, l := range listeners {
+			c.pids[l.Inode] = all[l.Inode]
+		}
+		c.procRoot, c.walked = procRoot, time.Now()
+	}
+	for i := range listeners {
+		listeners[i].PID = c.pids[listeners[i].Inode]
+	}
+}
+
+// ParseProcNet parses a socket table, such as /proc/net/tcp, and returns the
+// listening sockets in it: TCP sockets in the listen state, and unconnected
+// UDP sockets bound to a port. It is exported for testing.
+func ParseProcNet(proto string, b []byte) ([]Listener, error) {
+	var listeners []Listener
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		fields := strings.Fields(string(line))
+		if i == 0 || len(fields) == 0 {
+			continue // the header, or the trailing newline
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("%s line %d: too few fields", proto, i)
+		}
+
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: bad state: %v", proto, i, err)
+		}
+		_, remotePort, err := parseProcNetAddr(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", proto, i, err)
+		}
+		switch {
+		case proto == TCP && state == tcpListen:
+		case proto == UDP && state == tcpClose && remotePort == 0:
+		default:
+			continue
+		}
+
+		addr, port, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", proto, i, err)
+		}
+		if port == 0 {
+			continue // not bound
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: bad inode: %v", proto, i, err)
+		}
+		listeners = append(listeners, Listener{
+			Proto: proto,
+			Addr:  addr,
+			Port:  port,
+			Inode: inode,
+		})
+	}
+	return listeners, nil
+}
+
+// parseProcNetAddr parses an address and port such as 0100007F:0050, where
+// the address is printed as 32-bit words in host byte order.
+func parseProcNetAddr(s string) (string, uint16, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", 0, fmt.Errorf("bad address %q", s)
+	}
+	raw, err := hex.DecodeString(s[:i])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("bad address %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for j := 0; j < len(raw); j += 4 {
+		nativeEndian.PutUint32(ip[j:j+4], binary.BigEndian.Uint32(raw[j:j+4]))
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad port %q", s)
+	}
+	return ip.String(), uint16(port), nil
+}
+
+// socketOwners maps socket inodes to the processes with them open. Sockets
+// shared by several processes, such as those of pre-forking servers, are
+// attributed to the lowest PID, typically the parent. Processes we can't
+// inspect are skipped.
+func socketOwners(procRoot string) map[uint64]uint {
+	owners := map[uint64]uint{}
+	dirEntries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return owners
+	}
+	for _, dirEntry := range dirEntries {
+		pid, err := strconv.ParseUint(dirEntry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		fdDir := path.Join(procRoot, dirEntry.Name(), "fd")
+		fds, err := readDirNames(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(path.Join(fdDir, fd))
+			if err != nil || !strings.HasPrefix(link, "socket:[") || !strings.HasSuffix(link, "]") {
+				continue
+			}
+			inode, err := strconv.ParseUint(link[len("socket:["):len(link)-1], 10, 64)
+			if err != nil {
+				continue
+			}
+			if owner, ok := owners[inode]; !ok || uint(pid) < owner {
+				owners[inode] = uint(pid)
+			}
+		}
+	}
+	return owners
+}
+
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
@@ -0,0 +1,149 @@
+package endpoint_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/scope/probe/endpoint"
+	"github.com/weaveworks/scope/test"
+)
+
+const (
+	procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0101A8C0:0016 0201A8C0:D431 01 00000000:00000000 02:000A7B2B 00000000     0        0 1003 4 0000000000000000 20 4 29 10 -1
+`
+	procNetTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0000000000000000 100 0 0 10 0
+   1: B80D0120000000000000000001000000:01BB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2002 1 0000000000000000 100 0 0 10 0
+`
+	procNetUDP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+   1: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 3001 2 0000000000000000 0
+   2: 0101A8C0:9C40 0801A8C0:0035 01 00000000:00000000 00:00000000 00000000     0        0 3002 2 0000000000000000 0
+   3: 00000000:0000 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 3003 2 0000000000000000 0
+`
+)
+
+func TestParseProcNet(t *testing.T) {
+	have, err := endpoint.ParseProcNet(endpoint.TCP, []byte(procNetTCP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []endpoint.Listener{
+		{Proto: endpoint.TCP, Addr: "0.0.0.0", Port: 22, Inode: 1001},
+		{Proto: endpoint.TCP, Addr: "127.0.0.1", Port: 631, Inode: 1002},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	have, err = endpoint.ParseProcNet(endpoint.TCP, []byte(procNetTCP6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []endpoint.Listener{
+		{Proto: endpoint.TCP, Addr: "::", Port: 80, Inode: 2001},
+		{Proto: endpoint.TCP, Addr: "2001:db8::1", Port: 443, Inode: 2002},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Connected and unbound UDP sockets aren't listening.
+	have, err = endpoint.ParseProcNet(endpoint.UDP, []byte(procNetUDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []endpoint.Listener{
+		{Proto: endpoint.UDP, Addr: "0.0.0.0", Port: 53, Inode: 3001},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	if _, err := endpoint.ParseProcNet(endpoint.TCP, []byte("header\n 0: 00000000:0016 00000000:0000 0A\n")); err == nil {
+		t.Error("expected an error for a short line")
+	}
+}
+
+func TestListeningSockets(t *testing.T) {
+	procRoot, err := ioutil.TempDir("", "scope-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(procRoot)
+
+	mkdir := func(dir string) {
+		if err := os.MkdirAll(path.Join(procRoot, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile := func(file, content string) {
+		if err := ioutil.WriteFile(path.Join(procRoot, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := func(target, link string) {
+		if err := os.Symlink(target, path.Join(procRoot, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mkdir("net")
+	writeFile("net/tcp", procNetTCP)
+	writeFile("net/udp", procNetUDP)
+	mkdir("10/fd") // a forked worker, sharing its parent's socket
+	symlink("socket:[1001]", "10/fd/3")
+	mkdir("9/fd")
+	symlink("socket:[1001]", "9/fd/3")
+	symlink("/dev/null", "9/fd/0")
+	mkdir("53/fd")
+	symlink("socket:[3001]", "53/fd/5")
+	mkdir("self")
+
+	have, err := endpoint.ListeningSockets(procRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []endpoint.Listener{
+		{Proto: endpoint.TCP, Addr: "0.0.0.0", Port: 22, Inode: 1001, PID: 9},
+		{Proto: endpoint.TCP, Addr: "127.0.0.1", Port: 631, Inode: 1002},
+		{Proto: endpoint.UDP, Addr: "0.0.0.0", Port: 53, Inode: 3001, PID: 53},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Owners are cached, so the fds aren't walked again...
+	if err := os.Remove(path.Join(procRoot, "53/fd/5")); err != nil {
+		t.Fatal(err)
+	}
+	have, err = endpoint.ListeningSockets(procRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// ...until a new socket turns up.
+	writeFile("net/tcp", procNetTCP+"   3: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 100 0 0 10 0\n")
+	symlink("socket:[1004]", "9/fd/4")
+	have, err = endpoint.ListeningSockets(procRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []endpoint.Listener{
+		{Proto: endpoint.TCP, Addr: "0.0.0.0", Port: 22, Inode: 1001, PID: 9},
+		{Proto: endpoint.TCP, Addr: "127.0.0.1", Port: 631, Inode: 1002},
+		{Proto: endpoint.TCP, Addr: "0.0.0.0", Port: 8080, Inode: 1004, PID: 9},
+		{Proto: endpoint.UDP, Addr: "0.0.0.0", Port: 53, Inode: 3001},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
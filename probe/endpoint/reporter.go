@@ -3,6 +3,7 @@ package endpoint
 import (
 	"log"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,18 +15,27 @@ import (
 
 // Node metadata keys.
 const (
-	Addr = "addr" // typically IPv4
-	Port = "port"
+	Addr      = "addr" // typically IPv4
+	Port      = "port"
+	Listening = "listening" // protocols listened on, e.g. "tcp,udp"
 )
 
+// WildcardPort is the port of the client end of UDP flows. UDP clients
+// typically use a fresh ephemeral port for every flow (e.g. every DNS
+// lookup), so flows are collapsed by client address and server address and
+// port.
+const WildcardPort = "*"
+
 // Reporter generates Reports containing the Endpoint topology.
 type Reporter struct {
 	hostID           string
 	hostName         string
+	procRoot         string
 	includeProcesses bool
 	includeNAT       bool
 	conntracker      *Conntracker
 	natmapper        *natmapper
+	flowCounters     map[int64]flowCounters // as of the last report, by flow ID
 }
 
 // SpyDuration is an exported prometheus metric
@@ -44,8 +54,11 @@ var SpyDuration = prometheus.NewSummaryVec(
 // generate a report.Report that contains every discovered (spied) connection
 // on the host machine, at the granularity of host and port. That information
 // is stored in the Endpoint topology. It optionally enriches that topology
-// with process (PID) information.
-func NewReporter(hostID, hostName string, includeProcesses bool, useConntrack bool) *Reporter {
+// with process (PID) information, and the sockets listening on the host,
+// found in the proc filesystem at procRoot. Traffic is only counted if
+// conntrack accounting is on; if enableAccounting is set, the reporter turns
+// it on, which changes a setting for the whole host.
+func NewReporter(hostID, hostName, procRoot string, includeProcesses bool, useConntrack, enableAccounting bool, udp UDPPolicy) *Reporter {
 	var (
 		conntrackModulePresent = ConntrackModulePresent()
 		conntracker            *Conntracker
@@ -53,7 +66,14 @@ func NewReporter(hostID, hostName string, includeProcesses bool, useConntrack bo
 		err                    error
 	)
 	if conntrackModulePresent && useConntrack {
-		conntracker, err = NewConntracker()
+		if enableAccounting {
+			if err := EnableConntrackAccounting(); err != nil {
+				log.Printf("Failed to enable conntrack accounting: %v", err)
+			}
+		} else if !ConntrackAccountingEnabled() {
+			log.Printf("Conntrack accounting is off, so traffic won't be counted; set net.netfilter.nf_conntrack_acct=1, or use -conntrack.accounting")
+		}
+		conntracker, err = NewConntracker(udp)
 		if err != nil {
 			log.Printf("Failed to start conntracker: %v", err)
 		}
@@ -67,9 +87,11 @@ func NewReporter(hostID, hostName string, includeProcesses bool, useConntrack bo
 	return &Reporter{
 		hostID:           hostID,
 		hostName:         hostName,
+		procRoot:         procRoot,
 		includeProcesses: includeProcesses,
 		conntracker:      conntracker,
 		natmapper:        natmapper,
+		flowCounters:     map[int64]flowCounters{},
 	}
 }
 
@@ -102,10 +124,22 @@ func (r *Reporter) Report() (report.Report, error) {
 			localAddr  = conn.LocalAddress.String()
 			remoteAddr = conn.RemoteAddress.String()
 		)
-		r.addConnection(&rpt, localAddr, remoteAddr, localPort, remotePort, &conn.Proc)
+		r.addTCPConnection(&rpt, localAddr, remoteAddr, localPort, remotePort, flowCounters{}, &conn.Proc)
+	}
+
+	if r.includeProcesses {
+		listeners, err := ListeningSockets(r.procRoot)
+		if err != nil {
+			log.Printf("endpoint: failed to list listening sockets: %v", err)
+		}
+		r.addListeners(&rpt, listeners)
 	}
 
 	if r.conntracker != nil {
+		var (
+			udpFlows = map[udpFlow]udpFlowStats{}
+			counters = map[int64]flowCounters{}
+		)
 		r.conntracker.WalkFlows(func(f Flow) {
 			var (
 				localPort  = f.Original.Layer4.SrcPort
@@ -113,8 +147,32 @@ func (r *Reporter) Report() (report.Report, error) {
 				localAddr  = f.Original.Layer3.SrcIP
 				remoteAddr = f.Original.Layer3.DstIP
 			)
-			r.addConnection(&rpt, localAddr, remoteAddr, uint16(localPort), uint16(remotePort), nil)
+
+			// Counters are cumulative, but reports carry the traffic since
+			// the last report.
+			current := countersOf(f)
+			delta := current.sub(r.flowCounters[f.Independent.ID])
+			counters[f.Independent.ID] = current
+
+			if f.Original.Layer4.Proto == UDP {
+				// The original direction is the client's.
+				key := udpFlow{localAddr, remoteAddr, strconv.Itoa(remotePort)}
+				stats := udpFlows[key]
+				stats.flows++
+				stats.counters = stats.counters.add(delta)
+				udpFlows[key] = stats
+				return
+			}
+			r.addTCPConnection(&rpt, localAddr, remoteAddr, uint16(localPort), uint16(remotePort), delta, nil)
 		})
+		r.flowCounters = counters
+
+		for flow, stats := range udpFlows {
+			r.addConnection(&rpt, flow.clientAddr, flow.serverAddr, WildcardPort, flow.serverPort, true, stats.counters.addTo(report.EdgeMetadata{
+				MaxConnCountUDP: newu64(stats.flows),
+				Protocols:       report.MakeIDList(UDP),
+			}, true), nil)
+		}
 	}
 
 	if r.natmapper != nil {
@@ -124,11 +182,92 @@ func (r *Reporter) Report() (report.Report, error) {
 	return rpt, err
 }
 
-func (r *Reporter) addConnection(rpt *report.Report, localAddr, remoteAddr string, localPort, remotePort uint16, proc *procspy.Proc) {
-	var (
-		localIsClient = int(localPort) > int(remotePort)
-		hostNodeID    = report.MakeHostNodeID(r.hostID)
-	)
+// udpFlow identifies the UDP flows collapsed into a single edge.
+type udpFlow struct {
+	clientAddr, serverAddr, serverPort string
+}
+
+// udpFlowStats are the number of UDP flows collapsed into a single edge, and
+// their traffic.
+type udpFlowStats struct {
+	flows    uint64
+	counters flowCounters
+}
+
+// flowCounters are the packets and bytes sent in each direction of a flow.
+type flowCounters struct {
+	originalPackets, originalBytes uint64
+	replyPackets, replyBytes       uint64
+}
+
+func countersOf(f Flow) flowCounters {
+	result := flowCounters{
+		originalPackets: f.Original.Packets,
+		originalBytes:   f.Original.Bytes,
+	}
+	if f.Reply != nil {
+		result.replyPackets = f.Reply.Packets
+		result.replyBytes = f.Reply.Bytes
+	}
+	return result
+}
+
+func (c flowCounters) add(other flowCounters) flowCounters {
+	return flowCounters{
+		originalPackets: c.originalPackets + other.originalPackets,
+		originalBytes:   c.originalBytes + other.originalBytes,
+		replyPackets:    c.replyPackets + other.replyPackets,
+		replyBytes:      c.replyBytes + other.replyBytes,
+	}
+}
+
+// sub returns the counters since the earlier counters were read. If a
+// counter has gone backwards, the flow must be a new one, with the same ID.
+func (c flowCounters) sub(earlier flowCounters) flowCounters {
+	delta := func(now, then uint64) uint64 {
+		if now < then {
+			return now
+		}
+		return now - then
+	}
+	return flowCounters{
+		originalPackets: delta(c.originalPackets, earlier.originalPackets),
+		originalBytes:   delta(c.originalBytes, earlier.originalBytes),
+		replyPackets:    delta(c.replyPackets, earlier.replyPackets),
+		replyBytes:      delta(c.replyBytes, earlier.replyBytes),
+	}
+}
+
+// addTo adds the counters to the metadata of an edge from the original
+// (forwards) or reply end of the flow. Zero counters, say because conntrack
+// accounting is disabled, are left out.
+func (c flowCounters) addTo(edge report.EdgeMetadata, forwards bool) report.EdgeMetadata {
+	if c == (flowCounters{}) {
+		return edge
+	}
+	egressPackets, egressBytes, ingressPackets, ingressBytes := c.originalPackets, c.originalBytes, c.replyPackets, c.replyBytes
+	if !forwards {
+		egressPackets, egressBytes, ingressPackets, ingressBytes = ingressPackets, ingressBytes, egressPackets, egressBytes
+	}
+	edge.EgressPacketCount = newu64(egressPackets)
+	edge.EgressByteCount = newu64(egressBytes)
+	edge.IngressPacketCount = newu64(ingressPackets)
+	edge.IngressByteCount = newu64(ingressBytes)
+	return edge
+}
+
+// addTCPConnection adds a TCP connection, with the local end being the
+// original end of the flow, if it's from conntrack.
+func (r *Reporter) addTCPConnection(rpt *report.Report, localAddr, remoteAddr string, localPort, remotePort uint16, counters flowCounters, proc *procspy.Proc) {
+	localIsClient := int(localPort) > int(remotePort)
+	r.addConnection(rpt, localAddr, remoteAddr, strconv.Itoa(int(localPort)), strconv.Itoa(int(remotePort)), localIsClient, counters.addTo(report.EdgeMetadata{
+		MaxConnCountTCP: newu64(1),
+		Protocols:       report.MakeIDList(TCP),
+	}, localIsClient), proc)
+}
+
+func (r *Reporter) addConnection(rpt *report.Report, localAddr, remoteAddr, localPort, remotePort string, localIsClient bool, edge report.EdgeMetadata, proc *procspy.Proc) {
+	hostNodeID := report.MakeHostNodeID(r.hostID)
 
 	// Update address topology
 	{
@@ -147,13 +286,9 @@ func (r *Reporter) addConnection(rpt *report.Report, localAddr, remoteAddr strin
 
 		if localIsClient {
 			// New nodes are merged into the report so we don't need to do any counting here; the merge does it for us.
-			localNode = localNode.WithEdge(remoteAddressNodeID, report.EdgeMetadata{
-				MaxConnCountTCP: newu64(1),
-			})
+			localNode = localNode.WithEdge(remoteAddressNodeID, edge.Copy())
 		} else {
-			remoteNode = localNode.WithEdge(localAddressNodeID, report.EdgeMetadata{
-				MaxConnCountTCP: newu64(1),
-			})
+			remoteNode = localNode.WithEdge(localAddressNodeID, edge.Copy())
 		}
 
 		rpt.Address = rpt.Address.WithNode(localAddressNodeID, localNode)
@@ -163,29 +298,25 @@ func (r *Reporter) addConnection(rpt *report.Report, localAddr, remoteAddr strin
 	// Update endpoint topology
 	if r.includeProcesses {
 		var (
-			localEndpointNodeID  = report.MakeEndpointNodeID(r.hostID, localAddr, strconv.Itoa(int(localPort)))
-			remoteEndpointNodeID = report.MakeEndpointNodeID(r.hostID, remoteAddr, strconv.Itoa(int(remotePort)))
+			localEndpointNodeID  = report.MakeEndpointNodeID(r.hostID, localAddr, localPort)
+			remoteEndpointNodeID = report.MakeEndpointNodeID(r.hostID, remoteAddr, remotePort)
 
 			localNode = report.MakeNodeWith(map[string]string{
 				Addr:              localAddr,
-				Port:              strconv.Itoa(int(localPort)),
+				Port:              localPort,
 				report.HostNodeID: hostNodeID,
 			})
 			remoteNode = report.MakeNodeWith(map[string]string{
 				Addr: remoteAddr,
-				Port: strconv.Itoa(int(remotePort)),
+				Port: remotePort,
 			})
 		)
 
 		if localIsClient {
 			// New nodes are merged into the report so we don't need to do any counting here; the merge does it for us.
-			localNode = localNode.WithEdge(remoteEndpointNodeID, report.EdgeMetadata{
-				MaxConnCountTCP: newu64(1),
-			})
+			localNode = localNode.WithEdge(remoteEndpointNodeID, edge.Copy())
 		} else {
-			remoteNode = remoteNode.WithEdge(localEndpointNodeID, report.EdgeMetadata{
-				MaxConnCountTCP: newu64(1),
-			})
+			remoteNode = remoteNode.WithEdge(localEndpointNodeID, edge.Copy())
 		}
 
 		if proc != nil && proc.PID > 0 {
@@ -197,6 +328,40 @@ func (r *Reporter) addConnection(rpt *report.Report, localAddr, remoteAddr strin
 	}
 }
 
+// addListeners adds listening sockets to the endpoint topology, so servers
+// show up even when they have no clients. A TCP and a UDP socket may listen on
+// the same address and port.
+func (r *Reporter) addListeners(rpt *report.Report, listeners []Listener) {
+	var (
+		hostNodeID = report.MakeHostNodeID(r.hostID)
+		nodes      = map[string]report.Node{}
+		protocols  = map[string]report.IDList{}
+	)
+	for _, l := range listeners {
+		var (
+			port   = strconv.Itoa(int(l.Port))
+			nodeID = report.MakeEndpointNodeID(r.hostID, l.Addr, port)
+		)
+		node, ok := nodes[nodeID]
+		if !ok {
+			node = report.MakeNodeWith(map[string]string{
+				Addr:              l.Addr,
+				Port:              port,
+				report.HostNodeID: hostNodeID,
+			})
+		}
+		protocols[nodeID] = protocols[nodeID].Add(l.Proto)
+		node.Metadata[Listening] = strings.Join(protocols[nodeID], ",")
+		if l.PID > 0 {
+			node.Metadata[process.PID] = strconv.FormatUint(uint64(l.PID), 10)
+		}
+		nodes[nodeID] = node
+	}
+	for nodeID, node := range nodes {
+		rpt.Endpoint = rpt.Endpoint.WithNode(nodeID, node)
+	}
+}
+
 func newu64(i uint64) *uint64 {
 	return &i
 }
@@ -2,13 +2,17 @@ package endpoint_test
 
 import (
 	"net"
+	"reflect"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/procspy"
 	"github.com/weaveworks/scope/probe/docker"
 	"github.com/weaveworks/scope/probe/endpoint"
 	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
 )
 
 var (
@@ -71,7 +75,7 @@ func TestSpyNoProcesses(t *testing.T) {
 		nodeName = "frenchs-since-1904"   // TODO rename to hostNmae
 	)
 
-	reporter := endpoint.NewReporter(nodeID, nodeName, false, false)
+	reporter := endpoint.NewReporter(nodeID, nodeName, "/proc", false, false, false, endpoint.UDPPolicy{})
 	r, _ := reporter.Report()
 	//buf, _ := json.MarshalIndent(r, "", "    ")
 	//t.Logf("\n%s\n", buf)
@@ -107,7 +111,7 @@ func TestSpyWithProcesses(t *testing.T) {
 		nodeName = "fishermans-friend" // TODO rename to hostNmae
 	)
 
-	reporter := endpoint.NewReporter(nodeID, nodeName, true, false)
+	reporter := endpoint.NewReporter(nodeID, nodeName, "/proc", true, false, false, endpoint.UDPPolicy{})
 	r, _ := reporter.Report()
 	// buf, _ := json.MarshalIndent(r, "", "    ") ; t.Logf("\n%s\n", buf)
 
@@ -132,3 +136,207 @@ func TestSpyWithProcesses(t *testing.T) {
 		}
 	}
 }
+
+func TestSpyIPv6(t *testing.T) {
+	var (
+		localAddress  = net.ParseIP("2001:db8::1")
+		remoteAddress = net.ParseIP("2001:db8::2")
+	)
+	procspy.SetFixtures([]procspy.Connection{
+		{
+			Transport:     "tcp",
+			LocalAddress:  localAddress,
+			LocalPort:     fixLocalPort,
+			RemoteAddress: remoteAddress,
+			RemotePort:    fixRemotePort,
+		},
+	})
+
+	const nodeID = "nikon"
+	reporter := endpoint.NewReporter(nodeID, "fishermans-friend", "/proc", true, false, false, endpoint.UDPPolicy{})
+	r, _ := reporter.Report()
+
+	var (
+		scopedLocal  = report.MakeEndpointNodeID(nodeID, "2001:db8::1", strconv.Itoa(int(fixLocalPort)))
+		scopedRemote = report.MakeEndpointNodeID(nodeID, "2001:db8::2", strconv.Itoa(int(fixRemotePort)))
+	)
+
+	if want, have := "2001:db8::1", r.Endpoint.Nodes[scopedLocal].Metadata[endpoint.Addr]; want != have {
+		t.Fatalf("want %q, have %q", want, have)
+	}
+
+	if want, have := report.MakeIDList(scopedLocal), r.Endpoint.Nodes[scopedRemote].Adjacency; !reflect.DeepEqual(want, have) {
+		t.Fatalf("want %v, have %v", want, have)
+	}
+}
+
+// makeCountedFlow makes a flow which has sent the given packets and bytes in
+// each direction.
+func makeCountedFlow(id int64, proto, srcIP, dstIP string, srcPort, dstPort int, packets, bytes, replyPackets, replyBytes uint64) endpoint.Flow {
+	f := makeFlow(id, srcIP, dstIP, srcPort, dstPort, endpoint.New, "ESTABLISHED")
+	f.Metas[0].Layer4.Proto = proto
+	f.Metas[0].Packets, f.Metas[0].Bytes = packets, bytes
+	f.Metas = append(f.Metas, endpoint.Meta{
+		Direction: "reply",
+		Layer3:    endpoint.Layer3{SrcIP: dstIP, DstIP: srcIP},
+		Layer4:    endpoint.Layer4{SrcPort: dstPort, DstPort: srcPort, Proto: proto},
+		Packets:   replyPackets,
+		Bytes:     replyBytes,
+	})
+	return f
+}
+
+func TestConntrackCounters(t *testing.T) {
+	procspy.SetFixtures(nil)
+
+	var (
+		mtx   sync.Mutex
+		table = []endpoint.Flow{
+			makeCountedFlow(1, endpoint.TCP, "10.0.0.1", "10.0.0.2", 34567, 80, 10, 1000, 5, 500),
+			makeCountedFlow(2, endpoint.UDP, "10.0.0.1", "10.0.0.3", 40001, 53, 2, 100, 2, 200),
+			makeCountedFlow(3, endpoint.UDP, "10.0.0.1", "10.0.0.3", 40002, 53, 1, 50, 1, 60),
+		}
+		setTable = func(flows ...endpoint.Flow) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			table = flows
+		}
+	)
+
+	oldDial, oldConntrackPresent, oldEnableAccounting, oldAccountingEnabled, oldResyncInterval := endpoint.DialConntrack, endpoint.ConntrackModulePresent, endpoint.EnableConntrackAccounting, endpoint.ConntrackAccountingEnabled, endpoint.ConntrackResyncInterval
+	defer func() {
+		endpoint.DialConntrack, endpoint.ConntrackModulePresent, endpoint.EnableConntrackAccounting, endpoint.ConntrackAccountingEnabled, endpoint.ConntrackResyncInterval = oldDial, oldConntrackPresent, oldEnableAccounting, oldAccountingEnabled, oldResyncInterval
+	}()
+	endpoint.DialConntrack = func() (endpoint.ConntrackConn, error) {
+		return &mockConntrackConn{
+			dump: func() []endpoint.Flow {
+				mtx.Lock()
+				defer mtx.Unlock()
+				return table
+			},
+			events: make(chan []endpoint.Flow),
+		}, nil
+	}
+	endpoint.ConntrackModulePresent = func() bool { return true }
+	// Accounting is already on; the host's setting is left alone.
+	endpoint.EnableConntrackAccounting = func() error {
+		t.Error("conntrack accounting enabled without asking")
+		return nil
+	}
+	endpoint.ConntrackAccountingEnabled = func() bool { return true }
+	endpoint.ConntrackResyncInterval = time.Millisecond
+
+	const hostID = "heinz-tomato-ketchup"
+	reporter := endpoint.NewReporter(hostID, "frenchs-since-1904", "/proc", true, true, false, endpoint.UDPPolicy{Track: true})
+	defer reporter.Stop()
+
+	var (
+		tcpClient = report.MakeEndpointNodeID(hostID, "10.0.0.1", "34567")
+		tcpServer = report.MakeEndpointNodeID(hostID, "10.0.0.2", "80")
+		udpClient = report.MakeEndpointNodeID(hostID, "10.0.0.1", endpoint.WildcardPort)
+		udpServer = report.MakeEndpointNodeID(hostID, "10.0.0.3", "53")
+	)
+	edges := func() interface{} {
+		rpt, err := reporter.Report()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []report.EdgeMetadata{
+			rpt.Endpoint.Nodes[tcpClient].Edges[tcpServer],
+			rpt.Endpoint.Nodes[udpClient].Edges[udpServer],
+		}
+	}
+	u64 := func(i uint64) *uint64 { return &i }
+
+	// The first report has all the traffic so far.
+	test.Poll(t, 100*time.Millisecond, []report.EdgeMetadata{
+		{
+			EgressPacketCount:  u64(10),
+			IngressPacketCount: u64(5),
+			EgressByteCount:    u64(1000),
+			IngressByteCount:   u64(500),
+			MaxConnCountTCP:    u64(1),
+			Protocols:          report.MakeIDList(endpoint.TCP),
+		},
+		{
+			EgressPacketCount:  u64(3),
+			IngressPacketCount: u64(3),
+			EgressByteCount:    u64(150),
+			IngressByteCount:   u64(260),
+			MaxConnCountUDP:    u64(2),
+			Protocols:          report.MakeIDList(endpoint.UDP),
+		},
+	}, edges)
+
+	// Later reports have the traffic since the previous report.
+	setTable(makeCountedFlow(1, endpoint.TCP, "10.0.0.1", "10.0.0.2", 34567, 80, 15, 1500, 8, 800))
+	want := report.EdgeMetadata{
+		EgressPacketCount:  u64(5),
+		IngressPacketCount: u64(3),
+		EgressByteCount:    u64(500),
+		IngressByteCount:   u64(300),
+		MaxConnCountTCP:    u64(1),
+		Protocols:          report.MakeIDList(endpoint.TCP),
+	}
+	test.Poll(t, 100*time.Millisecond, true, func() interface{} {
+		return reflect.DeepEqual(want, edges().([]report.EdgeMetadata)[0])
+	})
+}
+
+func TestSpyListeners(t *testing.T) {
+	procspy.SetFixtures(fixConnectionsWithProcesses)
+
+	oldListeningSockets := endpoint.ListeningSockets
+	defer func() { endpoint.ListeningSockets = oldListeningSockets }()
+	endpoint.ListeningSockets = func(string) ([]endpoint.Listener, error) {
+		return []endpoint.Listener{
+			{Proto: endpoint.TCP, Addr: fixLocalAddress.String(), Port: fixLocalPort, PID: fixProcessPID},
+			{Proto: endpoint.TCP, Addr: "0.0.0.0", Port: 53, PID: 53},
+			{Proto: endpoint.UDP, Addr: "0.0.0.0", Port: 53, PID: 53},
+			{Proto: endpoint.UDP, Addr: "::", Port: 123},
+		}, nil
+	}
+
+	const nodeID = "nikon"
+	reporter := endpoint.NewReporter(nodeID, "fishermans-friend", "/proc", true, false, false, endpoint.UDPPolicy{})
+	r, _ := reporter.Report()
+
+	var (
+		scopedLocal  = report.MakeEndpointNodeID(nodeID, fixLocalAddress.String(), strconv.Itoa(int(fixLocalPort)))
+		scopedRemote = report.MakeEndpointNodeID(nodeID, fixRemoteAddress.String(), strconv.Itoa(int(fixRemotePort)))
+		dns          = report.MakeEndpointNodeID(nodeID, "0.0.0.0", "53")
+		ntp          = report.MakeEndpointNodeID(nodeID, "::", "123")
+	)
+
+	// A listener is merged with the connections to it.
+	if want, have := report.MakeIDList(scopedLocal), r.Endpoint.Nodes[scopedRemote].Adjacency; !reflect.DeepEqual(want, have) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	for id, want := range map[string]report.Metadata{
+		scopedLocal: {
+			endpoint.Addr:      fixLocalAddress.String(),
+			endpoint.Port:      strconv.Itoa(int(fixLocalPort)),
+			endpoint.Listening: "tcp",
+			report.HostNodeID:  report.MakeHostNodeID(nodeID),
+			"pid":              strconv.FormatUint(uint64(fixProcessPID), 10),
+		},
+		dns: {
+			endpoint.Addr:      "0.0.0.0",
+			endpoint.Port:      "53",
+			endpoint.Listening: "tcp,udp",
+			report.HostNodeID:  report.MakeHostNodeID(nodeID),
+			"pid":              "53",
+		},
+		ntp: {
+			endpoint.Addr:      "::",
+			endpoint.Port:      "123",
+			endpoint.Listening: "udp",
+			report.HostNodeID:  report.MakeHostNodeID(nodeID),
+		},
+	} {
+		if have := r.Endpoint.Nodes[id].Metadata; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", id, test.Diff(want, have))
+		}
+	}
+}
@@ -2,6 +2,8 @@ package host
 
 import (
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,17 +19,38 @@ const (
 	Load          = "load"
 	KernelVersion = "kernel_version"
 	Uptime        = "uptime"
+
+	// Resource usage. Rates are in bytes per second. All but MemoryTotal
+	// are also kept as metrics.
+	CPUUsage            = "cpu_usage_percent"
+	MemoryUsage         = "memory_usage"
+	MemoryTotal         = "memory_total"
+	DiskReadRate        = "disk_read_rate"
+	DiskWriteRate       = "disk_write_rate"
+	NetworkRxRatePrefix = "network_rx_rate_" // followed by interface name
+	NetworkTxRatePrefix = "network_tx_rate_" // followed by interface name
+)
+
+// Keys for use in Node.Metrics.
+const (
+	Load1 = "load1"
 )
 
 // Exposed for testing.
 const (
-	ProcUptime = "/proc/uptime"
-	ProcLoad   = "/proc/loadavg"
+	ProcUptime    = "/proc/uptime"
+	ProcLoad      = "/proc/loadavg"
+	ProcStat      = "/proc/stat"
+	ProcMeminfo   = "/proc/meminfo"
+	ProcDiskstats = "/proc/diskstats"
+	ProcMdstat    = "/proc/mdstat"
+	ProcNetDev    = "/proc/net/dev"
+	SysClassNet   = "/sys/class/net"
 )
 
 // Exposed for testing.
 var (
-	Now = func() string { return time.Now().UTC().Format(time.RFC3339Nano) }
+	Now = func() time.Time { return time.Now().UTC() }
 )
 
 // Reporter generates Reports containing the host topology.
@@ -35,6 +58,13 @@ type Reporter struct {
 	hostID    string
 	hostName  string
 	localNets report.Networks
+
+	// Rates are worked out from the counters at the previous report.
+	metrics    report.Metrics
+	prevTime   time.Time
+	prevCPU    *CPUStat
+	prevDisk   *DiskStat
+	prevIfaces map[string]InterfaceStat
 }
 
 // NewReporter returns a Reporter which produces a report containing host
@@ -44,6 +74,7 @@ func NewReporter(hostID, hostName string, localNets report.Networks) *Reporter {
 		hostID:    hostID,
 		hostName:  hostName,
 		localNets: localNets,
+		metrics:   report.Metrics{},
 	}
 }
 
@@ -68,15 +99,97 @@ func (r *Reporter) Report() (report.Report, error) {
 		return rep, err
 	}
 
-	rep.Host.Nodes[report.MakeHostNodeID(r.hostID)] = report.MakeNodeWith(map[string]string{
-		Timestamp:     Now(),
-		HostName:      r.hostName,
-		LocalNetworks: strings.Join(localCIDRs, " "),
-		OS:            runtime.GOOS,
-		Load:          GetLoad(),
-		KernelVersion: kernel,
-		Uptime:        uptime.String(),
-	})
+	var (
+		now      = Now()
+		load     = GetLoad()
+		metadata = map[string]string{
+			Timestamp:     now.Format(time.RFC3339Nano),
+			HostName:      r.hostName,
+			LocalNetworks: strings.Join(localCIDRs, " "),
+			OS:            runtime.GOOS,
+			Load:          load,
+			KernelVersion: kernel,
+			Uptime:        uptime.String(),
+		}
+		metrics = report.Metrics{}
+	)
+	if fields := strings.Fields(load); len(fields) > 0 {
+		if one, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			metrics[Load1] = r.metrics[Load1].Add(now, one)
+		}
+	}
+	r.addResourceUsage(now, metadata, metrics)
+	r.metrics = metrics
+
+	rep.Host.Nodes[report.MakeHostNodeID(r.hostID)] = report.MakeNodeWith(metadata).WithMetrics(metrics.Copy())
 
 	return rep, nil
 }
+
+// addResourceUsage adds the host's CPU, memory, disk and network usage to the
+// metadata, and their histories to the metrics. Anything which can't be read
+// is left out, along with its history.
+func (r *Reporter) addResourceUsage(now time.Time, metadata map[string]string, metrics report.Metrics) {
+	var (
+		elapsed = now.Sub(r.prevTime).Seconds()
+		record  = func(key string, value float64) {
+			metadata[key] = strconv.FormatFloat(value, 'f', -1, 64)
+			metrics[key] = r.metrics[key].Add(now, value)
+		}
+		rate = func(key string, prev, cur uint64) {
+			if elapsed > 0 && cur >= prev {
+				record(key, float64(cur-prev)/elapsed)
+			}
+		}
+	)
+	r.prevTime = now
+
+	if cpu, err := GetCPUStat(); err == nil {
+		if r.prevCPU != nil && cpu.Total > r.prevCPU.Total && cpu.Busy >= r.prevCPU.Busy {
+			record(CPUUsage, float64(cpu.Busy-r.prevCPU.Busy)/float64(cpu.Total-r.prevCPU.Total)*100)
+		}
+		r.prevCPU = &cpu
+	} else {
+		r.prevCPU = nil
+	}
+
+	if used, total, err := GetMemoryUsage(); err == nil {
+		record(MemoryUsage, float64(used))
+		metadata[MemoryTotal] = strconv.FormatUint(total, 10)
+	}
+
+	if disk, err := GetDiskStat(); err == nil {
+		if r.prevDisk != nil {
+			rate(DiskReadRate, r.prevDisk.ReadBytes, disk.ReadBytes)
+			rate(DiskWriteRate, r.prevDisk.WriteBytes, disk.WriteBytes)
+		}
+		r.prevDisk = &disk
+	} else {
+		r.prevDisk = nil
+	}
+
+	ifaces, err := GetInterfaceStats()
+	if err != nil {
+		ifaces = nil
+	}
+	for name, iface := range ifaces {
+		if prev, ok := r.prevIfaces[name]; ok {
+			rate(NetworkRxRatePrefix+name, prev.RxBytes, iface.RxBytes)
+			rate(NetworkTxRatePrefix+name, prev.TxBytes, iface.TxBytes)
+		}
+	}
+	r.prevIfaces = ifaces
+}
+
+// ExtractInterfaces returns the names of the network interfaces with rates
+// in the host node's metadata, in order.
+func ExtractInterfaces(nmd report.Node) []string {
+	result := []string{}
+	for key := range nmd.Metadata {
+		if strings.HasPrefix(key, NetworkRxRatePrefix) {
+			result = append(result, strings.TrimPrefix(key, NetworkRxRatePrefix))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
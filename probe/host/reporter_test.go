@@ -18,7 +18,7 @@ func TestReporter(t *testing.T) {
 		version     = "version"
 		network     = "192.168.0.0/16"
 		hostID      = "hostid"
-		now         = "now"
+		now         = time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
 		hostname    = "hostname"
 		load        = "0.59 0.36 0.29"
 		uptime      = "278h55m43s"
@@ -32,30 +32,84 @@ func TestReporter(t *testing.T) {
 		oldGetLoad          = host.GetLoad
 		oldGetUptime        = host.GetUptime
 		oldNow              = host.Now
+		oldGetCPUStat       = host.GetCPUStat
+		oldGetMemoryUsage   = host.GetMemoryUsage
+		oldGetDiskStat      = host.GetDiskStat
+		oldGetIfaceStats    = host.GetInterfaceStats
 	)
 	defer func() {
 		host.GetKernelVersion = oldGetKernelVersion
 		host.GetLoad = oldGetLoad
 		host.GetUptime = oldGetUptime
 		host.Now = oldNow
+		host.GetCPUStat = oldGetCPUStat
+		host.GetMemoryUsage = oldGetMemoryUsage
+		host.GetDiskStat = oldGetDiskStat
+		host.GetInterfaceStats = oldGetIfaceStats
 	}()
+	var (
+		cpu    = host.CPUStat{Busy: 100, Total: 1000}
+		disk   = host.DiskStat{ReadBytes: 4096, WriteBytes: 8192}
+		ifaces = map[string]host.InterfaceStat{"eth0": {RxBytes: 1000, TxBytes: 2000}}
+	)
 	host.GetKernelVersion = func() (string, error) { return release + " " + version, nil }
 	host.GetLoad = func() string { return load }
 	host.GetUptime = func() (time.Duration, error) { return time.ParseDuration(uptime) }
-	host.Now = func() string { return now }
+	host.Now = func() time.Time { return now }
+	host.GetCPUStat = func() (host.CPUStat, error) { return cpu, nil }
+	host.GetMemoryUsage = func() (uint64, uint64, error) { return 1024, 4096, nil }
+	host.GetDiskStat = func() (host.DiskStat, error) { return disk, nil }
+	host.GetInterfaceStats = func() (map[string]host.InterfaceStat, error) { return ifaces, nil }
 
 	want := report.MakeReport()
 	want.Host.Nodes[report.MakeHostNodeID(hostID)] = report.MakeNodeWith(map[string]string{
-		host.Timestamp:     now,
+		host.Timestamp:     now.Format(time.RFC3339Nano),
 		host.HostName:      hostname,
 		host.LocalNetworks: network,
 		host.OS:            runtime.GOOS,
 		host.Load:          load,
 		host.Uptime:        uptime,
 		host.KernelVersion: kernel,
+		host.MemoryUsage:   "1024",
+		host.MemoryTotal:   "4096",
+	}).WithMetrics(report.Metrics{
+		host.Load1:       report.MakeMetric(now, 0.59),
+		host.MemoryUsage: report.MakeMetric(now, 1024),
 	})
-	have, _ := host.NewReporter(hostID, hostname, localNets).Report()
+	r := host.NewReporter(hostID, hostname, localNets)
+	have, _ := r.Report()
 	if !reflect.DeepEqual(want, have) {
 		t.Errorf("%s", test.Diff(want, have))
 	}
+
+	// Rates need two reports, and histories grow with each report
+	later := now.Add(2 * time.Second)
+	host.Now = func() time.Time { return later }
+	cpu = host.CPUStat{Busy: 150, Total: 1200}
+	disk = host.DiskStat{ReadBytes: 4096 + 2048, WriteBytes: 8192 + 4096}
+	ifaces = map[string]host.InterfaceStat{"eth0": {RxBytes: 1000 + 200, TxBytes: 2000 + 400}}
+	have, _ = r.Report()
+	node := have.Host.Nodes[report.MakeHostNodeID(hostID)]
+	for key, value := range map[string]string{
+		host.CPUUsage:                     "25",
+		host.DiskReadRate:                 "1024",
+		host.DiskWriteRate:                "2048",
+		host.NetworkRxRatePrefix + "eth0": "100",
+		host.NetworkTxRatePrefix + "eth0": "200",
+	} {
+		if want, have := value, node.Metadata[key]; want != have {
+			t.Errorf("%s: want %q, have %q", key, want, have)
+		}
+	}
+	wantLoad := report.MakeMetric(now, 0.59).Add(later, 0.59)
+	if haveLoad := node.Metrics[host.Load1]; !reflect.DeepEqual(wantLoad, haveLoad) {
+		t.Errorf("%s", test.Diff(wantLoad, haveLoad))
+	}
+	wantCPU := report.MakeMetric(later, 25)
+	if haveCPU := node.Metrics[host.CPUUsage]; !reflect.DeepEqual(wantCPU, haveCPU) {
+		t.Errorf("%s", test.Diff(wantCPU, haveCPU))
+	}
+	if want, have := []string{"eth0"}, host.ExtractInterfaces(node); !reflect.DeepEqual(want, have) {
+		t.Errorf("%s", test.Diff(want, have))
+	}
 }
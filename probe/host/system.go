@@ -0,0 +1,21 @@
+package host
+
+// CPUStat is the time spent by all CPUs since boot, in jiffies.
+type CPUStat struct {
+	Busy  uint64
+	Total uint64
+}
+
+// DiskStat is the number of bytes read from and written to all disks since
+// boot.
+type DiskStat struct {
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// InterfaceStat is the number of bytes received and transmitted by a
+// network interface since boot.
+type InterfaceStat struct {
+	RxBytes uint64
+	TxBytes uint64
+}
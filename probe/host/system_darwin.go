@@ -1,6 +1,7 @@
 package host
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 	"regexp"
@@ -64,3 +65,25 @@ var GetUptime = func() (time.Duration, error) {
 	}
 	return (time.Duration(d) * 24 * time.Hour) + (time.Duration(h) * time.Hour) + (time.Duration(m) * time.Minute), nil
 }
+
+var errNotSupported = errors.New("not supported on darwin")
+
+// GetCPUStat is not supported on darwin.
+var GetCPUStat = func() (CPUStat, error) {
+	return CPUStat{}, errNotSupported
+}
+
+// GetMemoryUsage is not supported on darwin.
+var GetMemoryUsage = func() (used, total uint64, err error) {
+	return 0, 0, errNotSupported
+}
+
+// GetDiskStat is not supported on darwin.
+var GetDiskStat = func() (DiskStat, error) {
+	return DiskStat{}, errNotSupported
+}
+
+// GetInterfaceStats is not supported on darwin.
+var GetInterfaceStats = func() (map[string]InterfaceStat, error) {
+	return nil, errNotSupported
+}
@@ -3,14 +3,24 @@ package host
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 )
 
-// Uname is swappable for mocking in tests.
-var Uname = syscall.Uname
+// Hooks exposed for mocking
+var (
+	Uname    = syscall.Uname
+	ReadFile = ioutil.ReadFile
+	Readlink = os.Readlink
+)
+
+// diskSectorSize is the unit of the sector counts in /proc/diskstats,
+// regardless of the disk's actual sector size.
+const diskSectorSize = 512
 
 func charsToString(ca [65]int8) string {
 	s := make([]byte, len(ca))
@@ -77,3 +87,211 @@ var GetUptime = func() (time.Duration, error) {
 
 	return time.Duration(uptime) * time.Second, nil
 }
+
+// GetCPUStat returns the time spent by all CPUs since boot, from /proc/stat.
+var GetCPUStat = func() (CPUStat, error) {
+	buf, err := ReadFile(ProcStat)
+	if err != nil {
+		return CPUStat{}, err
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal; guest time is
+		// already counted in user time.
+		var stat CPUStat
+		for i, field := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return CPUStat{}, err
+			}
+			stat.Total += v
+			if i != 3 && i != 4 { // idle, iowait
+				stat.Busy += v
+			}
+		}
+		return stat, nil
+	}
+	return CPUStat{}, fmt.Errorf("no cpu line in %s", ProcStat)
+}
+
+// GetMemoryUsage returns the memory used and the total memory of the host,
+// in bytes, from /proc/meminfo.
+var GetMemoryUsage = func() (used, total uint64, err error) {
+	buf, err := ReadFile(ProcMeminfo)
+	if err != nil {
+		return 0, 0, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = v * 1024 // kB
+	}
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, fmt.Errorf("no MemTotal in %s", ProcMeminfo)
+	}
+	available, ok := values["MemAvailable"]
+	if !ok { // before Linux 3.14
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	if available > total {
+		available = total
+	}
+	return total - available, total, nil
+}
+
+// GetDiskStat returns the bytes read from and written to all disks since
+// boot, from /proc/diskstats. Partitions are skipped, as their I/O is also
+// counted against their disk, and so is the I/O of md (software RAID)
+// members, as it's also counted against their array.
+var GetDiskStat = func() (DiskStat, error) {
+	buf, err := ReadFile(ProcDiskstats)
+	if err != nil {
+		return DiskStat{}, err
+	}
+	var (
+		members                                  = mdMembers()
+		disks                                    []string
+		read, written, memberRead, memberWritten uint64
+	)
+outer:
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		// Device mapper devices (e.g. LVM volumes) are skipped, as their
+		// I/O is counted against the underlying disks.
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "dm-") {
+			continue
+		}
+		r, err := strconv.ParseUint(fields[5], 10, 64)
+		if err != nil {
+			return DiskStat{}, err
+		}
+		w, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return DiskStat{}, err
+		}
+		// A member may be a partition, whose I/O is counted against its
+		// disk, so it's taken off the total rather than just skipped.
+		if members[name] {
+			memberRead += r
+			memberWritten += w
+		}
+		for _, disk := range disks {
+			if isPartition(name, disk) {
+				continue outer
+			}
+		}
+		disks = append(disks, name)
+		read += r
+		written += w
+	}
+	if read < memberRead || written < memberWritten {
+		return DiskStat{}, fmt.Errorf("md members did more I/O than all disks")
+	}
+	return DiskStat{
+		ReadBytes:  (read - memberRead) * diskSectorSize,
+		WriteBytes: (written - memberWritten) * diskSectorSize,
+	}, nil
+}
+
+// mdMembers returns the names of the devices which make up md arrays, from
+// /proc/mdstat, where arrays are listed like
+//
+//	md0 : active raid1 sdb1[1] sda1[0]
+//
+// If it can't be read, there are assumed to be none.
+func mdMembers() map[string]bool {
+	members := map[string]bool{}
+	buf, err := ReadFile(ProcMdstat)
+	if err != nil {
+		return members
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "md") || fields[1] != ":" {
+			continue
+		}
+		for _, field := range fields[2:] {
+			if i := strings.IndexByte(field, '['); i > 0 {
+				members[field[:i]] = true
+			}
+		}
+	}
+	return members
+}
+
+// isPartition says whether name is a partition of disk. Disks are listed
+// before their partitions, which are named after them, e.g. sda and sda1, or
+// nvme0n1 and nvme0n1p1.
+func isPartition(name, disk string) bool {
+	if !strings.HasPrefix(name, disk) {
+		return false
+	}
+	suffix := strings.TrimPrefix(strings.TrimPrefix(name, disk), "p")
+	if suffix == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(suffix, 10, 64)
+	return err == nil
+}
+
+// GetInterfaceStats returns the bytes received and transmitted by each
+// physical network interface since boot, from /proc/net/dev. Loopback and
+// virtual interfaces, such as veths and bridges, are skipped: there can be
+// very many of them, and their traffic mostly crosses a physical one too.
+var GetInterfaceStats = func() (map[string]InterfaceStat, error) {
+	buf, err := ReadFile(ProcNetDev)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]InterfaceStat{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue // headers
+		}
+		name := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+		if name == "lo" || len(fields) < 9 || isVirtualInterface(name) {
+			continue
+		}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stats[name] = InterfaceStat{RxBytes: rx, TxBytes: tx}
+	}
+	return stats, nil
+}
+
+// isVirtualInterface says whether the network interface is virtual, which
+// sysfs shows by putting it under /sys/devices/virtual. If sysfs can't be
+// read, veths, which are the bulk of them, are recognised by name.
+func isVirtualInterface(name string) bool {
+	target, err := Readlink(path.Join(SysClassNet, name))
+	if err != nil {
+		return strings.HasPrefix(name, "veth")
+	}
+	return strings.Contains(target, "/devices/virtual/")
+}
@@ -2,10 +2,13 @@ package host_test
 
 import (
 	"fmt"
+	"os"
+	"reflect"
 	"syscall"
 	"testing"
 
 	"github.com/weaveworks/scope/probe/host"
+	"github.com/weaveworks/scope/test"
 )
 
 func TestUname(t *testing.T) {
@@ -38,3 +41,98 @@ func string2c(s string) [65]int8 {
 	}
 	return result
 }
+
+func TestResourceUsage(t *testing.T) {
+	oldReadFile, oldReadlink := host.ReadFile, host.Readlink
+	defer func() { host.ReadFile, host.Readlink = oldReadFile, oldReadlink }()
+
+	files := map[string]string{
+		host.ProcStat: `cpu  100 10 50 800 40 0 5 0 20 0
+cpu0 100 10 50 800 40 0 5 0 20 0
+intr 12345
+`,
+		host.ProcMeminfo: `MemTotal:        4000 kB
+MemFree:          500 kB
+MemAvailable:    1000 kB
+Buffers:          100 kB
+Cached:           700 kB
+`,
+		host.ProcDiskstats: `   7       0 loop0 9 0 9 0 0 0 0 0 0 0 0
+   8       0 sda 100 0 10 0 50 0 20 0 0 0 0
+   8       1 sda1 90 0 8 0 40 0 16 0 0 0 0
+ 259       0 nvme0n1 10 0 4 0 5 0 2 0 0 0 0
+ 259       1 nvme0n1p1 10 0 4 0 5 0 2 0 0 0 0
+ 253       0 dm-0 90 0 8 0 40 0 16 0 0 0 0
+   8      16 sdb 30 0 6 0 30 0 12 0 0 0 0
+   8      32 sdc 30 0 6 0 30 0 12 0 0 0 0
+   8      33 sdc1 30 0 6 0 30 0 12 0 0 0 0
+   9       0 md0 30 0 6 0 30 0 12 0 0 0 0
+`,
+		host.ProcMdstat: `Personalities : [raid1]
+md0 : active raid1 sdc1[1] sdb[0]
+      1048512 blocks [2/2] [UU]
+
+unused devices: <none>
+`,
+		host.ProcNetDev: `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 500 5 0 0 0 0 0 0 500 5 0 0 0 0 0 0
+  eth0:1000 10 0 0 0 0 0 0 2000 20 0 0 0 0 0 0
+docker0: 300 3 0 0 0 0 0 0 400 4 0 0 0 0 0 0
+veth1a2b3c: 300 3 0 0 0 0 0 0 400 4 0 0 0 0 0 0
+`,
+	}
+	links := map[string]string{
+		host.SysClassNet + "/eth0":    "../../devices/pci0000:00/0000:00:03.0/net/eth0",
+		host.SysClassNet + "/docker0": "../../devices/virtual/net/docker0",
+	}
+	host.Readlink = func(path string) (string, error) {
+		if target, ok := links[path]; ok {
+			return target, nil
+		}
+		return "", os.ErrNotExist
+	}
+	host.ReadFile = func(path string) ([]byte, error) {
+		if contents, ok := files[path]; ok {
+			return []byte(contents), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	cpu, err := host.GetCPUStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (host.CPUStat{Busy: 165, Total: 1005}); want != cpu {
+		t.Errorf("want %v, have %v", want, cpu)
+	}
+
+	used, total, err := host.GetMemoryUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(3000 * 1024); want != used {
+		t.Errorf("want %d used, have %d", want, used)
+	}
+	if want := uint64(4000 * 1024); want != total {
+		t.Errorf("want %d total, have %d", want, total)
+	}
+
+	disk, err := host.GetDiskStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The md array's I/O is counted, rather than its members'.
+	if want := (host.DiskStat{ReadBytes: 20 * 512, WriteBytes: 34 * 512}); want != disk {
+		t.Errorf("want %v, have %v", want, disk)
+	}
+
+	ifaces, err := host.GetInterfaceStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]host.InterfaceStat{"eth0": {RxBytes: 1000, TxBytes: 2000}}
+	if !reflect.DeepEqual(want, ifaces) {
+		t.Errorf("%s", test.Diff(want, ifaces))
+	}
+}
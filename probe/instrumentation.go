@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/weaveworks/scope/probe/endpoint"
+	"github.com/weaveworks/scope/xfer"
 )
 
 var (
@@ -23,5 +26,18 @@ var (
 func makePrometheusHandler() http.Handler {
 	prometheus.MustRegister(publishTicks)
 	prometheus.MustRegister(endpoint.SpyDuration)
+	prometheus.MustRegister(xfer.PublishQueueLength)
+	prometheus.MustRegister(xfer.PublishDropped)
 	return prometheus.Handler()
 }
+
+// makePublishersHandler returns a handler listing the publish targets, and
+// the result of the last publish to each of them, as JSON.
+func makePublishersHandler(publishers *xfer.MultiPublisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(publishers.Targets()); err != nil {
+			log.Print(err)
+		}
+	})
+}
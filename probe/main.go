@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
@@ -33,6 +34,13 @@ func main() {
 		token              = flag.String("token", "default-token", "probe token")
 		httpListen         = flag.String("http.listen", "", "listen address for HTTP profiling and instrumentation server")
 		publishInterval    = flag.Duration("publish.interval", 3*time.Second, "publish (output) interval")
+		publishQueue       = flag.Int("publish.queue", 20, "reports to hold in memory per app, while it's unreachable")
+		publishSpillDir    = flag.String("publish.spill.dir", "", "directory to spill reports to when the publish queue is full (optional)")
+		publishSpillMax    = flag.Int("publish.spill.max", 200, "reports to hold on disk per app, while it's unreachable")
+		publishBackoffMin  = flag.Duration("publish.backoff.min", time.Second, "initial delay before retrying a failed publish")
+		publishBackoffMax  = flag.Duration("publish.backoff.max", time.Minute, "maximum delay between retries of a failed publish")
+		publishSnapshots   = flag.Int("publish.snapshot.every", 10, "publish a full report every N publishes, and deltas in between (1 for always full reports)")
+		publishTransport   = flag.String("publish.transport", "websocket", "how to publish reports to apps: websocket (a persistent connection, which apps also send control requests along; apps without websocket support are sent POSTs instead) or http (a POST per report; no controls)")
 		spyInterval        = flag.Duration("spy.interval", time.Second, "spy (scan) interval")
 		prometheusEndpoint = flag.String("prometheus.endpoint", "/metrics", "Prometheus metrics exposition endpoint (requires -http.listen)")
 		spyProcs           = flag.Bool("processes", true, "report processes (needs root)")
@@ -47,6 +55,13 @@ func main() {
 		captureOff         = flag.Duration("capture.off", 5*time.Second, "packet capture duty cycle 'off'")
 		printVersion       = flag.Bool("version", false, "print version number and exit")
 		useConntrack       = flag.Bool("conntrack", true, "also use conntrack to track connections")
+		conntrackUDP       = flag.Bool("conntrack.udp", true, "also use conntrack to track UDP flows")
+		conntrackUDPMin    = flag.Duration("conntrack.udp.min-duration", 0, "ignore UDP flows which last less than this")
+		conntrackAcct      = flag.Bool("conntrack.accounting", false, "turn on conntrack accounting for the whole host, to count traffic")
+		useTLS             = flag.Bool("tls", false, "publish to apps over HTTPS")
+		tlsCA              = flag.String("tls.ca", "", "CA bundle to verify apps' certificates with (default: system roots)")
+		tlsCert            = flag.String("tls.cert", "", "client certificate to present to apps (optional)")
+		tlsKey             = flag.String("tls.key", "", "private key for -tls.cert")
 	)
 	flag.Parse()
 
@@ -72,6 +87,7 @@ func main() {
 	if *httpListen != "" {
 		log.Printf("profiling data being exported to %s", *httpListen)
 		log.Printf("go tool pprof http://%s/debug/pprof/{profile,heap,block}", *httpListen)
+		log.Printf("publish targets listed at %s/publishers", *httpListen)
 		if *prometheusEndpoint != "" {
 			log.Printf("exposing Prometheus endpoint at %s%s", *httpListen, *prometheusEndpoint)
 			http.Handle(*prometheusEndpoint, makePrometheusHandler())
@@ -86,9 +102,45 @@ func main() {
 		log.Printf("warning: process reporting enabled, but that requires root to find everything")
 	}
 
-	publisherFactory := func(target string) (xfer.Publisher, error) { return xfer.NewHTTPPublisher(target, *token, probeID) }
-	publishers := xfer.NewMultiPublisher(publisherFactory)
-	resolver := newStaticResolver(targets, publishers.Add)
+	var tlsConfig *tls.Config
+	if *useTLS {
+		var err error
+		if tlsConfig, err = xfer.ClientTLSConfig(*tlsCA, *tlsCert, *tlsKey); err != nil {
+			log.Fatalf("failed to load TLS configuration: %v", err)
+		}
+	}
+
+	// Apps can only send control requests to probes publishing over
+	// websockets.
+	controls := newControlRegistry()
+	var publisherFactory func(string) (xfer.Publisher, error)
+	switch *publishTransport {
+	case "http":
+		publisherFactory = func(target string) (xfer.Publisher, error) {
+			return xfer.NewHTTPPublisher(target, *token, probeID, tlsConfig)
+		}
+	case "websocket":
+		publisherFactory = func(target string) (xfer.Publisher, error) {
+			p, err := xfer.NewWebsocketPublisher(target, *token, probeID, tlsConfig)
+			if err != nil {
+				return nil, err
+			}
+			p.HandleControl(controls.Handle)
+			return p, nil
+		}
+	default:
+		log.Fatalf("unknown publish transport %q", *publishTransport)
+	}
+	publishers := xfer.NewBufferedMultiPublisher(publisherFactory, xfer.QueueConfig{
+		MaxReports:    *publishQueue,
+		SpillDir:      *publishSpillDir,
+		MaxSpilled:    *publishSpillMax,
+		MinBackoff:    *publishBackoffMin,
+		MaxBackoff:    *publishBackoffMax,
+		SnapshotEvery: *publishSnapshots,
+	})
+	resolver := newStaticResolver(targets, publishers.Set)
+	http.Handle("/publishers", makePublishersHandler(publishers))
 	defer resolver.Stop()
 
 	addrs, err := net.InterfaceAddrs()
@@ -104,7 +156,8 @@ func main() {
 	}
 
 	var (
-		endpointReporter = endpoint.NewReporter(hostID, hostName, *spyProcs, *useConntrack)
+		udpPolicy        = endpoint.UDPPolicy{Track: *conntrackUDP, MinDuration: *conntrackUDPMin}
+		endpointReporter = endpoint.NewReporter(hostID, hostName, *procRoot, *spyProcs, *useConntrack, *conntrackAcct, udpPolicy)
 		processCache     = process.NewCachingWalker(process.NewWalker(*procRoot))
 		reporters        = []Reporter{
 			endpointReporter,
@@ -114,6 +167,7 @@ func main() {
 		taggers = []Tagger{newTopologyTagger(), host.NewTagger(hostID)}
 	)
 	defer endpointReporter.Stop()
+	controls.Register(process.NewController(*procRoot))
 
 	if *dockerEnabled {
 		if err := report.AddLocalBridge(*dockerBridge); err != nil {
@@ -127,7 +181,12 @@ func main() {
 		defer dockerRegistry.Stop()
 
 		taggers = append(taggers, docker.NewTagger(dockerRegistry, processCache))
-		reporters = append(reporters, docker.NewReporter(dockerRegistry, hostID))
+		reporters = append(reporters, docker.NewReporter(dockerRegistry, hostID, probeID))
+		dockerController, err := docker.NewController(dockerRegistry)
+		if err != nil {
+			log.Fatalf("failed to start docker controller: %v", err)
+		}
+		controls.Register(dockerController)
 	}
 
 	if *weaveRouterAddr != "" {
@@ -173,7 +232,11 @@ func main() {
 			case <-pubTick:
 				publishTicks.WithLabelValues().Add(1)
 				r.Window = *publishInterval
-				if err := publishers.Publish(r); err != nil {
+				if err := publishers.PublishEnvelope(xfer.Envelope{
+					ProbeVersion: version,
+					Hostname:     hostName,
+					Report:       r,
+				}); err != nil {
 					log.Printf("publish: %v", err)
 				}
 				r = report.MakeReport()
@@ -202,6 +202,9 @@ func sanitize(scheme string, port int, path string) func(string) (string, error)
 		if s == "" {
 			return "", fmt.Errorf("no host")
 		}
+		if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+			s = "[" + s + "]" // a bare IPv6 address
+		}
 		if !strings.HasPrefix(s, "http") {
 			s = scheme + s
 		}
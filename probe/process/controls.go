@@ -0,0 +1,51 @@
+package process
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+// DetailsAction is the control action which fetches a process' details.
+// It takes the process' PID as the pid argument. The environment often holds
+// secrets, so it's only included if the env argument is "true".
+const DetailsAction = "process.details"
+
+// Arguments of DetailsAction.
+const (
+	PIDArg = "pid"
+	EnvArg = "env"
+)
+
+// Details are the details of a process that are too big, or too sensitive,
+// to include in every report.
+type Details struct {
+	PID     int      `json:"pid"`
+	Comm    string   `json:"comm"`
+	Cmdline []string `json:"cmdline"`
+	Env     []string `json:"env,omitempty"`
+}
+
+// Controller performs actions on processes.
+type Controller struct {
+	procRoot string
+}
+
+// NewController returns a Controller for the processes found in procRoot.
+func NewController(procRoot string) *Controller {
+	return &Controller{procRoot: procRoot}
+}
+
+// Controls implements Controller.
+func (c *Controller) Controls() map[string]xfer.ControlHandler {
+	return map[string]xfer.ControlHandler{
+		DetailsAction: func(args map[string]string) (interface{}, error) {
+			pid, err := strconv.Atoi(args[PIDArg])
+			if err != nil {
+				return nil, fmt.Errorf("invalid pid %q", args[PIDArg])
+			}
+			return c.details(pid, args[EnvArg] == "true")
+		},
+	}
+}
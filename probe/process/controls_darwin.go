@@ -0,0 +1,7 @@
+package process
+
+import "fmt"
+
+func (c *Controller) details(pid int, withEnv bool) (Details, error) {
+	return Details{}, fmt.Errorf("process details not supported on darwin")
+}
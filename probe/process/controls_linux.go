@@ -0,0 +1,43 @@
+package process
+
+import (
+	"bytes"
+	"path"
+	"strconv"
+)
+
+func (c *Controller) details(pid int, withEnv bool) (Details, error) {
+	dir := path.Join(c.procRoot, strconv.Itoa(pid))
+	comm, err := ReadFile(path.Join(dir, "comm"))
+	if err != nil {
+		return Details{}, err
+	}
+	cmdline, err := ReadFile(path.Join(dir, "cmdline"))
+	if err != nil {
+		return Details{}, err
+	}
+	details := Details{
+		PID:     pid,
+		Comm:    string(bytes.TrimSpace(comm)),
+		Cmdline: splitNull(cmdline),
+	}
+	if withEnv {
+		environ, err := ReadFile(path.Join(dir, "environ"))
+		if err != nil {
+			return Details{}, err
+		}
+		details.Env = splitNull(environ)
+	}
+	return details, nil
+}
+
+// splitNull splits a list of NUL-terminated strings, as found in /proc.
+func splitNull(buf []byte) []string {
+	result := []string{}
+	for _, s := range bytes.Split(buf, []byte{'\000'}) {
+		if len(s) > 0 {
+			result = append(result, string(s))
+		}
+	}
+	return result
+}
@@ -0,0 +1,58 @@
+package process_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/weaveworks/scope/probe/process"
+	"github.com/weaveworks/scope/test"
+)
+
+func TestDetailsControl(t *testing.T) {
+	oldReadFile := process.ReadFile
+	defer func() { process.ReadFile = oldReadFile }()
+
+	files := map[string]string{
+		"/proc/3/comm":    "curl\n",
+		"/proc/3/cmdline": "curl\000google.com\000",
+		"/proc/3/environ": "HOME=/root\000TERM=xterm\000",
+	}
+	process.ReadFile = func(path string) ([]byte, error) {
+		contents, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("not found")
+		}
+		return []byte(contents), nil
+	}
+
+	details := process.NewController("/proc").Controls()[process.DetailsAction]
+	have, err := details(map[string]string{process.PIDArg: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := process.Details{
+		PID:     3,
+		Comm:    "curl",
+		Cmdline: []string{"curl", "google.com"},
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// The environment is only included on request.
+	have, err = details(map[string]string{process.PIDArg: "3", process.EnvArg: "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Env = []string{"HOME=/root", "TERM=xterm"}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	for _, args := range []map[string]string{{}, {"pid": "foo"}, {"pid": "4"}} {
+		if _, err := details(args); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+}
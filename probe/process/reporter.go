@@ -1,7 +1,10 @@
 package process
 
 import (
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/weaveworks/scope/report"
 )
@@ -13,6 +16,22 @@ const (
 	PPID    = "ppid"
 	Cmdline = "cmdline"
 	Threads = "threads"
+
+	// Resource usage. CPUUsage and MemoryUsage are also kept as metrics.
+	CPUUsage      = "cpu_usage_percent"
+	MemoryUsage   = "memory_usage" // resident, in bytes
+	VirtualMemory = "virtual_memory"
+	OpenFiles     = "open_files_count"
+	StartTime     = "start_time"
+
+	// Ownership and isolation. Namespaces are identified by inode number.
+	UID            = "uid"
+	User           = "user"
+	Cgroup         = "cgroup"
+	SystemdUnit    = "systemd_unit"
+	NetNamespace   = "net_namespace"
+	PIDNamespace   = "pid_namespace"
+	MountNamespace = "mnt_namespace"
 )
 
 // Reporter generates Reports containing the Process topology.
@@ -41,7 +60,10 @@ func (r *Reporter) Report() (report.Report, error) {
 }
 
 func (r *Reporter) processTopology() (report.Topology, error) {
-	t := report.MakeTopology()
+	var (
+		t   = report.MakeTopology()
+		now = Now()
+	)
 	err := r.walker.Walk(func(p Process) {
 		pidstr := strconv.Itoa(p.PID)
 		nodeID := report.MakeProcessNodeID(r.scope, pidstr)
@@ -59,7 +81,53 @@ func (r *Reporter) processTopology() (report.Topology, error) {
 		if p.PPID > 0 {
 			t.Nodes[nodeID].Metadata[PPID] = strconv.Itoa(p.PPID)
 		}
+		if p.User != "" {
+			t.Nodes[nodeID].Metadata[UID] = strconv.Itoa(p.UID)
+			t.Nodes[nodeID].Metadata[User] = p.User
+		}
+		if p.Cgroup != "" {
+			t.Nodes[nodeID].Metadata[Cgroup] = p.Cgroup
+			if unit, ok := ExtractSystemdUnit(p.Cgroup); ok {
+				t.Nodes[nodeID].Metadata[SystemdUnit] = unit
+			}
+		}
+		for _, tuple := range []struct {
+			key   string
+			inode uint64
+		}{
+			{NetNamespace, p.NetNamespace},
+			{PIDNamespace, p.PIDNamespace},
+			{MountNamespace, p.MountNamespace},
+		} {
+			if tuple.inode != 0 {
+				t.Nodes[nodeID].Metadata[tuple.key] = strconv.FormatUint(tuple.inode, 10)
+			}
+		}
+
+		// Walkers which know the start time know the rest of the resource
+		// usage too. There are many processes, so only the latest sample of
+		// each metric is sent; merging the reports in the app's window gives
+		// a short history.
+		if !p.StartTime.IsZero() {
+			t.Nodes[nodeID].Metadata[StartTime] = p.StartTime.UTC().Format(time.RFC3339)
+			t.Nodes[nodeID].Metadata[CPUUsage] = strconv.FormatFloat(p.CPUUsage, 'f', -1, 64)
+			t.Nodes[nodeID].Metadata[MemoryUsage] = strconv.FormatUint(p.RSS, 10)
+			t.Nodes[nodeID].Metadata[VirtualMemory] = strconv.FormatUint(p.VirtualMemory, 10)
+			t.Nodes[nodeID].Metadata[OpenFiles] = strconv.Itoa(p.OpenFiles)
+			t.Nodes[nodeID].Metrics[CPUUsage] = report.MakeMetric(now, p.CPUUsage)
+			t.Nodes[nodeID].Metrics[MemoryUsage] = report.MakeMetric(now, float64(p.RSS))
+		}
 	})
 
 	return t, err
 }
+
+// ExtractSystemdUnit returns the systemd service a process belongs to, given
+// its cgroup, e.g. nginx.service for /system.slice/nginx.service.
+func ExtractSystemdUnit(cgroup string) (string, bool) {
+	unit := path.Base(cgroup)
+	if !strings.HasSuffix(unit, ".service") {
+		return "", false
+	}
+	return unit, true
+}
@@ -3,6 +3,7 @@ package process_test
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/report"
@@ -21,6 +22,11 @@ func (m *mockWalker) Walk(f func(process.Process)) error {
 }
 
 func TestReporter(t *testing.T) {
+	oldNow := process.Now
+	defer func() { process.Now = oldNow }()
+	now := time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
+	process.Now = func() time.Time { return now }
+
 	walker := &mockWalker{
 		processes: []process.Process{
 			{PID: 1, PPID: 0, Comm: "init"},
@@ -28,6 +34,12 @@ func TestReporter(t *testing.T) {
 			{PID: 3, PPID: 1, Comm: "apache", Threads: 2},
 			{PID: 4, PPID: 2, Comm: "ping", Cmdline: "ping foo.bar.local"},
 			{PID: 5, PPID: 1, Cmdline: "tail -f /var/log/syslog"},
+			{
+				PID: 6, PPID: 1, Comm: "java", CPUUsage: 12.5, RSS: 1 << 20, VirtualMemory: 1 << 30,
+				OpenFiles: 42, StartTime: now.Add(-time.Hour),
+				UID: 1000, User: "alice", Cgroup: "/system.slice/java.service",
+				NetNamespace: 1, PIDNamespace: 2, MountNamespace: 3,
+			},
 		},
 	}
 
@@ -65,6 +77,27 @@ func TestReporter(t *testing.T) {
 				process.Cmdline: "tail -f /var/log/syslog",
 				process.Threads: "0",
 			}),
+			report.MakeProcessNodeID("", "6"): report.MakeNodeWith(map[string]string{
+				process.PID:            "6",
+				process.Comm:           "java",
+				process.PPID:           "1",
+				process.Threads:        "0",
+				process.CPUUsage:       "12.5",
+				process.MemoryUsage:    "1048576",
+				process.VirtualMemory:  "1073741824",
+				process.OpenFiles:      "42",
+				process.StartTime:      "2015-08-20T11:00:00Z",
+				process.UID:            "1000",
+				process.User:           "alice",
+				process.Cgroup:         "/system.slice/java.service",
+				process.SystemdUnit:    "java.service",
+				process.NetNamespace:   "1",
+				process.PIDNamespace:   "2",
+				process.MountNamespace: "3",
+			}).WithMetrics(report.Metrics{
+				process.CPUUsage:    report.MakeMetric(now, 12.5),
+				process.MemoryUsage: report.MakeMetric(now, 1<<20),
+			}),
 		},
 	}
 
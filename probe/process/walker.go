@@ -1,6 +1,9 @@
 package process
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // Process represents a single process.
 type Process struct {
@@ -8,8 +11,29 @@ type Process struct {
 	Comm      string
 	Cmdline   string
 	Threads   int
+
+	// Resource usage. Walkers which can't tell leave these zero. CPUUsage
+	// is only set by the CachingWalker, as it needs two walks to work out.
+	UserTime      time.Duration // total CPU time spent in user mode
+	SystemTime    time.Duration // total CPU time spent in kernel mode
+	CPUUsage      float64       // percent of a single CPU, since the previous walk
+	RSS           uint64        // resident memory, in bytes
+	VirtualMemory uint64        // in bytes
+	OpenFiles     int
+	StartTime     time.Time
+
+	// Ownership and isolation. Walkers which can't tell leave these empty.
+	UID            int
+	User           string // the owner's name, or its UID if it has none
+	Cgroup         string // path in the systemd (or unified) hierarchy
+	NetNamespace   uint64 // namespaces, by inode number
+	PIDNamespace   uint64
+	MountNamespace uint64
 }
 
+// Now is exposed for mocking.
+var Now = time.Now
+
 // Walker is something that walks the /proc directory
 type Walker interface {
 	Walk(func(Process)) error
@@ -21,6 +45,7 @@ type CachingWalker struct {
 	cache     []Process
 	cacheLock sync.RWMutex
 	source    Walker
+	updated   time.Time
 }
 
 // NewCachingWalker returns a new CachingWalker
@@ -39,9 +64,11 @@ func (c *CachingWalker) Walk(f func(Process)) error {
 	return nil
 }
 
-// Update updates cached copy of process list
+// Update updates cached copy of process list, working out the CPU usage of
+// processes which were also in the previous copy.
 func (c *CachingWalker) Update() error {
 	newCache := []Process{}
+	now := Now()
 	err := c.source.Walk(func(p Process) {
 		newCache = append(newCache, p)
 	})
@@ -51,6 +78,23 @@ func (c *CachingWalker) Update() error {
 
 	c.cacheLock.Lock()
 	defer c.cacheLock.Unlock()
+	if elapsed := now.Sub(c.updated); !c.updated.IsZero() && elapsed > 0 {
+		previous := make(map[int]Process, len(c.cache))
+		for _, p := range c.cache {
+			previous[p.PID] = p
+		}
+		for i, p := range newCache {
+			prev, ok := previous[p.PID]
+			if !ok || !prev.StartTime.Equal(p.StartTime) { // new, or PID reused
+				continue
+			}
+			cpu := (p.UserTime + p.SystemTime) - (prev.UserTime + prev.SystemTime)
+			if cpu > 0 {
+				newCache[i].CPUUsage = float64(cpu) / float64(elapsed) * 100
+			}
+		}
+	}
 	c.cache = newCache
+	c.updated = now
 	return nil
 }
@@ -2,25 +2,43 @@ package process
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"os/user"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Hooks exposed for mocking
 var (
-	ReadDir  = ioutil.ReadDir
-	ReadFile = ioutil.ReadFile
+	ReadDir      = ioutil.ReadDir
+	ReadDirNames = readDirNames
+	ReadFile     = ioutil.ReadFile
+	Readlink     = os.Readlink
+	LookupUser   = func(uid int) (string, error) {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	}
 )
 
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat. It's
+// 100 on all the architectures we care about.
+const clockTicks = 100
+
 type walker struct {
 	procRoot string
+	users    map[int]string // UID -> user name, as looked up already
 }
 
 // NewWalker creates a new process Walker.
 func NewWalker(procRoot string) Walker {
-	return &walker{procRoot: procRoot}
+	return &walker{procRoot: procRoot, users: map[int]string{}}
 }
 
 // Walk walks the supplied directory (expecting it to look like /proc)
@@ -32,6 +50,7 @@ func (w *walker) Walk(f func(Process)) error {
 	if err != nil {
 		return err
 	}
+	bootTime := w.bootTime()
 
 	for _, dirEntry := range dirEntries {
 		filename := dirEntry.Name()
@@ -66,14 +85,148 @@ func (w *walker) Walk(f func(Process)) error {
 			comm = strings.TrimSpace(string(commBuf))
 		}
 
-		f(Process{
+		p := Process{
 			PID:     pid,
 			PPID:    ppid,
 			Comm:    comm,
 			Cmdline: cmdline,
 			Threads: threads,
-		})
+		}
+		if len(splits) > 23 {
+			p.UserTime = ticks(splits[13])
+			p.SystemTime = ticks(splits[14])
+			if !bootTime.IsZero() {
+				p.StartTime = bootTime.Add(ticks(splits[21]))
+			}
+			p.VirtualMemory, _ = strconv.ParseUint(splits[22], 10, 64)
+			if rss, err := strconv.ParseUint(splits[23], 10, 64); err == nil {
+				p.RSS = rss * uint64(os.Getpagesize())
+			}
+		}
+		if fds, err := ReadDirNames(path.Join(w.procRoot, filename, "fd")); err == nil {
+			p.OpenFiles = len(fds)
+		}
+		if uid, ok := w.uid(filename); ok {
+			p.UID, p.User = uid, w.user(uid)
+		}
+		p.Cgroup = w.cgroup(filename)
+		p.NetNamespace = w.namespace(filename, "net")
+		p.PIDNamespace = w.namespace(filename, "pid")
+		p.MountNamespace = w.namespace(filename, "mnt")
+		f(p)
 	}
 
 	return nil
 }
+
+// readDirNames lists the names in a directory, without lstat-ing each entry
+// as ioutil.ReadDir does; for counting fds that's most of the work.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// bootTime reads the time the host booted, which process start times are
+// relative to, from /proc/stat.
+func (w *walker) bootTime() time.Time {
+	buf, err := ReadFile(path.Join(w.procRoot, "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "btime" {
+			if btime, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return time.Unix(btime, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// uid reads the real UID of the process from /proc/<pid>/status.
+func (w *walker) uid(pid string) (int, bool) {
+	buf, err := ReadFile(path.Join(w.procRoot, pid, "status"))
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "Uid:" {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[1])
+		return uid, err == nil
+	}
+	return 0, false
+}
+
+// user returns the name of the user with the given UID, or the UID if it has
+// no name. Note the probe may not share the host's users.
+func (w *walker) user(uid int) string {
+	if name, ok := w.users[uid]; ok {
+		return name
+	}
+	name, err := LookupUser(uid)
+	if err != nil || name == "" {
+		name = strconv.Itoa(uid)
+	}
+	w.users[uid] = name
+	return name
+}
+
+// cgroup reads the process's cgroup from /proc/<pid>/cgroup. Each line is
+// hierarchy-ID:controllers:path; systemd's hierarchy, or failing that the
+// unified one, is the most useful, as it names services and containers.
+func (w *walker) cgroup(pid string) string {
+	buf, err := ReadFile(path.Join(w.procRoot, pid, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	var unified, first string
+	for _, line := range strings.Split(string(buf), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[1] == "name=systemd":
+			return fields[2]
+		case fields[0] == "0" && fields[1] == "":
+			unified = fields[2]
+		case first == "":
+			first = fields[2]
+		}
+	}
+	if unified != "" {
+		return unified
+	}
+	return first
+}
+
+// namespace reads the inode number of one of the process's namespaces from
+// the /proc/<pid>/ns/<kind> symlink, which looks like net:[4026531956].
+func (w *walker) namespace(pid, kind string) uint64 {
+	target, err := Readlink(path.Join(w.procRoot, pid, "ns", kind))
+	if err != nil {
+		return 0
+	}
+	var inode uint64
+	if _, err := fmt.Sscanf(target, kind+":[%d]", &inode); err != nil {
+		return 0
+	}
+	return inode
+}
+
+// ticks converts a time in clock ticks to a duration.
+func ticks(s string) time.Duration {
+	t, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(t) * time.Second / clockTicks
+}
@@ -25,10 +25,13 @@ func (p mockProcess) IsDir() bool        { return true }
 func (p mockProcess) Sys() interface{}   { return nil }
 
 func TestWalker(t *testing.T) {
-	oldReadDir, oldReadFile := process.ReadDir, process.ReadFile
+	oldReadDir, oldReadDirNames, oldReadFile, oldReadlink, oldLookupUser := process.ReadDir, process.ReadDirNames, process.ReadFile, process.Readlink, process.LookupUser
 	defer func() {
 		process.ReadDir = oldReadDir
+		process.ReadDirNames = oldReadDirNames
 		process.ReadFile = oldReadFile
+		process.Readlink = oldReadlink
+		process.LookupUser = oldLookupUser
 	}()
 
 	processes := map[string]mockProcess{
@@ -47,7 +50,17 @@ func TestWalker(t *testing.T) {
 		return result, nil
 	}
 
+	process.ReadDirNames = func(path string) ([]string, error) {
+		if !strings.HasSuffix(path, "/fd") {
+			return nil, fmt.Errorf("not found")
+		}
+		return []string{"0", "1", "2"}, nil // every process has 3 open files
+	}
+
 	process.ReadFile = func(path string) ([]byte, error) {
+		if path == "unused/stat" {
+			return []byte("cpu  1 2 3 4\nbtime 1440072000\n"), nil
+		}
 		splits := strings.Split(path, "/")
 
 		pid := splits[len(splits)-2]
@@ -63,20 +76,47 @@ func TestWalker(t *testing.T) {
 		case "stat":
 			pid, _ := strconv.Atoi(splits[len(splits)-2])
 			parent := pid - 1
-			return []byte(fmt.Sprintf("%d na R %d 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 1", pid, parent)), nil
+			// utime 150 and stime 50 ticks, started 1000 ticks after boot, with
+			// 4096 bytes of virtual memory, and 2 pages resident
+			return []byte(fmt.Sprintf("%d na R %d 0 0 0 0 0 0 0 0 0 150 50 0 0 0 0 1 0 1000 4096 2", pid, parent)), nil
 		case "cmdline":
 			return []byte(process.cmdline), nil
+		case "status":
+			return []byte("Name:\tx\nUid:\t1000\t1000\t1000\t1000\nGid:\t1000\t1000\t1000\t1000\n"), nil
+		case "cgroup":
+			return []byte("4:memory:/system.slice/apache.service\n1:name=systemd:/system.slice/apache.service\n0::/system.slice/apache.service\n"), nil
 		}
 
 		return nil, fmt.Errorf("not found")
 	}
 
+	process.Readlink = func(path string) (string, error) {
+		kind := path[strings.LastIndex(path, "/")+1:]
+		return fmt.Sprintf("%s:[%d]", kind, map[string]int{"net": 1, "pid": 2, "mnt": 3}[kind]), nil
+	}
+
+	process.LookupUser = func(uid int) (string, error) {
+		return "alice", nil
+	}
+
 	want := map[int]process.Process{
 		3: {PID: 3, PPID: 2, Comm: "curl", Cmdline: "curl google.com", Threads: 1},
 		2: {PID: 2, PPID: 1, Comm: "bash", Cmdline: "", Threads: 1},
 		4: {PID: 4, PPID: 3, Comm: "apache", Cmdline: "", Threads: 1},
 		1: {PID: 1, PPID: 0, Comm: "init", Cmdline: "", Threads: 1},
 	}
+	for pid, p := range want {
+		p.UserTime = 1500 * time.Millisecond
+		p.SystemTime = 500 * time.Millisecond
+		p.StartTime = time.Unix(1440072000+10, 0)
+		p.VirtualMemory = 4096
+		p.RSS = 2 * uint64(os.Getpagesize())
+		p.OpenFiles = 3
+		p.UID, p.User = 1000, "alice"
+		p.Cgroup = "/system.slice/apache.service"
+		p.NetNamespace, p.PIDNamespace, p.MountNamespace = 1, 2, 3
+		want[pid] = p
+	}
 
 	have := map[int]process.Process{}
 	walker := process.NewWalker("unused")
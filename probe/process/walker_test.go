@@ -3,6 +3,7 @@ package process_test
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/test"
@@ -57,6 +58,44 @@ func TestCache(t *testing.T) {
 	}
 }
 
+func TestCacheCPUUsage(t *testing.T) {
+	oldNow := process.Now
+	defer func() { process.Now = oldNow }()
+	now := time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
+	process.Now = func() time.Time { return now }
+
+	var (
+		started = now.Add(-time.Hour)
+		walker  = &mockWalker{processes: []process.Process{
+			{PID: 1, UserTime: time.Second, SystemTime: time.Second, StartTime: started},
+			{PID: 2, UserTime: time.Second, StartTime: started},
+		}}
+		cachingWalker = process.NewCachingWalker(walker)
+	)
+	if err := cachingWalker.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Over 2 seconds, PID 1 uses another second of CPU time, and PID 2 is
+	// reused by a new process.
+	now = now.Add(2 * time.Second)
+	walker.processes = []process.Process{
+		{PID: 1, UserTime: 1500 * time.Millisecond, SystemTime: 1500 * time.Millisecond, StartTime: started},
+		{PID: 2, UserTime: 2 * time.Second, StartTime: now},
+	}
+	if err := cachingWalker.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := all(cachingWalker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{50, 0}; !reflect.DeepEqual(want, []float64{have[0].CPUUsage, have[1].CPUUsage}) {
+		t.Errorf("want CPU usage %v, have %v", want, have)
+	}
+}
+
 func all(w process.Walker) ([]process.Process, error) {
 	all := []process.Process{}
 	err := w.Walk(func(p process.Process) {
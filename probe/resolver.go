@@ -17,8 +17,10 @@ var (
 
 type staticResolver struct {
 	quit  chan struct{}
-	add   func(string)
+	done  chan struct{} // closed when loop has returned
+	set   func([]string)
 	peers []peer
+	known map[peer][]string // last successfully resolved targets, per peer
 }
 
 type peer struct {
@@ -27,15 +29,18 @@ type peer struct {
 }
 
 // NewResolver starts a new resolver that periodically
-// tries to resolve peers and the calls add() with all the
-// resolved IPs.  It explictiy supports hostnames which
-// resolve to multiple IPs; it will repeatedly call
-// add with the same IP, expecting the target to dedupe.
-func newStaticResolver(peers []string, add func(string)) staticResolver {
+// tries to resolve peers and then calls set() with the
+// full set of resolved IPs, so the target can add new
+// ones and remove stale ones. It explictiy supports
+// hostnames which resolve to multiple IPs. If a lookup
+// fails, the peer's previously resolved IPs are kept.
+func newStaticResolver(peers []string, set func([]string)) staticResolver {
 	r := staticResolver{
 		quit:  make(chan struct{}),
-		add:   add,
+		done:  make(chan struct{}),
+		set:   set,
 		peers: prepareNames(peers),
+		known: map[peer][]string{},
 	}
 	go r.loop()
 	return r
@@ -49,14 +54,21 @@ func prepareNames(strs []string) []peer {
 			port     string
 		)
 
-		if strings.Contains(s, ":") {
+		switch {
+		case net.ParseIP(s) != nil:
+			// A bare IP address; IPv6 addresses contain colons.
+			hostname, port = s, strconv.Itoa(xfer.AppPort)
+		case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+			// A bracketed IPv6 address, without a port.
+			hostname, port = s[1:len(s)-1], strconv.Itoa(xfer.AppPort)
+		case strings.Contains(s, ":"):
 			var err error
 			hostname, port, err = net.SplitHostPort(s)
 			if err != nil {
 				log.Printf("invalid address %s: %v", s, err)
 				continue
 			}
-		} else {
+		default:
 			hostname, port = s, strconv.Itoa(xfer.AppPort)
 		}
 
@@ -66,6 +78,7 @@ func prepareNames(strs []string) []peer {
 }
 
 func (r staticResolver) loop() {
+	defer close(r.done)
 	r.resolveHosts()
 	t := tick(time.Minute)
 	for {
@@ -80,6 +93,7 @@ func (r staticResolver) loop() {
 }
 
 func (r staticResolver) resolveHosts() {
+	var targets []string
 	for _, peer := range r.peers {
 		var addrs []net.IP
 		if addr := net.ParseIP(peer.hostname); addr != nil {
@@ -88,20 +102,24 @@ func (r staticResolver) resolveHosts() {
 			var err error
 			addrs, err = lookupIP(peer.hostname)
 			if err != nil {
+				targets = append(targets, r.known[peer]...)
 				continue
 			}
 		}
 
+		var resolved []string
 		for _, addr := range addrs {
-			// For now, ignore IPv6
-			if addr.To4() == nil {
-				continue
-			}
-			r.add(net.JoinHostPort(addr.String(), peer.port))
+			// JoinHostPort brackets IPv6 addresses.
+			resolved = append(resolved, net.JoinHostPort(addr.String(), peer.port))
 		}
+		r.known[peer] = resolved
+		targets = append(targets, resolved...)
 	}
+	r.set(targets)
 }
 
+// Stop stops the resolver, and waits for it to finish.
 func (r staticResolver) Stop() {
 	close(r.quit)
+	<-r.done
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net"
+	"reflect"
 	"runtime"
 	"sync"
 	"testing"
@@ -39,47 +40,54 @@ func TestResolver(t *testing.T) {
 	port := ":80"
 	ip1 := "192.168.0.1"
 	ip2 := "192.168.0.10"
-	adds := make(chan string)
-	add := func(s string) { adds <- s }
+	sets := make(chan []string)
+	set := func(s []string) { sets <- s }
 
-	r := newStaticResolver([]string{"symbolic.name" + port, "namewithnoport", ip1 + port, ip2}, add)
+	r := newStaticResolver([]string{"symbolic.name" + port, "namewithnoport", ip1 + port, ip2}, set)
 
-	assertAdd := func(want string) {
+	assertSet := func(want ...string) {
 		_, _, line, _ := runtime.Caller(1)
 		select {
-		case have := <-adds:
-			if want != have {
+		case have := <-sets:
+			if !reflect.DeepEqual(want, have) {
 				t.Errorf("line %d: want %q, have %q", line, want, have)
 			}
 		case <-time.After(100 * time.Millisecond):
-			t.Fatalf("line %d: didn't get add in time", line)
+			t.Fatalf("line %d: didn't get set in time", line)
 		}
 	}
 
 	// Initial resolve should just give us IPs
-	assertAdd(ip1 + port)
-	assertAdd(fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+	assertSet(ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
 
 	// Trigger another resolve with a tick; again,
 	// just want ips.
 	c <- time.Now()
-	assertAdd(ip1 + port)
-	assertAdd(fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+	assertSet(ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
 
 	ip3 := "1.2.3.4"
 	updateIPs("symbolic.name", makeIPs(ip3))
-	c <- time.Now()       // trigger a resolve
-	assertAdd(ip3 + port) // we want 1 add
-	assertAdd(ip1 + port)
-	assertAdd(fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+	c <- time.Now() // trigger a resolve
+	assertSet(ip3+port, ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
 
 	ip4 := "10.10.10.10"
 	updateIPs("symbolic.name", makeIPs(ip3, ip4))
-	c <- time.Now()       // trigger another resolve, this time with 2 adds
-	assertAdd(ip3 + port) // first add
-	assertAdd(ip4 + port) // second add
-	assertAdd(ip1 + port)
-	assertAdd(fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+	c <- time.Now() // trigger another resolve, this time with 2 IPs
+	assertSet(ip3+port, ip4+port, ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+
+	updateIPs("symbolic.name", makeIPs(ip4))
+	c <- time.Now() // ip3 is gone
+	assertSet(ip4+port, ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+
+	updateIPs("other.name", makeIPs(ip3))
+	c <- time.Now() // lookup fails, so ip4 is kept
+	assertSet(ip4+port, ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
+
+	// Hostnames resolving to IPv6 addresses are kept.
+	ip5 := "2001:db8::1"
+	updateIPs("symbolic.name", makeIPs(ip4, ip5))
+	c <- time.Now()
+	assertSet(ip4+port, "["+ip5+"]"+port, ip1+port, fmt.Sprintf("%s:%d", ip2, xfer.AppPort))
 
 	done := make(chan struct{})
 	go func() { r.Stop(); close(done) }()
@@ -90,6 +98,30 @@ func TestResolver(t *testing.T) {
 	}
 }
 
+func TestResolverIPv6(t *testing.T) {
+	oldTick := tick
+	defer func() { tick = oldTick }()
+	tick = func(_ time.Duration) <-chan time.Time { return make(chan time.Time) }
+
+	sets := make(chan []string)
+	r := newStaticResolver([]string{"::1", "[2001:db8::1]", "[2001:db8::2]:4041"}, func(s []string) { sets <- s })
+	defer r.Stop()
+
+	want := []string{
+		fmt.Sprintf("[::1]:%d", xfer.AppPort),
+		fmt.Sprintf("[2001:db8::1]:%d", xfer.AppPort),
+		"[2001:db8::2]:4041",
+	}
+	select {
+	case have := <-sets:
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("didn't get set in time")
+	}
+}
+
 func makeIPs(addrs ...string) []net.IP {
 	var ips []net.IP
 	for _, addr := range addrs {
@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
 )
 
 // Tagger tags nodes with value-add node metadata.
@@ -16,6 +17,20 @@ type Reporter interface {
 	Report() (report.Report, error)
 }
 
+// Controller performs actions on demand, when asked to by an app. Controls
+// returns the handler for each action, keyed by action name.
+type Controller interface {
+	Controls() map[string]xfer.ControlHandler
+}
+
+// PipeController is a Controller with actions which stream data over a pipe,
+// e.g. a container's logs. PipeControls returns the handler for each such
+// action.
+type PipeController interface {
+	Controller
+	PipeControls() map[string]xfer.PipeHandler
+}
+
 // Apply tags the report with all the taggers.
 func Apply(r report.Report, taggers []Tagger) report.Report {
 	var err error
@@ -2,10 +2,13 @@ package render
 
 import (
 	"fmt"
+	"net"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/weaveworks/scope/probe/docker"
+	"github.com/weaveworks/scope/probe/endpoint"
 	"github.com/weaveworks/scope/probe/host"
 	"github.com/weaveworks/scope/probe/overlay"
 	"github.com/weaveworks/scope/probe/process"
@@ -42,10 +45,11 @@ type Table struct {
 
 // Row is a single entry in a Table dataset.
 type Row struct {
-	Key        string `json:"key"`                   // e.g. Ingress
-	ValueMajor string `json:"value_major"`           // e.g. 25
-	ValueMinor string `json:"value_minor,omitempty"` // e.g. KB/s
-	Expandable bool   `json:"expandable,omitempty"`  // Whether it can be expanded (hidden by default)
+	Key        string        `json:"key"`                   // e.g. Ingress
+	ValueMajor string        `json:"value_major"`           // e.g. 25
+	ValueMinor string        `json:"value_minor,omitempty"` // e.g. KB/s
+	Expandable bool          `json:"expandable,omitempty"`  // Whether it can be expanded (hidden by default)
+	Metric     report.Metric `json:"metric,omitempty"`      // Recent history of the value, for sparklines
 }
 
 type sortableRows []Row
@@ -83,18 +87,19 @@ func MakeDetailedNode(r report.Report, n RenderableNode) DetailedNode {
 	// multiple origins. The ultimate goal here is to generate tables to view
 	// in the UI, so we skip the intermediate representations, but we could
 	// add them later.
-	connections := []Row{}
+	connections, listening := []Row{}, []Row{}
 	for _, id := range n.Origins {
 		if table, ok := OriginTable(r, id, multiHost, multiContainer); ok {
 			tables = append(tables, table)
 		} else if _, ok := r.Endpoint.Nodes[id]; ok {
 			connections = append(connections, connectionDetailsRows(r.Endpoint, id)...)
+			listening = append(listening, listeningRows(r.Endpoint, id)...)
 		} else if _, ok := r.Address.Nodes[id]; ok {
 			connections = append(connections, connectionDetailsRows(r.Address, id)...)
 		}
 	}
 
-	if table, ok := connectionsTable(connections, r, n); ok {
+	if table, ok := connectionsTable(connections, listening, r, n); ok {
 		tables = append(tables, table)
 	}
 
@@ -134,7 +139,7 @@ func getRenderingContext(r report.Report, n RenderableNode) (multiContainer, mul
 	return
 }
 
-func connectionsTable(connections []Row, r report.Report, n RenderableNode) (Table, bool) {
+func connectionsTable(connections, listening []Row, r report.Report, n RenderableNode) (Table, bool) {
 	sec := r.Window.Seconds()
 	rate := func(u *uint64) (float64, bool) {
 		if u == nil {
@@ -145,34 +150,33 @@ func connectionsTable(connections []Row, r report.Report, n RenderableNode) (Tab
 		}
 		return float64(*u) / sec, true
 	}
-	shortenByteRate := func(rate float64) (major, minor string) {
-		switch {
-		case rate > 1024*1024:
-			return fmt.Sprintf("%.2f", rate/1024/1024), "MBps"
-		case rate > 1024:
-			return fmt.Sprintf("%.1f", rate/1024), "KBps"
-		default:
-			return fmt.Sprintf("%.0f", rate), "Bps"
-		}
-	}
-
 	rows := []Row{}
 	if n.EdgeMetadata.MaxConnCountTCP != nil {
-		rows = append(rows, Row{"TCP connections", strconv.FormatUint(*n.EdgeMetadata.MaxConnCountTCP, 10), "", false})
+		rows = append(rows, Row{"TCP connections", strconv.FormatUint(*n.EdgeMetadata.MaxConnCountTCP, 10), "", false, nil})
+	}
+	if n.EdgeMetadata.MaxConnCountUDP != nil {
+		rows = append(rows, Row{"UDP flows", strconv.FormatUint(*n.EdgeMetadata.MaxConnCountUDP, 10), "", false, nil})
+	}
+	if len(n.EdgeMetadata.Protocols) > 0 {
+		rows = append(rows, Row{"Protocols", strings.Join(n.EdgeMetadata.Protocols, ", "), "", false, nil})
 	}
 	if rate, ok := rate(n.EdgeMetadata.EgressPacketCount); ok {
-		rows = append(rows, Row{"Egress packet rate", fmt.Sprintf("%.0f", rate), "packets/sec", false})
+		rows = append(rows, Row{"Egress packet rate", fmt.Sprintf("%.0f", rate), "packets/sec", false, nil})
 	}
 	if rate, ok := rate(n.EdgeMetadata.IngressPacketCount); ok {
-		rows = append(rows, Row{"Ingress packet rate", fmt.Sprintf("%.0f", rate), "packets/sec", false})
+		rows = append(rows, Row{"Ingress packet rate", fmt.Sprintf("%.0f", rate), "packets/sec", false, nil})
 	}
 	if rate, ok := rate(n.EdgeMetadata.EgressByteCount); ok {
 		s, unit := shortenByteRate(rate)
-		rows = append(rows, Row{"Egress byte rate", s, unit, false})
+		rows = append(rows, Row{"Egress byte rate", s, unit, false, nil})
 	}
 	if rate, ok := rate(n.EdgeMetadata.IngressByteCount); ok {
 		s, unit := shortenByteRate(rate)
-		rows = append(rows, Row{"Ingress byte rate", s, unit, false})
+		rows = append(rows, Row{"Ingress byte rate", s, unit, false, nil})
+	}
+	if len(listening) > 0 {
+		sort.Sort(sortableRows(listening))
+		rows = append(rows, listening...)
 	}
 	if len(connections) > 0 {
 		sort.Sort(sortableRows(connections))
@@ -185,6 +189,17 @@ func connectionsTable(connections []Row, r report.Report, n RenderableNode) (Tab
 	return Table{}, false
 }
 
+func shortenByteRate(rate float64) (major, minor string) {
+	switch {
+	case rate > 1024*1024:
+		return fmt.Sprintf("%.2f", rate/1024/1024), "MBps"
+	case rate > 1024:
+		return fmt.Sprintf("%.1f", rate/1024), "KBps"
+	default:
+		return fmt.Sprintf("%.0f", rate), "Bps"
+	}
+}
+
 // OriginTable produces a table (to be consumed directly by the UI) based on
 // an origin ID, which is (optimistically) a node ID in one of our topologies.
 func OriginTable(r report.Report, originID string, addHostTags bool, addContainerTags bool) (Table, bool) {
@@ -203,11 +218,26 @@ func OriginTable(r report.Report, originID string, addHostTags bool, addContaine
 	return Table{}, false
 }
 
+// listeningRows produces a row for an endpoint with a listening socket,
+// saying where and with which protocols it listens.
+func listeningRows(topology report.Topology, originID string) []Row {
+	node := topology.Nodes[originID]
+	protocols, ok := node.Metadata[endpoint.Listening]
+	if !ok {
+		return []Row{}
+	}
+	return []Row{{
+		Key:        "Listens on",
+		ValueMajor: net.JoinHostPort(node.Metadata[endpoint.Addr], node.Metadata[endpoint.Port]),
+		ValueMinor: strings.Replace(protocols, ",", ", ", -1),
+	}}
+}
+
 func connectionDetailsRows(topology report.Topology, originID string) []Row {
 	rows := []Row{}
 	labeler := func(nodeID string) (string, bool) {
 		if _, addr, port, ok := report.ParseEndpointNodeID(nodeID); ok {
-			return fmt.Sprintf("%s:%s", addr, port), true
+			return net.JoinHostPort(addr, port), true
 		}
 		if _, addr, ok := report.ParseAddressNodeID(nodeID); ok {
 			return addr, true
@@ -252,17 +282,48 @@ func connectionDetailsRows(topology report.Topology, originID string) []Row {
 	return rows
 }
 
+// processResourceRows shows the process's CPU, memory and file descriptor
+// usage.
+func processResourceRows(nmd report.Node) []Row {
+	rows := []Row{}
+	if cpu, err := strconv.ParseFloat(nmd.Metadata[process.CPUUsage], 64); err == nil {
+		rows = append(rows, Row{Key: "CPU usage", ValueMajor: fmt.Sprintf("%0.2f", cpu), ValueMinor: "%", Metric: nmd.Metrics[process.CPUUsage]})
+	}
+	for _, tuple := range []struct{ key, human string }{
+		{process.MemoryUsage, "Memory usage"},
+		{process.VirtualMemory, "Virtual memory"},
+	} {
+		if memory, err := strconv.ParseFloat(nmd.Metadata[tuple.key], 64); err == nil {
+			rows = append(rows, Row{Key: tuple.human, ValueMajor: fmt.Sprintf("%0.2f", memory/float64(mb)), ValueMinor: "MB", Metric: nmd.Metrics[tuple.key]})
+		}
+	}
+	if val, ok := nmd.Metadata[process.OpenFiles]; ok {
+		rows = append(rows, Row{Key: "# Open files", ValueMajor: val, ValueMinor: ""})
+	}
+	if val, ok := nmd.Metadata[process.StartTime]; ok {
+		rows = append(rows, Row{Key: "Started", ValueMajor: val, ValueMinor: ""})
+	}
+	return rows
+}
+
 func processOriginTable(nmd report.Node, addHostTag bool, addContainerTag bool) (Table, bool) {
 	rows := []Row{}
 	for _, tuple := range []struct{ key, human string }{
 		{process.PPID, "Parent PID"},
 		{process.Cmdline, "Command"},
 		{process.Threads, "# Threads"},
+		{process.User, "User"},
+		{process.SystemdUnit, "Systemd unit"},
+		{process.Cgroup, "Cgroup"},
+		{process.NetNamespace, "Network namespace"},
+		{process.PIDNamespace, "PID namespace"},
+		{process.MountNamespace, "Mount namespace"},
 	} {
 		if val, ok := nmd.Metadata[tuple.key]; ok {
 			rows = append(rows, Row{Key: tuple.human, ValueMajor: val, ValueMinor: ""})
 		}
 	}
+	rows = append(rows, processResourceRows(nmd)...)
 
 	if containerID, ok := nmd.Metadata[docker.ContainerID]; ok && addContainerTag {
 		rows = append([]Row{{Key: "Container ID", ValueMajor: containerID}}, rows...)
@@ -312,11 +373,17 @@ func containerOriginTable(nmd report.Node, addHostTag bool) (Table, bool) {
 	}
 	rows = append(rows, getDockerLabelRows(nmd)...)
 
+	if metric, ok := nmd.Metrics[docker.CPUUsagePercent]; ok {
+		if last, ok := metric.Last(); ok {
+			cpuStr := fmt.Sprintf("%0.2f", last.Value)
+			rows = append(rows, Row{Key: "CPU Usage (%):", ValueMajor: cpuStr, ValueMinor: "", Metric: metric})
+		}
+	}
 	if val, ok := nmd.Metadata[docker.MemoryUsage]; ok {
 		memory, err := strconv.ParseFloat(val, 64)
 		if err == nil {
 			memoryStr := fmt.Sprintf("%0.2f", memory/float64(mb))
-			rows = append(rows, Row{Key: "Memory Usage (MB):", ValueMajor: memoryStr, ValueMinor: ""})
+			rows = append(rows, Row{Key: "Memory Usage (MB):", ValueMajor: memoryStr, ValueMinor: "", Metric: nmd.Metrics[docker.MemoryUsage]})
 		}
 	}
 	if addHostTag {
@@ -380,18 +447,48 @@ func getDockerLabelRows(nmd report.Node) []Row {
 	return rows
 }
 
+// hostResourceRows shows the host's CPU, memory, disk and network usage.
+func hostResourceRows(nmd report.Node) []Row {
+	rows := []Row{}
+	if val, ok := nmd.Metadata[host.CPUUsage]; ok {
+		if cpu, err := strconv.ParseFloat(val, 64); err == nil {
+			rows = append(rows, Row{Key: "CPU usage", ValueMajor: fmt.Sprintf("%0.2f", cpu), ValueMinor: "%", Metric: nmd.Metrics[host.CPUUsage]})
+		}
+	}
+	used, usedErr := strconv.ParseFloat(nmd.Metadata[host.MemoryUsage], 64)
+	total, totalErr := strconv.ParseFloat(nmd.Metadata[host.MemoryTotal], 64)
+	if usedErr == nil && totalErr == nil {
+		memoryStr := fmt.Sprintf("%0.0f / %0.0f", used/float64(mb), total/float64(mb))
+		rows = append(rows, Row{Key: "Memory usage", ValueMajor: memoryStr, ValueMinor: "MB", Metric: nmd.Metrics[host.MemoryUsage]})
+	}
+	rateRow := func(human, key string) {
+		if rate, err := strconv.ParseFloat(nmd.Metadata[key], 64); err == nil {
+			s, unit := shortenByteRate(rate)
+			rows = append(rows, Row{Key: human, ValueMajor: s, ValueMinor: unit, Metric: nmd.Metrics[key]})
+		}
+	}
+	rateRow("Disk read rate", host.DiskReadRate)
+	rateRow("Disk write rate", host.DiskWriteRate)
+	for _, iface := range host.ExtractInterfaces(nmd) {
+		rateRow(fmt.Sprintf("Interface %q ingress", iface), host.NetworkRxRatePrefix+iface)
+		rateRow(fmt.Sprintf("Interface %q egress", iface), host.NetworkTxRatePrefix+iface)
+	}
+	return rows
+}
+
 func hostOriginTable(nmd report.Node) (Table, bool) {
 	rows := []Row{}
-	for _, tuple := range []struct{ key, human string }{
-		{host.Load, "Load"},
-		{host.OS, "Operating system"},
-		{host.KernelVersion, "Kernel version"},
-		{host.Uptime, "Uptime"},
+	for _, tuple := range []struct{ key, human, metric string }{
+		{host.Load, "Load", host.Load1},
+		{host.OS, "Operating system", ""},
+		{host.KernelVersion, "Kernel version", ""},
+		{host.Uptime, "Uptime", ""},
 	} {
 		if val, ok := nmd.Metadata[tuple.key]; ok {
-			rows = append(rows, Row{Key: tuple.human, ValueMajor: val, ValueMinor: ""})
+			rows = append(rows, Row{Key: tuple.human, ValueMajor: val, ValueMinor: "", Metric: nmd.Metrics[tuple.metric]})
 		}
 	}
+	rows = append(rows, hostResourceRows(nmd)...)
 
 	title := "Host"
 	var (
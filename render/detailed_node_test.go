@@ -4,8 +4,13 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/weaveworks/scope/probe/endpoint"
+	"github.com/weaveworks/scope/probe/host"
+	"github.com/weaveworks/scope/probe/process"
 	"github.com/weaveworks/scope/render"
+	"github.com/weaveworks/scope/report"
 	"github.com/weaveworks/scope/test"
 )
 
@@ -24,8 +29,8 @@ func TestOriginTable(t *testing.T) {
 			Numeric: false,
 			Rank:    1,
 			Rows: []render.Row{
-				{"Load", "0.01 0.01 0.01", "", false},
-				{"Operating system", "Linux", "", false},
+				{"Load", "0.01 0.01 0.01", "", false, nil},
+				{"Operating system", "Linux", "", false, nil},
 			},
 		},
 	} {
@@ -39,6 +44,32 @@ func TestOriginTable(t *testing.T) {
 		}
 	}
 
+	// Test host resource usage
+	var (
+		now    = time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
+		cpu    = report.MakeMetric(now, 12.5)
+		rpt    = report.MakeReport()
+		hostID = report.MakeHostNodeID("host1")
+	)
+	rpt.Host.Nodes[hostID] = report.MakeNodeWith(map[string]string{
+		host.CPUUsage:                     "12.5",
+		host.MemoryUsage:                  "1073741824",
+		host.MemoryTotal:                  "4294967296",
+		host.DiskReadRate:                 "2048",
+		host.NetworkRxRatePrefix + "eth0": "100",
+		host.NetworkTxRatePrefix + "eth0": "3145728",
+	}).WithMetrics(report.Metrics{host.CPUUsage: cpu})
+	wantRows := []render.Row{
+		{"CPU usage", "12.50", "%", false, cpu},
+		{"Memory usage", "1024 / 4096", "MB", false, nil},
+		{"Disk read rate", "2.0", "KBps", false, nil},
+		{`Interface "eth0" ingress`, "100", "Bps", false, nil},
+		{`Interface "eth0" egress`, "3.00", "MBps", false, nil},
+	}
+	if have, _ := render.OriginTable(rpt, hostID, false, false); !reflect.DeepEqual(wantRows, have.Rows) {
+		t.Errorf("%s", test.Diff(wantRows, have.Rows))
+	}
+
 	// Test host/container tags
 	for originID, want := range map[string]render.Table{
 		test.ServerProcessNodeID: {
@@ -46,8 +77,8 @@ func TestOriginTable(t *testing.T) {
 			Numeric: false,
 			Rank:    2,
 			Rows: []render.Row{
-				{"Host", test.ServerHostID, "", false},
-				{"Container ID", test.ServerContainerID, "", false},
+				{"Host", test.ServerHostID, "", false, nil},
+				{"Container ID", test.ServerContainerID, "", false, nil},
 			},
 		},
 		test.ServerContainerNodeID: {
@@ -55,11 +86,11 @@ func TestOriginTable(t *testing.T) {
 			Numeric: false,
 			Rank:    3,
 			Rows: []render.Row{
-				{"Host", test.ServerHostID, "", false},
-				{"ID", test.ServerContainerID, "", false},
-				{"Image ID", test.ServerContainerImageID, "", false},
-				{`Label "foo1"`, `bar1`, "", false},
-				{`Label "foo2"`, `bar2`, "", false},
+				{"Host", test.ServerHostID, "", false, nil},
+				{"ID", test.ServerContainerID, "", false, nil},
+				{"Image ID", test.ServerContainerImageID, "", false, nil},
+				{`Label "foo1"`, `bar1`, "", false, nil},
+				{`Label "foo2"`, `bar2`, "", false, nil},
 			},
 		},
 	} {
@@ -146,9 +177,9 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 				Numeric: false,
 				Rank:    4,
 				Rows: []render.Row{
-					{"Image ID", test.ServerContainerImageID, "", false},
-					{`Label "foo1"`, `bar1`, "", false},
-					{`Label "foo2"`, `bar2`, "", false},
+					{"Image ID", test.ServerContainerImageID, "", false, nil},
+					{`Label "foo1"`, `bar1`, "", false, nil},
+					{`Label "foo2"`, `bar2`, "", false, nil},
 				},
 			},
 			{
@@ -156,10 +187,10 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 				Numeric: false,
 				Rank:    3,
 				Rows: []render.Row{
-					{"ID", test.ServerContainerID, "", false},
-					{"Image ID", test.ServerContainerImageID, "", false},
-					{`Label "foo1"`, `bar1`, "", false},
-					{`Label "foo2"`, `bar2`, "", false},
+					{"ID", test.ServerContainerID, "", false, nil},
+					{"Image ID", test.ServerContainerImageID, "", false, nil},
+					{`Label "foo1"`, `bar1`, "", false, nil},
+					{`Label "foo2"`, `bar2`, "", false, nil},
 				},
 			},
 			{
@@ -173,8 +204,8 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 				Numeric: false,
 				Rank:    1,
 				Rows: []render.Row{
-					{"Load", "0.01 0.01 0.01", "", false},
-					{"Operating system", "Linux", "", false},
+					{"Load", "0.01 0.01 0.01", "", false, nil},
+					{"Operating system", "Linux", "", false, nil},
 				},
 			},
 			{
@@ -182,44 +213,50 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 				Numeric: true,
 				Rank:    0,
 				Rows: []render.Row{
-					{"Egress packet rate", "105", "packets/sec", false},
-					{"Egress byte rate", "1.0", "KBps", false},
-					{"Client", "Server", "", true},
+					{"Egress packet rate", "105", "packets/sec", false, nil},
+					{"Egress byte rate", "1.0", "KBps", false, nil},
+					{"Client", "Server", "", true, nil},
 					{
 						fmt.Sprintf("%s:%s", test.UnknownClient1IP, test.UnknownClient1Port),
 						fmt.Sprintf("%s:%s", test.ServerIP, test.ServerPort),
 						"",
 						true,
+						nil,
 					},
 					{
 						fmt.Sprintf("%s:%s", test.UnknownClient2IP, test.UnknownClient2Port),
 						fmt.Sprintf("%s:%s", test.ServerIP, test.ServerPort),
 						"",
 						true,
+						nil,
 					},
 					{
 						fmt.Sprintf("%s:%s", test.UnknownClient3IP, test.UnknownClient3Port),
 						fmt.Sprintf("%s:%s", test.ServerIP, test.ServerPort),
 						"",
 						true,
+						nil,
 					},
 					{
 						fmt.Sprintf("%s:%s", test.ClientIP, test.ClientPort54001),
 						fmt.Sprintf("%s:%s", test.ServerIP, test.ServerPort),
 						"",
 						true,
+						nil,
 					},
 					{
 						fmt.Sprintf("%s:%s", test.ClientIP, test.ClientPort54002),
 						fmt.Sprintf("%s:%s", test.ServerIP, test.ServerPort),
 						"",
 						true,
+						nil,
 					},
 					{
 						fmt.Sprintf("%s:%s", test.RandomClientIP, test.RandomClientPort),
 						fmt.Sprintf("%s:%s", test.ServerIP, test.ServerPort),
 						"",
 						true,
+						nil,
 					},
 				},
 			},
@@ -229,3 +266,30 @@ func TestMakeDetailedContainerNode(t *testing.T) {
 		t.Errorf("%s", test.Diff(want, have))
 	}
 }
+
+func TestMakeDetailedNodeListening(t *testing.T) {
+	rpt := test.Report.Copy()
+	listenerNodeID := report.MakeEndpointNodeID(test.ServerHostID, "0.0.0.0", "443")
+	rpt.Endpoint = rpt.Endpoint.WithNode(listenerNodeID, report.MakeNodeWith(map[string]string{
+		endpoint.Addr:      "0.0.0.0",
+		endpoint.Port:      "443",
+		endpoint.Listening: "tcp,udp",
+		process.PID:        test.ServerPID,
+		report.HostNodeID:  test.ServerHostNodeID,
+	}))
+
+	renderableNode := render.ProcessRenderer.Render(rpt)[render.MakeProcessID(test.ServerHostID, test.ServerPID)]
+	have := render.MakeDetailedNode(rpt, renderableNode)
+	want := render.Row{"Listens on", "0.0.0.0:443", "tcp, udp", false, nil}
+	for _, table := range have.Tables {
+		if table.Title != "Connections" {
+			continue
+		}
+		for _, row := range table.Rows {
+			if reflect.DeepEqual(want, row) {
+				return
+			}
+		}
+	}
+	t.Errorf("no %v row in %+v", want, have.Tables)
+}
@@ -17,6 +17,7 @@ func Sterilize(r render.RenderableNodes) render.RenderableNodes {
 	for id, n := range r {
 		n.Node.Metadata = report.Metadata{}
 		n.Node.Counters = report.Counters{}
+		n.Node.Metrics = report.Metrics{}
 		n.Node.Edges = report.EdgeMetadatas{}
 		r[id] = n
 	}
@@ -26,7 +26,15 @@ func MakeHostID(hostID string) string {
 }
 
 // MakePseudoNodeID produces a pseudo node ID from its composite parts,
-// for use in rendered nodes.
+// for use in rendered nodes. Parts containing colons (i.e. IPv6 addresses)
+// are bracketed, so IDs stay unambiguous.
 func MakePseudoNodeID(parts ...string) string {
-	return strings.Join(append([]string{"pseudo"}, parts...), ":")
+	result := []string{"pseudo"}
+	for _, part := range parts {
+		if strings.Contains(part, ":") {
+			part = "[" + part + "]"
+		}
+		result = append(result, part)
+	}
+	return strings.Join(result, ":")
 }
@@ -68,14 +68,14 @@ func MapEndpointIdentity(m RenderableNode, local report.Networks) RenderableNode
 		// Otherwise (the server node is missing), generate a pseudo node for every (server ip, server port)
 		outputID := MakePseudoNodeID(addr, port)
 		if port != "" {
-			return RenderableNodes{outputID: newDerivedPseudoNode(outputID, addr+":"+port, m)}
+			return RenderableNodes{outputID: newDerivedPseudoNode(outputID, net.JoinHostPort(addr, port), m)}
 		}
 		return RenderableNodes{outputID: newDerivedPseudoNode(outputID, addr, m)}
 	}
 
 	var (
 		id    = MakeEndpointID(report.ExtractHostID(m.Node), addr, port)
-		major = fmt.Sprintf("%s:%s", addr, port)
+		major = net.JoinHostPort(addr, port)
 		minor = report.ExtractHostID(m.Node)
 		rank  = major
 	)
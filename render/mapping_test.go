@@ -72,6 +72,60 @@ func TestMapHostIdentity(t *testing.T) {
 	}
 }
 
+func TestMapIPv6(t *testing.T) {
+	local := report.Networks([]*net.IPNet{mustParseCIDR("fd00::/64")})
+	var (
+		serverEndpointNodeID = report.MakeEndpointNodeID("server", "fd00::1", "80")
+		serverAddressNodeID  = report.MakeAddressNodeID("server", "fd00::1")
+	)
+	for i, c := range []struct {
+		f         render.MapFunc
+		node      report.Node
+		id, major string
+	}{
+		{
+			render.MapEndpointIdentity,
+			report.MakeNodeWith(map[string]string{report.HostNodeID: report.MakeHostNodeID("server"), endpoint.Addr: "fd00::1", endpoint.Port: "80"}),
+			render.MakeEndpointID("server", "fd00::1", "80"), "[fd00::1]:80",
+		},
+		{
+			render.MapEndpointIdentity,
+			report.MakeNodeWith(map[string]string{endpoint.Addr: "fd00::2", endpoint.Port: "40000"}).WithAdjacent(serverEndpointNodeID),
+			"pseudo:[fd00::2]:[fd00::1]:80", "fd00::2",
+		},
+		{
+			render.MapEndpointIdentity,
+			report.MakeNodeWith(map[string]string{endpoint.Addr: "fd00::2", endpoint.Port: "8080"}),
+			"pseudo:[fd00::2]:8080", "[fd00::2]:8080",
+		},
+		{
+			render.MapEndpointIdentity,
+			report.MakeNodeWith(map[string]string{endpoint.Addr: "2001:db8::1", endpoint.Port: "80"}),
+			render.TheInternetID, render.TheInternetMajor,
+		},
+		{
+			render.MapAddressIdentity,
+			report.MakeNodeWith(map[string]string{endpoint.Addr: "fd00::2"}).WithAdjacent(serverAddressNodeID),
+			"pseudo:[fd00::2]:[fd00::1]", "fd00::2",
+		},
+		{
+			render.MapAddressIdentity,
+			report.MakeNodeWith(map[string]string{endpoint.Addr: "2001:db8::1"}),
+			render.TheInternetID, render.TheInternetMajor,
+		},
+	} {
+		have := c.f(nrn(c.node), local)
+		n, ok := have[c.id]
+		if len(have) != 1 || !ok {
+			t.Errorf("%d: want %q, have %v", i, c.id, have)
+			continue
+		}
+		if n.LabelMajor != c.major {
+			t.Errorf("%d: want major label %q, have %q", i, c.major, n.LabelMajor)
+		}
+	}
+}
+
 type testcase struct {
 	md render.RenderableNode
 	ok bool
@@ -1,6 +1,7 @@
 package render
 
 import (
+	"github.com/weaveworks/scope/probe/endpoint"
 	"github.com/weaveworks/scope/report"
 )
 
@@ -159,6 +160,29 @@ func FilterUnconnected(r Renderer) Renderer {
 	}
 }
 
+// OnlyConnectedOrListening filters out unconnected RenderedNodes, except
+// for those listening for connections, i.e. idle servers.
+func OnlyConnectedOrListening(input RenderableNodes) RenderableNodes {
+	output := RenderableNodes{}
+	for id, node := range ColorConnected(input) {
+		_, isConnected := node.Node.Metadata[IsConnected]
+		_, isListening := node.Node.Metadata[endpoint.Listening]
+		if isConnected || isListening {
+			output[id] = node
+		}
+	}
+	return output
+}
+
+// FilterUnconnectedExceptListening produces a renderer that filters
+// unconnected nodes from the given renderer, but keeps idle servers.
+func FilterUnconnectedExceptListening(r Renderer) Renderer {
+	return CustomRenderer{
+		RenderFunc: OnlyConnectedOrListening,
+		Renderer:   r,
+	}
+}
+
 // ColorConnected colors nodes with the IsConnected key if
 // they have edges to or from them.
 func ColorConnected(input RenderableNodes) RenderableNodes {
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/weaveworks/scope/probe/endpoint"
 	"github.com/weaveworks/scope/render"
 	"github.com/weaveworks/scope/render/expected"
 	"github.com/weaveworks/scope/report"
@@ -191,4 +192,23 @@ func TestFilterRender(t *testing.T) {
 	}
 }
 
+func TestFilterUnconnectedExceptListening(t *testing.T) {
+	renderer := render.FilterUnconnectedExceptListening(
+		mockRenderer{RenderableNodes: render.RenderableNodes{
+			"foo": {ID: "foo", Node: report.MakeNode().WithAdjacent("bar")},
+			"bar": {ID: "bar", Node: report.MakeNode()},
+			"baz": {ID: "baz", Node: report.MakeNodeWith(map[string]string{endpoint.Listening: "tcp"})},
+			"qux": {ID: "qux", Node: report.MakeNode()},
+		}})
+	want := render.RenderableNodes{
+		"foo": {ID: "foo", Node: report.MakeNode().WithAdjacent("bar")},
+		"bar": {ID: "bar", Node: report.MakeNode()},
+		"baz": {ID: "baz", Node: report.MakeNode()},
+	}
+	have := expected.Sterilize(renderer.Render(report.MakeReport()))
+	if !reflect.DeepEqual(want, have) {
+		t.Errorf("want %+v, have %+v", want, have)
+	}
+}
+
 func newu64(value uint64) *uint64 { return &value }
@@ -17,7 +17,7 @@ func TestReportLocalNetworks(t *testing.T) {
 			Nodes: report.Nodes{
 				"nonets": report.MakeNode(),
 				"foo": report.MakeNodeWith(map[string]string{
-					host.LocalNetworks: "10.0.0.1/8 192.168.1.1/24 10.0.0.1/8 badnet/33",
+					host.LocalNetworks: "10.0.0.1/8 192.168.1.1/24 10.0.0.1/8 badnet/33 fd00::1/64",
 				}),
 			},
 		},
@@ -25,6 +25,7 @@ func TestReportLocalNetworks(t *testing.T) {
 	want := report.Networks([]*net.IPNet{
 		mustParseCIDR("10.0.0.1/8"),
 		mustParseCIDR("192.168.1.1/24"),
+		mustParseCIDR("fd00::1/64"),
 	})
 	have := render.LocalNetworks(r)
 	if !reflect.DeepEqual(want, have) {
@@ -2,6 +2,7 @@ package render
 
 import (
 	"reflect"
+	"sort"
 )
 
 // Diff is returned by TopoDiff. It represents the changes between two
@@ -37,3 +38,84 @@ func TopoDiff(a, b RenderableNodes) Diff {
 
 	return diff
 }
+
+// Edge is a directed edge between two RenderableNodes.
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type edgesByID []Edge
+
+func (e edgesByID) Len() int      { return len(e) }
+func (e edgesByID) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e edgesByID) Less(i, j int) bool {
+	if e[i].Source != e[j].Source {
+		return e[i].Source < e[j].Source
+	}
+	return e[i].Target < e[j].Target
+}
+
+// EdgeDiff gives you the edges which appear in B but not in A (added), and
+// the edges which appear in A but not in B (removed), sorted by ID.
+func EdgeDiff(a, b RenderableNodes) (added, removed []Edge) {
+	return missingEdges(b, a), missingEdges(a, b)
+}
+
+// missingEdges returns the edges in a which don't exist in b.
+func missingEdges(a, b RenderableNodes) []Edge {
+	result := []Edge{}
+	for id, node := range a {
+		other, ok := b[id]
+		for _, adjacent := range node.Adjacency {
+			if !ok || !other.Adjacency.Contains(adjacent) {
+				result = append(result, Edge{Source: id, Target: adjacent})
+			}
+		}
+	}
+	sort.Sort(edgesByID(result))
+	return result
+}
+
+// MetadataChange describes a single metadata key whose value differs
+// between two versions of a node. An empty From or To means the key was
+// absent.
+type MetadataChange struct {
+	Key  string `json:"key"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// MetadataDiff gives you the metadata changes, sorted by key, for every node
+// which exists in both A and B. Nodes without changes are omitted.
+func MetadataDiff(a, b RenderableNodes) map[string][]MetadataChange {
+	result := map[string][]MetadataChange{}
+	for id, before := range a {
+		after, ok := b[id]
+		if !ok {
+			continue
+		}
+		changes := []MetadataChange{}
+		for k, v := range before.Node.Metadata {
+			if v2, ok := after.Node.Metadata[k]; !ok || v != v2 {
+				changes = append(changes, MetadataChange{Key: k, From: v, To: v2})
+			}
+		}
+		for k, v := range after.Node.Metadata {
+			if _, ok := before.Node.Metadata[k]; !ok {
+				changes = append(changes, MetadataChange{Key: k, To: v})
+			}
+		}
+		if len(changes) > 0 {
+			sort.Sort(changesByKey(changes))
+			result[id] = changes
+		}
+	}
+	return result
+}
+
+type changesByKey []MetadataChange
+
+func (c changesByKey) Len() int           { return len(c) }
+func (c changesByKey) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c changesByKey) Less(i, j int) bool { return c[i].Key < c[j].Key }
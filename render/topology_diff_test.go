@@ -91,3 +91,49 @@ func TestTopoDiff(t *testing.T) {
 		}
 	}
 }
+
+func TestEdgeDiff(t *testing.T) {
+	var (
+		a = render.RenderableNodes{
+			"nodea": {ID: "nodea", Node: report.MakeNode().WithAdjacent("nodeb").WithAdjacent("nodec")},
+			"nodeb": {ID: "nodeb", Node: report.MakeNode().WithAdjacent("nodea")},
+		}
+		b = render.RenderableNodes{
+			"nodea": {ID: "nodea", Node: report.MakeNode().WithAdjacent("nodeb").WithAdjacent("noded")},
+			"nodec": {ID: "nodec", Node: report.MakeNode().WithAdjacent("nodea")},
+		}
+	)
+
+	added, removed := render.EdgeDiff(a, b)
+	if want := []render.Edge{{"nodea", "noded"}, {"nodec", "nodea"}}; !reflect.DeepEqual(want, added) {
+		t.Error(test.Diff(want, added))
+	}
+	if want := []render.Edge{{"nodea", "nodec"}, {"nodeb", "nodea"}}; !reflect.DeepEqual(want, removed) {
+		t.Error(test.Diff(want, removed))
+	}
+}
+
+func TestMetadataDiff(t *testing.T) {
+	var (
+		a = render.RenderableNodes{
+			"nodea": {ID: "nodea", Node: report.MakeNodeWith(map[string]string{"foo": "1", "bar": "2"})},
+			"nodeb": {ID: "nodeb", Node: report.MakeNodeWith(map[string]string{"foo": "1"})},
+			"nodec": {ID: "nodec", Node: report.MakeNodeWith(map[string]string{"foo": "1"})},
+		}
+		b = render.RenderableNodes{
+			"nodea": {ID: "nodea", Node: report.MakeNodeWith(map[string]string{"foo": "3", "baz": "4"})},
+			"nodeb": {ID: "nodeb", Node: report.MakeNodeWith(map[string]string{"foo": "1"})},
+		}
+	)
+
+	want := map[string][]render.MetadataChange{
+		"nodea": {
+			{Key: "bar", From: "2"},
+			{Key: "baz", To: "4"},
+			{Key: "foo", From: "1", To: "3"},
+		},
+	}
+	if have := render.MetadataDiff(a, b); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
@@ -0,0 +1,88 @@
+package report
+
+import (
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Delta is the difference between two reports: applying the delta of a and
+// b to a yields b. Probes publish deltas between full reports, to save
+// bandwidth when little has changed.
+type Delta struct {
+	Endpoint       TopologyDelta
+	Address        TopologyDelta
+	Process        TopologyDelta
+	Container      TopologyDelta
+	ContainerImage TopologyDelta
+	Host           TopologyDelta
+	Overlay        TopologyDelta
+	Sampling       Sampling
+	Window         time.Duration
+}
+
+// TopologyDelta is the difference between two topologies. Upserts holds
+// nodes that were added or changed, in full; Removed holds the IDs of nodes
+// that were removed.
+type TopologyDelta struct {
+	Upserts Nodes
+	Removed IDList
+}
+
+// MakeDelta returns the delta which turns prev into next.
+func MakeDelta(prev, next Report) Delta {
+	return Delta{
+		Endpoint:       makeTopologyDelta(prev.Endpoint, next.Endpoint),
+		Address:        makeTopologyDelta(prev.Address, next.Address),
+		Process:        makeTopologyDelta(prev.Process, next.Process),
+		Container:      makeTopologyDelta(prev.Container, next.Container),
+		ContainerImage: makeTopologyDelta(prev.ContainerImage, next.ContainerImage),
+		Host:           makeTopologyDelta(prev.Host, next.Host),
+		Overlay:        makeTopologyDelta(prev.Overlay, next.Overlay),
+		Sampling:       next.Sampling,
+		Window:         next.Window,
+	}
+}
+
+// ApplyDelta returns the report that results from applying the delta to the
+// receiver. The receiver is not modified.
+func (r Report) ApplyDelta(d Delta) Report {
+	return Report{
+		Endpoint:       r.Endpoint.applyDelta(d.Endpoint),
+		Address:        r.Address.applyDelta(d.Address),
+		Process:        r.Process.applyDelta(d.Process),
+		Container:      r.Container.applyDelta(d.Container),
+		ContainerImage: r.ContainerImage.applyDelta(d.ContainerImage),
+		Host:           r.Host.applyDelta(d.Host),
+		Overlay:        r.Overlay.applyDelta(d.Overlay),
+		Sampling:       d.Sampling,
+		Window:         d.Window,
+	}
+}
+
+func makeTopologyDelta(prev, next Topology) TopologyDelta {
+	d := TopologyDelta{Upserts: Nodes{}, Removed: IDList{}}
+	for id, n := range next.Nodes {
+		if p, ok := prev.Nodes[id]; !ok || !reflect.DeepEqual(p, n) {
+			d.Upserts[id] = n.Copy()
+		}
+	}
+	for id := range prev.Nodes {
+		if _, ok := next.Nodes[id]; !ok {
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	sort.Strings(d.Removed)
+	return d
+}
+
+func (t Topology) applyDelta(d TopologyDelta) Topology {
+	cp := t.Copy()
+	for _, id := range d.Removed {
+		delete(cp.Nodes, id)
+	}
+	for id, n := range d.Upserts {
+		cp.Nodes[id] = n.Copy()
+	}
+	return cp
+}
@@ -0,0 +1,46 @@
+package report_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
+)
+
+func TestDelta(t *testing.T) {
+	prev := report.MakeReport()
+	prev.Endpoint.Nodes["a"] = report.MakeNodeWith(map[string]string{"x": "1"})
+	prev.Endpoint.Nodes["b"] = report.MakeNodeWith(map[string]string{"x": "2"})
+	prev.Host.Nodes["h"] = report.MakeNodeWith(map[string]string{"load": "0.1"})
+	prev.Window = time.Second
+
+	next := report.MakeReport()
+	next.Endpoint.Nodes["a"] = report.MakeNodeWith(map[string]string{"x": "1"})
+	next.Endpoint.Nodes["c"] = report.MakeNodeWith(map[string]string{"x": "3"})
+	next.Host.Nodes["h"] = report.MakeNodeWith(map[string]string{"load": "0.2"})
+	next.Sampling = report.Sampling{Count: 1, Total: 2}
+	next.Window = 2 * time.Second
+
+	d := report.MakeDelta(prev, next)
+	if want, have := (report.TopologyDelta{
+		Upserts: report.Nodes{"c": next.Endpoint.Nodes["c"]},
+		Removed: report.IDList{"b"},
+	}), d.Endpoint; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if want, have := 1, len(d.Host.Upserts); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := 0, len(d.Process.Upserts)+len(d.Process.Removed); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+
+	if want, have := next, prev.ApplyDelta(d); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if _, ok := prev.Endpoint.Nodes["b"]; !ok {
+		t.Error("ApplyDelta modified the receiver")
+	}
+}
@@ -30,12 +30,12 @@ func MakeEndpointNodeID(hostID, address, port string) string {
 func MakeAddressNodeID(hostID, address string) string {
 	var scope string
 
-	// Loopback addresses and addresses explicity marked as
-	// local get scoped by hostID
+	// Loopback, link-local and unspecified (wildcard) addresses, and
+	// addresses explicity marked as local get scoped by hostID
 	addressIP := net.ParseIP(address)
 	if addressIP != nil && LocalNetworks.Contains(addressIP) {
 		scope = hostID
-	} else if isLoopback(address) {
+	} else if isLoopback(address) || isLinkLocal(address) || isUnspecified(address) {
 		scope = hostID
 	}
 
@@ -116,3 +116,17 @@ func isLoopback(address string) bool {
 	ip := net.ParseIP(address)
 	return ip != nil && ip.IsLoopback()
 }
+
+// isLinkLocal is true for addresses only meaningful on a single link, such
+// as IPv6 fe80::/10 addresses, which every interface has.
+func isLinkLocal(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLinkLocalUnicast()
+}
+
+// isUnspecified is true for the wildcard addresses, 0.0.0.0 and ::, which
+// listening sockets bind to in order to accept connections on any address.
+func isUnspecified(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsUnspecified()
+}
@@ -50,7 +50,12 @@ func TestEndpointNodeID(t *testing.T) {
 	}
 
 	for input, want := range map[string]struct{ name, address, port string }{
-		report.MakeEndpointNodeID("host.com", "1.2.3.4", "c"): {"", "1.2.3.4", "c"},
+		report.MakeEndpointNodeID("host.com", "1.2.3.4", "c"):      {"", "1.2.3.4", "c"},
+		report.MakeEndpointNodeID("host.com", "2001:db8::1", "80"): {"", "2001:db8::1", "80"},
+		report.MakeEndpointNodeID("host.com", "::1", "80"):         {"host.com", "::1", "80"},
+		report.MakeEndpointNodeID("host.com", "fe80::1", "80"):     {"host.com", "fe80::1", "80"},
+		report.MakeEndpointNodeID("host.com", "0.0.0.0", "80"):     {"host.com", "0.0.0.0", "80"},
+		report.MakeEndpointNodeID("host.com", "::", "80"):          {"host.com", "::", "80"},
 		"a;b;c": {"a", "b", "c"},
 	} {
 		haveName, haveAddress, havePort, ok := report.ParseEndpointNodeID(input)
@@ -101,6 +101,28 @@ func TestMergeEdgeMetadatas(t *testing.T) {
 				},
 			},
 		},
+		"Protocol merge": {
+			a: report.EdgeMetadatas{
+				"hostA|:192.168.1.1:*|:192.168.1.2:53": report.EdgeMetadata{
+					MaxConnCountUDP: newu64(3),
+					Protocols:       report.MakeIDList("udp"),
+				},
+			},
+			b: report.EdgeMetadatas{
+				"hostA|:192.168.1.1:*|:192.168.1.2:53": report.EdgeMetadata{
+					MaxConnCountTCP: newu64(1),
+					MaxConnCountUDP: newu64(2),
+					Protocols:       report.MakeIDList("tcp", "udp"),
+				},
+			},
+			want: report.EdgeMetadatas{
+				"hostA|:192.168.1.1:*|:192.168.1.2:53": report.EdgeMetadata{
+					MaxConnCountTCP: newu64(1),
+					MaxConnCountUDP: newu64(3),
+					Protocols:       report.MakeIDList("tcp", "udp"),
+				},
+			},
+		},
 	} {
 		if have := c.a.Merge(c.b); !reflect.DeepEqual(c.want, have) {
 			t.Errorf("%s:\n%s", name, test.Diff(c.want, have))
@@ -125,6 +147,23 @@ func TestFlattenEdgeMetadata(t *testing.T) {
 	if !reflect.DeepEqual(want, have) {
 		t.Error(test.Diff(want, have))
 	}
+
+	have = (report.EdgeMetadata{
+		MaxConnCountUDP: newu64(1),
+		Protocols:       report.MakeIDList("udp"),
+	}).Flatten(report.EdgeMetadata{
+		MaxConnCountTCP: newu64(2),
+		MaxConnCountUDP: newu64(4),
+		Protocols:       report.MakeIDList("tcp"),
+	})
+	want = report.EdgeMetadata{
+		MaxConnCountTCP: newu64(2),
+		MaxConnCountUDP: newu64(1 + 4),
+		Protocols:       report.MakeIDList("tcp", "udp"),
+	}
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
 }
 
 func TestMergeNodes(t *testing.T) {
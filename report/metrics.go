@@ -0,0 +1,94 @@
+package report
+
+import (
+	"sort"
+	"time"
+)
+
+// MaxSamples is the most samples a Metric holds; older samples are dropped
+// as newer ones arrive. At the probe's default spy interval, that's a
+// minute's worth.
+const MaxSamples = 60
+
+// Metrics is a string->metric map.
+type Metrics map[string]Metric
+
+// Merge merges two sets of metrics into a fresh set of metrics, merging the
+// samples of metrics present in both.
+func (m Metrics) Merge(other Metrics) Metrics {
+	result := m.Copy()
+	for k, v := range other {
+		result[k] = result[k].Merge(v)
+	}
+	return result
+}
+
+// Copy creates a deep copy of the Metrics.
+func (m Metrics) Copy() Metrics {
+	result := Metrics{}
+	for k, v := range m {
+		result[k] = v.Copy()
+	}
+	return result
+}
+
+// Sample is the value of a metric at a point in time.
+type Sample struct {
+	Timestamp time.Time `json:"date"`
+	Value     float64   `json:"value"`
+}
+
+// Metric is a time series of samples, oldest first, with at most one sample
+// per timestamp, and at most MaxSamples samples.
+type Metric []Sample
+
+// MakeMetric returns a metric holding a single sample.
+func MakeMetric(t time.Time, value float64) Metric {
+	return Metric{{Timestamp: t, Value: value}}
+}
+
+// Add returns a fresh copy of the metric, with the sample added.
+func (m Metric) Add(t time.Time, value float64) Metric {
+	return m.Merge(MakeMetric(t, value))
+}
+
+// Merge returns the union of the samples of two metrics, by timestamp. In
+// case of conflict, the other (right-hand) side wins. Only the newest
+// MaxSamples samples are kept. Merge does not modify the receiver.
+func (m Metric) Merge(other Metric) Metric {
+	byTime := make(map[int64]Sample, len(m)+len(other))
+	for _, s := range m {
+		byTime[s.Timestamp.UnixNano()] = s
+	}
+	for _, s := range other {
+		byTime[s.Timestamp.UnixNano()] = s
+	}
+	result := make(Metric, 0, len(byTime))
+	for _, s := range byTime {
+		result = append(result, s)
+	}
+	sort.Sort(result)
+	if len(result) > MaxSamples {
+		result = result[len(result)-MaxSamples:]
+	}
+	return result
+}
+
+// Copy returns a value copy of the metric.
+func (m Metric) Copy() Metric {
+	result := make(Metric, len(m))
+	copy(result, m)
+	return result
+}
+
+// Last returns the newest sample, if any.
+func (m Metric) Last() (Sample, bool) {
+	if len(m) == 0 {
+		return Sample{}, false
+	}
+	return m[len(m)-1], true
+}
+
+func (m Metric) Len() int           { return len(m) }
+func (m Metric) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m Metric) Less(i, j int) bool { return m[i].Timestamp.Before(m[j].Timestamp) }
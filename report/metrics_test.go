@@ -0,0 +1,91 @@
+package report_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
+)
+
+func TestMergeMetric(t *testing.T) {
+	var (
+		t1 = time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
+		t2 = t1.Add(time.Second)
+		t3 = t2.Add(time.Second)
+	)
+	for name, c := range map[string]struct {
+		a, b, want report.Metric
+	}{
+		"Empty a": {
+			a:    report.Metric{},
+			b:    report.MakeMetric(t1, 1),
+			want: report.MakeMetric(t1, 1),
+		},
+		"Empty b": {
+			a:    report.MakeMetric(t1, 1),
+			b:    report.Metric{},
+			want: report.MakeMetric(t1, 1),
+		},
+		"Interleaved": {
+			a:    report.Metric{{Timestamp: t1, Value: 1}, {Timestamp: t3, Value: 3}},
+			b:    report.MakeMetric(t2, 2),
+			want: report.Metric{{Timestamp: t1, Value: 1}, {Timestamp: t2, Value: 2}, {Timestamp: t3, Value: 3}},
+		},
+		"Conflict, b wins": {
+			a:    report.Metric{{Timestamp: t1, Value: 1}, {Timestamp: t2, Value: 2}},
+			b:    report.MakeMetric(t2, 20),
+			want: report.Metric{{Timestamp: t1, Value: 1}, {Timestamp: t2, Value: 20}},
+		},
+	} {
+		if have := c.a.Merge(c.b); !reflect.DeepEqual(c.want, have) {
+			t.Errorf("%s: %s", name, test.Diff(c.want, have))
+		}
+	}
+}
+
+func TestMetricBounded(t *testing.T) {
+	var (
+		start = time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
+		m     = report.Metric{}
+	)
+	for i := 0; i < report.MaxSamples+10; i++ {
+		m = m.Add(start.Add(time.Duration(i)*time.Second), float64(i))
+	}
+	if want, have := report.MaxSamples, len(m); want != have {
+		t.Fatalf("want %d samples, have %d", want, have)
+	}
+	first, last := m[0], m[len(m)-1]
+	if want, have := float64(10), first.Value; want != have {
+		t.Errorf("want oldest sample %v, have %v", want, have)
+	}
+	if want, have := float64(report.MaxSamples+9), last.Value; want != have {
+		t.Errorf("want newest sample %v, have %v", want, have)
+	}
+}
+
+func TestMergeNodesMetrics(t *testing.T) {
+	var (
+		t1 = time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC)
+		t2 = t1.Add(time.Second)
+		a  = report.Nodes{
+			"foo": report.MakeNodeWith(map[string]string{"a": "1"}).WithMetrics(report.Metrics{
+				"load": report.MakeMetric(t1, 1),
+			}),
+		}
+		b = report.Nodes{
+			"foo": report.MakeNodeWith(map[string]string{"a": "2"}).WithMetrics(report.Metrics{
+				"load": report.MakeMetric(t2, 2),
+			}),
+		}
+		want = report.Nodes{
+			"foo": report.MakeNodeWith(map[string]string{"a": "1"}).WithMetrics(report.Metrics{
+				"load": report.MakeMetric(t1, 1).Add(t2, 2),
+			}),
+		}
+	)
+	if have := a.Merge(b); !reflect.DeepEqual(want, have) {
+		t.Errorf("%s", test.Diff(want, have))
+	}
+}
@@ -13,6 +13,7 @@ func TestContains(t *testing.T) {
 	networks := report.Networks([]*net.IPNet{
 		mustParseCIDR("10.0.0.1/8"),
 		mustParseCIDR("192.168.1.1/24"),
+		mustParseCIDR("2001:db8::1/64"),
 	})
 
 	if networks.Contains(net.ParseIP("52.52.52.52")) {
@@ -22,6 +23,18 @@ func TestContains(t *testing.T) {
 	if !networks.Contains(net.ParseIP("10.0.0.1")) {
 		t.Errorf("10.0.0.1 in %v", networks)
 	}
+
+	if !networks.Contains(net.ParseIP("::ffff:10.0.0.1")) {
+		t.Errorf("::ffff:10.0.0.1 in %v", networks)
+	}
+
+	if !networks.Contains(net.ParseIP("2001:db8::2")) {
+		t.Errorf("2001:db8::2 in %v", networks)
+	}
+
+	if networks.Contains(net.ParseIP("2001:db8:1::2")) {
+		t.Errorf("2001:db8:1::2 not in %v", networks)
+	}
 }
 
 func mustParseCIDR(s string) *net.IPNet {
@@ -55,7 +68,7 @@ func TestAddLocal(t *testing.T) {
 	defer func() { report.InterfaceByNameStub = oldInterfaceByNameStub }()
 
 	report.InterfaceByNameStub = func(name string) (report.Interface, error) {
-		return mockInterface{[]net.Addr{mockAddr("52.53.54.55/16")}}, nil
+		return mockInterface{[]net.Addr{mockAddr("52.53.54.55/16"), mockAddr("fd00::1/64")}}, nil
 	}
 
 	err := report.AddLocalBridge("foo")
@@ -63,7 +76,7 @@ func TestAddLocal(t *testing.T) {
 		t.Errorf("%v", err)
 	}
 
-	want := report.Networks([]*net.IPNet{mustParseCIDR("52.53.54.55/16")})
+	want := report.Networks([]*net.IPNet{mustParseCIDR("52.53.54.55/16"), mustParseCIDR("fd00::1/64")})
 	have := report.LocalNetworks
 
 	if !reflect.DeepEqual(want, have) {
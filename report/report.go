@@ -163,4 +163,8 @@ const (
 	// a node in the host topology. That host node is the origin host, where
 	// the node was originally detected.
 	HostNodeID = "host_node_id"
+
+	// ProbeID is a metadata key holding the ID of the probe which reported
+	// the node, so apps know where to send control requests about it.
+	ProbeID = "probe_id"
 )
@@ -63,9 +63,14 @@ func (n Nodes) Copy() Nodes {
 func (n Nodes) Merge(other Nodes) Nodes {
 	cp := n.Copy()
 	for k, v := range other {
-		if _, ok := cp[k]; !ok { // don't overwrite
+		existing, ok := cp[k]
+		if !ok {
 			cp[k] = v.Copy()
+			continue
 		}
+		// don't overwrite, but do keep the history of both
+		existing.Metrics = existing.Metrics.Merge(v.Metrics)
+		cp[k] = existing
 	}
 	return cp
 }
@@ -76,6 +81,7 @@ func (n Nodes) Merge(other Nodes) Nodes {
 type Node struct {
 	Metadata  `json:"-"`
 	Counters  `json:"-"`
+	Metrics   Metrics       `json:"-"`
 	Adjacency IDList        `json:"adjacency"`
 	Edges     EdgeMetadatas `json:"-"`
 }
@@ -85,6 +91,7 @@ func MakeNode() Node {
 	return Node{
 		Metadata:  Metadata{},
 		Counters:  Counters{},
+		Metrics:   Metrics{},
 		Adjacency: MakeIDList(),
 		Edges:     EdgeMetadatas{},
 	}
@@ -109,6 +116,13 @@ func (n Node) WithCounters(c map[string]int) Node {
 	return result
 }
 
+// WithMetrics returns a fresh copy of n, with Metrics set to m
+func (n Node) WithMetrics(m map[string]Metric) Node {
+	result := n.Copy()
+	result.Metrics = m
+	return result
+}
+
 // WithAdjacency returns a fresh copy of n, with Adjacency set to a
 func (n Node) WithAdjacency(a IDList) Node {
 	result := n.Copy()
@@ -136,6 +150,7 @@ func (n Node) Copy() Node {
 	cp := MakeNode()
 	cp.Metadata = n.Metadata.Copy()
 	cp.Counters = n.Counters.Copy()
+	cp.Metrics = n.Metrics.Copy()
 	cp.Adjacency = n.Adjacency.Copy()
 	cp.Edges = n.Edges.Copy()
 	return cp
@@ -147,6 +162,7 @@ func (n Node) Merge(other Node) Node {
 	cp := n.Copy()
 	cp.Metadata = cp.Metadata.Merge(other.Metadata)
 	cp.Counters = cp.Counters.Merge(other.Counters)
+	cp.Metrics = cp.Metrics.Merge(other.Metrics)
 	cp.Adjacency = cp.Adjacency.Merge(other.Adjacency)
 	cp.Edges = cp.Edges.Merge(other.Edges)
 	return cp
@@ -238,6 +254,8 @@ type EdgeMetadata struct {
 	EgressByteCount    *uint64 `json:"egress_byte_count,omitempty"`  // Transport layer
 	IngressByteCount   *uint64 `json:"ingress_byte_count,omitempty"` // Transport layer
 	MaxConnCountTCP    *uint64 `json:"max_conn_count_tcp,omitempty"`
+	MaxConnCountUDP    *uint64 `json:"max_conn_count_udp,omitempty"` // UDP flows, as tracked by conntrack
+	Protocols          IDList  `json:"protocols,omitempty"`          // Transport layer, e.g. tcp, udp
 }
 
 // Copy returns a value copy of the EdgeMetadata.
@@ -248,7 +266,16 @@ func (e EdgeMetadata) Copy() EdgeMetadata {
 		EgressByteCount:    cpu64ptr(e.EgressByteCount),
 		IngressByteCount:   cpu64ptr(e.IngressByteCount),
 		MaxConnCountTCP:    cpu64ptr(e.MaxConnCountTCP),
+		MaxConnCountUDP:    cpu64ptr(e.MaxConnCountUDP),
+		Protocols:          cpIDList(e.Protocols),
+	}
+}
+
+func cpIDList(l IDList) IDList {
+	if l == nil {
+		return nil
 	}
+	return l.Copy()
 }
 
 func cpu64ptr(u *uint64) *uint64 {
@@ -269,6 +296,8 @@ func (e EdgeMetadata) Merge(other EdgeMetadata) EdgeMetadata {
 	cp.EgressByteCount = merge(cp.EgressByteCount, other.EgressByteCount, sum)
 	cp.IngressByteCount = merge(cp.IngressByteCount, other.IngressByteCount, sum)
 	cp.MaxConnCountTCP = merge(cp.MaxConnCountTCP, other.MaxConnCountTCP, max)
+	cp.MaxConnCountUDP = merge(cp.MaxConnCountUDP, other.MaxConnCountUDP, max)
+	cp.Protocols = cp.Protocols.Merge(other.Protocols)
 	return cp
 }
 
@@ -284,6 +313,8 @@ func (e EdgeMetadata) Flatten(other EdgeMetadata) EdgeMetadata {
 	// Note that summing of two maximums doesn't always give us the true
 	// maximum. But it's a best effort.
 	cp.MaxConnCountTCP = merge(cp.MaxConnCountTCP, other.MaxConnCountTCP, sum)
+	cp.MaxConnCountUDP = merge(cp.MaxConnCountUDP, other.MaxConnCountUDP, sum)
+	cp.Protocols = cp.Protocols.Merge(other.Protocols)
 	return cp
 }
 
@@ -0,0 +1,425 @@
+package xfer
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// WireVersion is the version of the envelope format produced by this
+// package. It's only incremented for incompatible changes; adding fields to
+// the wire types is compatible, as decoders on both codecs ignore fields they
+// don't know about, and default fields that are missing.
+const WireVersion = 1
+
+// Content types for the codecs. Requests without a recognised content type
+// are assumed to carry a bare, gob-encoded report.Report, which is what
+// probes sent before the envelope was introduced.
+const (
+	JSONContentType   = "application/json"
+	BinaryContentType = "application/x-scope-report"
+)
+
+// Envelope wraps a report with the metadata needed to interpret it. It's
+// what travels between probes and apps.
+//
+// The JSON form looks like
+//
+//	{
+//	  "version": 1,
+//	  "probe_id": "host1",
+//	  "probe_version": "0.7.0",
+//	  "hostname": "host1",
+//	  "boot_id": "9b1ae2d4c0f37a65",
+//	  "sequence": 42,
+//	  "timestamp": "2015-08-20T12:00:00.000000000Z",
+//	  "encoding": "json",
+//	  "report": {
+//	    "topologies": {
+//	      "endpoint": {
+//	        "nodes": {
+//	          "host1;10.0.0.1;80": {
+//	            "metadata": {"addr": "10.0.0.1", "port": "80"},
+//	            "counters": {},
+//	            "adjacency": [";10.0.0.2;54321"],
+//	            "edges": {";10.0.0.2;54321": {"max_conn_count_tcp": 1}}
+//	          }
+//	        }
+//	      },
+//	      "host": {"nodes": {}}
+//	    },
+//	    "sampling": {"count": 0, "total": 0},
+//	    "window": "3s"
+//	  }
+//	}
+//
+// Topologies are keyed by name (endpoint, address, process, container,
+// container_image, host, overlay); unknown topologies are ignored, and
+// missing ones are empty. Nodes may also have "metrics", time series keyed by
+// name, each a list of {"date": ..., "value": ...} samples, oldest first.
+//
+// A delta envelope has an empty report, plus "base", the sequence number of
+// the report it's relative to, and "delta", which has the same structure as
+// the report except that each topology has "upserts" (nodes added or
+// changed) and "removed" (node IDs) instead of "nodes". The binary form is
+// the same structure, gob-encoded.
+type Envelope struct {
+	Version      int
+	ProbeID      string
+	ProbeVersion string
+	Hostname     string
+	BootID       string // changes whenever the probe restarts; empty if unknown
+	Sequence     uint64 // per-probe, starting at 1 at every boot; 0 means unknown
+	Timestamp    time.Time
+	Encoding     string
+	Report       report.Report
+
+	// If Delta is non-nil, the envelope holds a delta, relative to the report
+	// with sequence number Base from the same probe, rather than a full
+	// Report.
+	Base  uint64
+	Delta *report.Delta
+
+	// Size is the encoded size of the envelope in bytes. It's set by
+	// DecodeEnvelope, and isn't part of the wire format.
+	Size int
+}
+
+// Codec encodes and decodes envelopes to and from a particular wire format.
+type Codec interface {
+	Name() string
+	ContentType() string
+	Encode(io.Writer, Envelope) error
+	Decode(io.Reader) (Envelope, error)
+}
+
+var (
+	// JSONCodec is the human-readable, documented codec.
+	JSONCodec Codec = jsonCodec{}
+
+	// BinaryCodec is the compact codec. It's the default for publishing.
+	BinaryCodec Codec = binaryCodec{}
+)
+
+// DecodeEnvelope decodes an envelope from r, choosing the codec according to
+// the content type. Bare gob-encoded reports from older probes are decoded
+// into an envelope with version 0.
+func DecodeEnvelope(contentType string, r io.Reader) (Envelope, error) {
+	cr := &countingReader{r: r}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, codec := range []Codec{JSONCodec, BinaryCodec} {
+		if mediaType == codec.ContentType() {
+			env, err := codec.Decode(cr)
+			env.Size = cr.n
+			return env, err
+		}
+	}
+
+	var rpt report.Report
+	if err := gob.NewDecoder(cr).Decode(&rpt); err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{Encoding: "gob", Report: rpt, Size: cr.n}, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return JSONContentType }
+
+func (c jsonCodec) Encode(w io.Writer, e Envelope) error {
+	return json.NewEncoder(w).Encode(toWire(c, e))
+}
+
+func (jsonCodec) Decode(r io.Reader) (Envelope, error) {
+	var we wireEnvelope
+	if err := json.NewDecoder(r).Decode(&we); err != nil {
+		return Envelope{}, err
+	}
+	return fromWire(we)
+}
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string        { return "gob" }
+func (binaryCodec) ContentType() string { return BinaryContentType }
+
+func (c binaryCodec) Encode(w io.Writer, e Envelope) error {
+	return gob.NewEncoder(w).Encode(toWire(c, e))
+}
+
+func (binaryCodec) Decode(r io.Reader) (Envelope, error) {
+	var we wireEnvelope
+	if err := gob.NewDecoder(r).Decode(&we); err != nil {
+		return Envelope{}, err
+	}
+	return fromWire(we)
+}
+
+// The wire types decouple the encoded form from report.Report, so that the
+// in-memory types can change without breaking mixed-version deployments.
+// Any change to report.Report needs a matching change here.
+type wireEnvelope struct {
+	Version      int        `json:"version"`
+	ProbeID      string     `json:"probe_id"`
+	ProbeVersion string     `json:"probe_version,omitempty"`
+	Hostname     string     `json:"hostname,omitempty"`
+	BootID       string     `json:"boot_id,omitempty"`
+	Sequence     uint64     `json:"sequence,omitempty"`
+	Timestamp    time.Time  `json:"timestamp"`
+	Encoding     string     `json:"encoding"`
+	Report       wireReport `json:"report"`
+	Base         uint64     `json:"base,omitempty"`
+	Delta        *wireDelta `json:"delta,omitempty"`
+}
+
+type wireReport struct {
+	Topologies map[string]wireTopology `json:"topologies"`
+	Sampling   wireSampling            `json:"sampling"`
+	Window     string                  `json:"window"`
+}
+
+type wireTopology struct {
+	Nodes map[string]wireNode `json:"nodes"`
+}
+
+type wireNode struct {
+	Metadata  map[string]string           `json:"metadata,omitempty"`
+	Counters  map[string]int              `json:"counters,omitempty"`
+	Metrics   map[string][]wireSample     `json:"metrics,omitempty"`
+	Adjacency []string                    `json:"adjacency,omitempty"`
+	Edges     map[string]wireEdgeMetadata `json:"edges,omitempty"`
+}
+
+type wireSample struct {
+	Timestamp time.Time `json:"date"`
+	Value     float64   `json:"value"`
+}
+
+type wireEdgeMetadata struct {
+	EgressPacketCount  *uint64  `json:"egress_packet_count,omitempty"`
+	IngressPacketCount *uint64  `json:"ingress_packet_count,omitempty"`
+	EgressByteCount    *uint64  `json:"egress_byte_count,omitempty"`
+	IngressByteCount   *uint64  `json:"ingress_byte_count,omitempty"`
+	MaxConnCountTCP    *uint64  `json:"max_conn_count_tcp,omitempty"`
+	MaxConnCountUDP    *uint64  `json:"max_conn_count_udp,omitempty"`
+	Protocols          []string `json:"protocols,omitempty"`
+}
+
+type wireDelta struct {
+	Topologies map[string]wireTopologyDelta `json:"topologies"`
+	Sampling   wireSampling                 `json:"sampling"`
+	Window     string                       `json:"window"`
+}
+
+type wireTopologyDelta struct {
+	Upserts map[string]wireNode `json:"upserts,omitempty"`
+	Removed []string            `json:"removed,omitempty"`
+}
+
+type wireSampling struct {
+	Count uint64 `json:"count"`
+	Total uint64 `json:"total"`
+}
+
+// topologies maps wire topology names to the topologies of a report.
+func topologies(r *report.Report) map[string]*report.Topology {
+	return map[string]*report.Topology{
+		"endpoint":        &r.Endpoint,
+		"address":         &r.Address,
+		"process":         &r.Process,
+		"container":       &r.Container,
+		"container_image": &r.ContainerImage,
+		"host":            &r.Host,
+		"overlay":         &r.Overlay,
+	}
+}
+
+// topologyDeltas maps wire topology names to the topologies of a delta.
+func topologyDeltas(d *report.Delta) map[string]*report.TopologyDelta {
+	return map[string]*report.TopologyDelta{
+		"endpoint":        &d.Endpoint,
+		"address":         &d.Address,
+		"process":         &d.Process,
+		"container":       &d.Container,
+		"container_image": &d.ContainerImage,
+		"host":            &d.Host,
+		"overlay":         &d.Overlay,
+	}
+}
+
+func toWire(c Codec, e Envelope) wireEnvelope {
+	wr := wireReport{
+		Topologies: map[string]wireTopology{},
+		Sampling:   wireSampling{Count: e.Report.Sampling.Count, Total: e.Report.Sampling.Total},
+		Window:     e.Report.Window.String(),
+	}
+	for name, t := range topologies(&e.Report) {
+		wr.Topologies[name] = wireTopology{Nodes: nodesToWire(t.Nodes)}
+	}
+	we := wireEnvelope{
+		Version:      WireVersion,
+		ProbeID:      e.ProbeID,
+		ProbeVersion: e.ProbeVersion,
+		Hostname:     e.Hostname,
+		BootID:       e.BootID,
+		Sequence:     e.Sequence,
+		Timestamp:    e.Timestamp,
+		Encoding:     c.Name(),
+		Report:       wr,
+		Base:         e.Base,
+	}
+	if e.Delta != nil {
+		wd := &wireDelta{
+			Topologies: map[string]wireTopologyDelta{},
+			Sampling:   wireSampling{Count: e.Delta.Sampling.Count, Total: e.Delta.Sampling.Total},
+			Window:     e.Delta.Window.String(),
+		}
+		for name, td := range topologyDeltas(e.Delta) {
+			wd.Topologies[name] = wireTopologyDelta{
+				Upserts: nodesToWire(td.Upserts),
+				Removed: td.Removed,
+			}
+		}
+		we.Delta = wd
+	}
+	return we
+}
+
+func fromWire(we wireEnvelope) (Envelope, error) {
+	if we.Version > WireVersion {
+		return Envelope{}, fmt.Errorf("unsupported envelope version %d (want <= %d)", we.Version, WireVersion)
+	}
+	rpt := report.MakeReport()
+	rpt.Sampling = report.Sampling{Count: we.Report.Sampling.Count, Total: we.Report.Sampling.Total}
+	window, err := windowFromWire(we.Report.Window)
+	if err != nil {
+		return Envelope{}, err
+	}
+	rpt.Window = window
+	for name, t := range topologies(&rpt) {
+		t.Nodes = nodesFromWire(we.Report.Topologies[name].Nodes)
+	}
+	env := Envelope{
+		Version:      we.Version,
+		ProbeID:      we.ProbeID,
+		ProbeVersion: we.ProbeVersion,
+		Hostname:     we.Hostname,
+		BootID:       we.BootID,
+		Sequence:     we.Sequence,
+		Timestamp:    we.Timestamp,
+		Encoding:     we.Encoding,
+		Report:       rpt,
+		Base:         we.Base,
+	}
+	if we.Delta != nil {
+		d := &report.Delta{
+			Sampling: report.Sampling{Count: we.Delta.Sampling.Count, Total: we.Delta.Sampling.Total},
+		}
+		if d.Window, err = windowFromWire(we.Delta.Window); err != nil {
+			return Envelope{}, err
+		}
+		for name, td := range topologyDeltas(d) {
+			wtd := we.Delta.Topologies[name]
+			td.Upserts = nodesFromWire(wtd.Upserts)
+			td.Removed = report.MakeIDList(wtd.Removed...)
+		}
+		env.Delta = d
+	}
+	return env, nil
+}
+
+func windowFromWire(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func nodesToWire(nodes report.Nodes) map[string]wireNode {
+	result := map[string]wireNode{}
+	for id, n := range nodes {
+		wn := wireNode{
+			Metadata:  n.Metadata,
+			Counters:  n.Counters,
+			Metrics:   map[string][]wireSample{},
+			Adjacency: n.Adjacency,
+			Edges:     map[string]wireEdgeMetadata{},
+		}
+		for k, m := range n.Metrics {
+			samples := make([]wireSample, 0, len(m))
+			for _, s := range m {
+				samples = append(samples, wireSample{Timestamp: s.Timestamp, Value: s.Value})
+			}
+			wn.Metrics[k] = samples
+		}
+		for dst, md := range n.Edges {
+			wn.Edges[dst] = wireEdgeMetadata{
+				EgressPacketCount:  md.EgressPacketCount,
+				IngressPacketCount: md.IngressPacketCount,
+				EgressByteCount:    md.EgressByteCount,
+				IngressByteCount:   md.IngressByteCount,
+				MaxConnCountTCP:    md.MaxConnCountTCP,
+				MaxConnCountUDP:    md.MaxConnCountUDP,
+				Protocols:          md.Protocols,
+			}
+		}
+		result[id] = wn
+	}
+	return result
+}
+
+func nodesFromWire(wireNodes map[string]wireNode) report.Nodes {
+	nodes := report.Nodes{}
+	for id, wn := range wireNodes {
+		n := report.MakeNode()
+		for k, v := range wn.Metadata {
+			n.Metadata[k] = v
+		}
+		for k, v := range wn.Counters {
+			n.Counters[k] = v
+		}
+		for k, samples := range wn.Metrics {
+			m := make(report.Metric, 0, len(samples))
+			for _, s := range samples {
+				m = append(m, report.Sample{Timestamp: s.Timestamp, Value: s.Value})
+			}
+			n.Metrics[k] = report.Metric{}.Merge(m)
+		}
+		for _, dst := range wn.Adjacency {
+			n.Adjacency = n.Adjacency.Add(dst)
+		}
+		for dst, md := range wn.Edges {
+			e := report.EdgeMetadata{
+				EgressPacketCount:  md.EgressPacketCount,
+				IngressPacketCount: md.IngressPacketCount,
+				EgressByteCount:    md.EgressByteCount,
+				IngressByteCount:   md.IngressByteCount,
+				MaxConnCountTCP:    md.MaxConnCountTCP,
+				MaxConnCountUDP:    md.MaxConnCountUDP,
+			}
+			if len(md.Protocols) > 0 {
+				e.Protocols = report.MakeIDList(md.Protocols...)
+			}
+			n.Edges[dst] = e
+		}
+		nodes[id] = n
+	}
+	return nodes
+}
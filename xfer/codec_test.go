@@ -0,0 +1,118 @@
+package xfer_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
+	"github.com/weaveworks/scope/xfer"
+)
+
+func TestCodecs(t *testing.T) {
+	rpt := makeReport("foo")
+	rpt.Window = 3 * time.Second
+	rpt.Sampling = report.Sampling{Count: 1, Total: 2}
+	node := rpt.Endpoint.Nodes["foo"]
+	node.Adjacency = node.Adjacency.Add("bar")
+	node.Counters["requests"] = 3
+	node.Metrics["load1"] = report.MakeMetric(time.Date(2015, 8, 20, 11, 59, 57, 0, time.UTC), 0.25).
+		Add(time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC), 0.5)
+	conns := uint64(2)
+	node.Edges["bar"] = report.EdgeMetadata{MaxConnCountTCP: &conns, MaxConnCountUDP: &conns, Protocols: report.MakeIDList("udp", "tcp")}
+	rpt.Endpoint.Nodes["foo"] = node
+
+	want := xfer.Envelope{
+		Version:      xfer.WireVersion,
+		ProbeID:      "probe",
+		ProbeVersion: "0.7.0",
+		Hostname:     "host1",
+		BootID:       "9b1ae2d4c0f37a65",
+		Sequence:     42,
+		Timestamp:    time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC),
+		Report:       rpt,
+	}
+	for _, codec := range []xfer.Codec{xfer.JSONCodec, xfer.BinaryCodec} {
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, want); err != nil {
+			t.Fatal(err)
+		}
+		have, err := xfer.DecodeEnvelope(codec.ContentType(), &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Encoding = codec.Name()
+		have.Size = 0
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", codec.Name(), test.Diff(want, have))
+		}
+	}
+}
+
+func TestDecodeLegacyEnvelope(t *testing.T) {
+	rpt := makeReport("foo")
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rpt); err != nil {
+		t.Fatal(err)
+	}
+	env, err := xfer.DecodeEnvelope("", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, have := 0, env.Version; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := rpt, report.MakeReport().Merge(env.Report); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestDecodeJSONEnvelope(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		ok   bool
+	}{
+		{`{"version": 1, "report": {"topologies": {"endpoint": {"nodes": {"foo": {"metadata": {"id": "foo"}}}}}}}`, true},
+		{`{"version": 1, "unknown": 1, "report": {"topologies": {"endpoint": {"nodes": {"foo": {"metadata": {"id": "foo"}, "unknown": 2}}}, "unknown": {}}}}`, true},
+		{`{"version": 2, "report": {}}`, false},
+		{`{"version": 1, "report": {"window": "foo"}}`, false},
+	} {
+		env, err := xfer.DecodeEnvelope("application/json; charset=utf-8", strings.NewReader(tc.body))
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.body, err)
+			continue
+		}
+		if want, have := makeReport("foo"), env.Report; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", tc.body, test.Diff(want, have))
+		}
+	}
+}
+
+func TestCodecsDelta(t *testing.T) {
+	d := report.MakeDelta(makeReport("foo"), makeReport("bar"))
+	want := xfer.Envelope{Version: xfer.WireVersion, Sequence: 2, Base: 1, Report: report.MakeReport(), Delta: &d}
+	for _, codec := range []xfer.Codec{xfer.JSONCodec, xfer.BinaryCodec} {
+		var buf bytes.Buffer
+		if err := codec.Encode(&buf, want); err != nil {
+			t.Fatal(err)
+		}
+		have, err := codec.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.Encoding = codec.Name()
+		if !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", codec.Name(), test.Diff(want, have))
+		}
+	}
+}
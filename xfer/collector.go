@@ -1,6 +1,9 @@
 package xfer
 
 import (
+	"errors"
+	"log"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,31 +22,237 @@ type Adder interface {
 	Add(report.Report)
 }
 
+// EnvelopeAdder is something that can accept reports along with the
+// metadata describing where they came from.
+type EnvelopeAdder interface {
+	AddEnvelope(Envelope) (bool, error)
+}
+
+// ErrResync is returned when a delta can't be applied, because the report
+// it's relative to isn't known. The probe should send a full report.
+var ErrResync = errors.New("resync required")
+
+// ProbeInfo describes a probe that has sent reports to a collector.
+// LastSeen is by the collector's clock; LastReport is the timestamp of the
+// last report, by the probe's clock. Connected is true while the probe has a
+// persistent connection open. A probe is Silent if it hasn't been seen for
+// several publish intervals, or if its persistent connection has closed.
+type ProbeInfo struct {
+	ID           string        `json:"id"`
+	Version      string        `json:"version,omitempty"`
+	Hostname     string        `json:"hostname,omitempty"`
+	LastSeen     time.Time     `json:"last_seen"`
+	LastReport   time.Time     `json:"last_report"`
+	LastSequence uint64        `json:"last_sequence"`
+	ReportSize   int           `json:"report_size"`
+	Interval     time.Duration `json:"publish_interval"`
+	Reports      int           `json:"reports"`
+	Duplicates   int           `json:"duplicates"`
+	Connected    bool          `json:"connected"`
+	Silent       bool          `json:"silent"`
+}
+
 // Collector receives published reports from multiple producers. It yields a
-// single merged report, representing all collected reports.
+// single merged report, representing all collected reports. Optionally, it
+// writes every report to a Store, so that it can yield merged reports for
+// ranges of time outside of its window.
+//
+// Reports added with a probe ID and sequence number are deduplicated, so a
+// probe publishing to several addresses of the same app is only counted
+// once. Sequence numbers start again when the probe restarts, which is
+// recognised by a change of boot ID. Probes which have been silent for a
+// long time, and aren't connected, are forgotten.
 type Collector struct {
-	mtx     sync.Mutex
-	reports []timestampReport
-	window  time.Duration
+	mtx        sync.Mutex
+	reports    []timestampReport
+	window     time.Duration
+	store      Store
+	probes     map[string]*probeState
+	lastForget time.Time
+}
+
+// silentIntervals is how many publish intervals may pass without a report
+// from a probe before it's considered silent.
+const silentIntervals = 3
+
+// forgetIntervals is how many silent intervals may pass before the collector
+// forgets a probe, along with the report its deltas are relative to.
+const forgetIntervals = 10
+
+// replayWindow is how many sequence numbers are remembered per probe. For
+// probes which don't send a boot ID, a sequence number further behind than
+// that is taken to mean the probe has restarted.
+const replayWindow = 1024
+
+type probeState struct {
+	ProbeInfo
+	bootID       string
+	disconnected bool
+	seen         map[uint64]struct{}
+	base         report.Report // the full report with sequence number baseSeq
+	baseSeq      uint64
 }
 
 // NewCollector returns a collector ready for use.
 func NewCollector(window time.Duration) *Collector {
 	return &Collector{
 		window: window,
+		probes: map[string]*probeState{},
 	}
 }
 
-var now = time.Now
+// NewStoringCollector returns a collector ready for use, which also writes
+// every added report to the store.
+func NewStoringCollector(window time.Duration, store Store) *Collector {
+	return &Collector{
+		window: window,
+		store:  store,
+		probes: map[string]*probeState{},
+	}
+}
+
+// Now returns the current time, by the app's clock. It is exported for
+// testing.
+var Now = time.Now
 
 // Add adds a report to the collector's internal state. It implements Adder.
 func (c *Collector) Add(rpt report.Report) {
+	ts := Now()
+	c.mtx.Lock()
+	c.add(ts, rpt)
+	c.mtx.Unlock()
+	c.put(ts, rpt)
+}
+
+// AddEnvelope adds the report in the envelope, unless it's a duplicate of
+// one already added from the same probe. The report is filed under the
+// envelope's timestamp, if it has one, but never more than half the window
+// from the app's clock, so a probe whose clock is wrong still shows up, and
+// can't file reports far in the past or the future. A delta is applied to the report
+// it's relative to, which must be the last full report from the probe, or
+// else ErrResync is returned. It returns whether the report was added. It
+// implements EnvelopeAdder.
+func (c *Collector) AddEnvelope(env Envelope) (bool, error) {
+	ts := c.envelopeTime(env)
+	c.mtx.Lock()
+	rpt, added, err := c.addEnvelope(ts, env)
+	c.mtx.Unlock()
+	if added {
+		c.put(ts, rpt)
+	}
+	return added, err
+}
+
+// addEnvelope does the work of AddEnvelope, under the lock, returning the
+// (full) report added.
+func (c *Collector) addEnvelope(ts time.Time, env Envelope) (report.Report, bool, error) {
+	if env.ProbeID == "" {
+		if env.Delta != nil {
+			return report.Report{}, false, ErrResync
+		}
+		c.add(ts, env.Report)
+		return env.Report, true, nil
+	}
+
+	p := c.probe(env.ProbeID)
+	if env.BootID != p.bootID {
+		p.reboot(env.BootID)
+	}
+	if p.duplicate(env.Sequence) {
+		p.Duplicates++
+		return report.Report{}, false, nil
+	}
+	if env.Delta != nil {
+		if env.Base == 0 || env.Base != p.baseSeq {
+			return report.Report{}, false, ErrResync
+		}
+		env.Report = p.base.ApplyDelta(*env.Delta)
+	}
+	p.observe(env.Sequence)
+	if env.Sequence == 0 || env.Sequence >= p.baseSeq {
+		p.base, p.baseSeq = env.Report, env.Sequence
+	}
+
+	p.Version = env.ProbeVersion
+	p.Hostname = env.Hostname
+	p.LastSeen = Now()
+	p.LastReport = env.Timestamp
+	p.ReportSize = env.Size
+	p.Interval = env.Report.Window
+	p.Reports++
+	c.add(ts, env.Report)
+	c.forget()
+	return env.Report, true, nil
+}
+
+// SetConnected records whether the probe has a persistent connection open.
+func (c *Collector) SetConnected(probeID string, connected bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	p := c.probe(probeID)
+	p.Connected = connected
+	p.disconnected = !connected
+}
+
+func (c *Collector) probe(id string) *probeState {
+	p, ok := c.probes[id]
+	if !ok {
+		p = &probeState{ProbeInfo: ProbeInfo{ID: id}}
+		c.probes[id] = p
+	}
+	return p
+}
+
+// Probes returns information about every probe which has added an envelope,
+// or connected, ordered by ID.
+func (c *Collector) Probes() []ProbeInfo {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
-	c.reports = append(c.reports, timestampReport{now(), rpt})
+
+	probes := make([]ProbeInfo, 0, len(c.probes))
+	for _, p := range c.probes {
+		info := p.ProbeInfo
+		info.Silent = p.disconnected || Now().Sub(info.LastSeen) > p.silentAfter(c.window)
+		probes = append(probes, info)
+	}
+	sort.Sort(probesByID(probes))
+	return probes
+}
+
+// envelopeTime returns the envelope's timestamp, clamped to within half the
+// window of now, or now if it has none.
+func (c *Collector) envelopeTime(env Envelope) time.Time {
+	var (
+		now       = Now()
+		tolerance = c.window / 2
+	)
+	switch {
+	case env.Timestamp.IsZero():
+		return now
+	case env.Timestamp.Before(now.Add(-tolerance)):
+		return now.Add(-tolerance)
+	case env.Timestamp.After(now.Add(tolerance)):
+		return now.Add(tolerance)
+	}
+	return env.Timestamp
+}
+
+func (c *Collector) add(ts time.Time, rpt report.Report) {
+	c.reports = append(c.reports, timestampReport{ts, rpt})
 	c.reports = clean(c.reports, c.window)
 }
 
+// put writes the report to the store, if there is one. It's called without
+// the lock, so a slow disk doesn't hold up readers of the merged report.
+func (c *Collector) put(ts time.Time, rpt report.Report) {
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Put(ts, rpt); err != nil {
+		log.Printf("collector: %v", err)
+	}
+}
+
 // Report returns a merged report over all added reports. It implements
 // Reporter.
 func (c *Collector) Report() report.Report {
@@ -59,6 +268,104 @@ func (c *Collector) Report() report.Report {
 	return rpt
 }
 
+// ReportRange returns a merged report over all reports added between from
+// and to, inclusive. Without a store, only reports within the window are
+// available. It implements RangeReporter.
+func (c *Collector) ReportRange(from, to time.Time) (report.Report, error) {
+	if c.store != nil {
+		return c.store.Range(from, to) // c.store is safe for concurrent use
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	rpt := report.MakeReport()
+	for _, tr := range c.reports {
+		if tr.timestamp.Before(from) || tr.timestamp.After(to) {
+			continue
+		}
+		rpt = rpt.Merge(tr.report)
+	}
+	return rpt, nil
+}
+
+// forget drops the state of probes which are neither connected, nor have
+// been seen for forgetIntervals silent intervals. It does so at most once per
+// window.
+func (c *Collector) forget() {
+	now := Now()
+	if now.Sub(c.lastForget) < c.window {
+		return
+	}
+	c.lastForget = now
+	for id, p := range c.probes {
+		if !p.Connected && now.Sub(p.LastSeen) > forgetIntervals*p.silentAfter(c.window) {
+			delete(c.probes, id)
+		}
+	}
+}
+
+// silentAfter returns how long the probe may go without being seen before
+// it's considered silent.
+func (p *probeState) silentAfter(window time.Duration) time.Duration {
+	if after := silentIntervals * p.Interval; after > 0 {
+		return after
+	}
+	return window
+}
+
+// reboot forgets the sequence numbers seen, and the base report, as they
+// belong to the previous run of the probe.
+func (p *probeState) reboot(bootID string) {
+	p.bootID = bootID
+	p.LastSequence, p.seen = 0, nil
+	p.base, p.baseSeq = report.Report{}, 0
+}
+
+// duplicate returns true if the sequence number has already been seen.
+// Sequence number 0 is never considered a duplicate.
+func (p *probeState) duplicate(seq uint64) bool {
+	if seq == 0 || p.restarted(seq) {
+		return false
+	}
+	_, ok := p.seen[seq]
+	return ok
+}
+
+// restarted returns true if the sequence number is so far behind the last
+// one that the probe must have restarted.
+func (p *probeState) restarted(seq uint64) bool {
+	return seq+replayWindow <= p.LastSequence
+}
+
+// observe records the sequence number as seen.
+func (p *probeState) observe(seq uint64) {
+	if seq == 0 {
+		return
+	}
+	if p.restarted(seq) {
+		p.LastSequence, p.seen, p.baseSeq = 0, nil, 0
+	}
+	if p.seen == nil {
+		p.seen = map[uint64]struct{}{}
+	}
+	p.seen[seq] = struct{}{}
+	if seq > p.LastSequence {
+		p.LastSequence = seq
+		for s := range p.seen {
+			if s+replayWindow <= seq {
+				delete(p.seen, s)
+			}
+		}
+	}
+}
+
+type probesByID []ProbeInfo
+
+func (p probesByID) Len() int           { return len(p) }
+func (p probesByID) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p probesByID) Less(i, j int) bool { return p[i].ID < p[j].ID }
+
 type timestampReport struct {
 	timestamp time.Time
 	report    report.Report
@@ -67,7 +374,7 @@ type timestampReport struct {
 func clean(reports []timestampReport, window time.Duration) []timestampReport {
 	var (
 		cleaned = make([]timestampReport, 0, len(reports))
-		oldest  = now().Add(-window)
+		oldest  = Now().Add(-window)
 	)
 	for _, tr := range reports {
 		if tr.timestamp.Before(oldest) {
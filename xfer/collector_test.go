@@ -1,6 +1,9 @@
 package xfer_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"time"
 
@@ -40,3 +43,275 @@ func TestCollector(t *testing.T) {
 		t.Error(test.Diff(want, have))
 	}
 }
+
+func TestStoringCollector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := xfer.NewDiskStore(dir, time.Minute, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var (
+		c     = xfer.NewStoringCollector(time.Millisecond, store)
+		r1    = makeReport("foo")
+		begin = time.Now()
+	)
+	c.Add(r1)
+	time.Sleep(2 * time.Millisecond)
+
+	// r1 has fallen out of the window, but should still be in the store.
+	if want, have := report.MakeReport(), c.Report(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	have, err := c.ReportRange(begin, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := merged(r1); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestStoringCollectorClockSkew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := xfer.NewDiskStore(dir, time.Minute, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	var (
+		begin = time.Now()
+		clock = begin
+		c     = xfer.NewStoringCollector(time.Minute, store)
+		rpts  []report.Report
+	)
+	defer mockNow(&clock)()
+	for i, id := range []string{"a", "b", "c"} {
+		clock = begin.Add(time.Duration(i) * time.Minute)
+		rpts = append(rpts, makeReport(id))
+		mustAdd(t, c, xfer.Envelope{ProbeID: "probe", Sequence: uint64(i + 1), Timestamp: clock, Report: rpts[i]})
+	}
+
+	// Probes whose clocks are a day ahead, or an hour behind, still show up,
+	// and expire nothing from the store.
+	var (
+		ahead  = makeReport("ahead")
+		behind = makeReport("behind")
+	)
+	mustAdd(t, c, xfer.Envelope{ProbeID: "ahead", Sequence: 1, Timestamp: clock.Add(24 * time.Hour), Report: ahead})
+	mustAdd(t, c, xfer.Envelope{ProbeID: "behind", Sequence: 1, Timestamp: clock.Add(-time.Hour), Report: behind})
+	if want, have := merged(rpts[1], rpts[2], ahead, behind), c.Report(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	have, err := c.ReportRange(begin, clock.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := merged(append(rpts, ahead, behind)...); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if want, have := 3, len(segments); want != have {
+		t.Errorf("want %d segments, have %d", want, have)
+	}
+}
+
+func TestCollectorDeduplicates(t *testing.T) {
+	var (
+		c   = xfer.NewCollector(time.Minute)
+		rpt = makeReport("foo")
+	)
+	rpt.Window = time.Second
+
+	for _, tc := range []struct {
+		probeID  string
+		sequence uint64
+		added    bool
+	}{
+		{"probe1", 1, true},
+		{"probe1", 1, false}, // delivered again, via another address
+		{"probe2", 1, true},
+		{"probe1", 3, true},
+		{"probe1", 2, true}, // late, but not seen before
+		{"probe1", 3, false},
+		{"probe1", 0, true}, // no sequence number
+		{"", 1, true},       // no probe ID
+	} {
+		env := xfer.Envelope{ProbeID: tc.probeID, Sequence: tc.sequence, Report: rpt}
+		if want, have := tc.added, mustAdd(t, c, env); want != have {
+			t.Errorf("%s/%d: want %v, have %v", tc.probeID, tc.sequence, want, have)
+		}
+	}
+
+	// Windows are summed when reports are merged.
+	if want, have := 6*time.Second, c.Report().Window; want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	probes := c.Probes()
+	if want, have := 2, len(probes); want != have {
+		t.Fatalf("want %d, have %d", want, have)
+	}
+	if want, have := (xfer.ProbeInfo{ID: "probe1", LastSeen: probes[0].LastSeen, LastSequence: 3, Interval: time.Second, Reports: 4, Duplicates: 2}), probes[0]; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if probes[0].LastSeen.IsZero() {
+		t.Error("want last seen time")
+	}
+
+	// A probe that restarts begins again at 1.
+	mustAdd(t, c, xfer.Envelope{ProbeID: "probe2", Sequence: 5000, Report: rpt})
+	if want, have := true, mustAdd(t, c, xfer.Envelope{ProbeID: "probe2", Sequence: 1, Report: rpt}); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestCollectorProbeRestart(t *testing.T) {
+	var (
+		c   = xfer.NewCollector(time.Minute)
+		rpt = makeReport("foo")
+	)
+	for seq := uint64(1); seq <= 5; seq++ {
+		mustAdd(t, c, xfer.Envelope{ProbeID: "probe1", BootID: "a", Sequence: seq, Report: rpt})
+	}
+
+	// After a restart, well within the replay window, the probe begins again
+	// at 1, with a new boot ID.
+	for seq := uint64(1); seq <= 5; seq++ {
+		if want, have := true, mustAdd(t, c, xfer.Envelope{ProbeID: "probe1", BootID: "b", Sequence: seq, Report: rpt}); want != have {
+			t.Errorf("%d: want %v, have %v", seq, want, have)
+		}
+	}
+	if want, have := false, mustAdd(t, c, xfer.Envelope{ProbeID: "probe1", BootID: "b", Sequence: 5, Report: rpt}); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	// A delta relative to a report from before the restart can't be applied.
+	d := report.MakeDelta(rpt, makeReport("bar"))
+	mustAdd(t, c, xfer.Envelope{ProbeID: "probe1", BootID: "c", Sequence: 1, Report: rpt})
+	if _, err := c.AddEnvelope(xfer.Envelope{ProbeID: "probe1", BootID: "d", Sequence: 2, Base: 1, Delta: &d}); err != xfer.ErrResync {
+		t.Errorf("want %v, have %v", xfer.ErrResync, err)
+	}
+
+	probes := c.Probes()
+	if want, have := (xfer.ProbeInfo{ID: "probe1", LastSeen: probes[0].LastSeen, Reports: 11, Duplicates: 1}), probes[0]; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestCollectorProbes(t *testing.T) {
+	var (
+		c   = xfer.NewCollector(time.Minute)
+		rpt = makeReport("foo")
+		ts  = time.Now().Add(-time.Second)
+	)
+	rpt.Window = time.Millisecond
+	mustAdd(t, c, xfer.Envelope{
+		ProbeID:      "probe1",
+		ProbeVersion: "0.7.0",
+		Hostname:     "host1",
+		Sequence:     1,
+		Timestamp:    ts,
+		Size:         1234,
+		Report:       rpt,
+	})
+
+	probes := c.Probes()
+	if want, have := 1, len(probes); want != have {
+		t.Fatalf("want %d, have %d", want, have)
+	}
+	want := xfer.ProbeInfo{
+		ID:           "probe1",
+		Version:      "0.7.0",
+		Hostname:     "host1",
+		LastSeen:     probes[0].LastSeen,
+		LastReport:   ts,
+		LastSequence: 1,
+		ReportSize:   1234,
+		Interval:     time.Millisecond,
+		Reports:      1,
+		Silent:       probes[0].Silent,
+	}
+	if have := probes[0]; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if !c.Probes()[0].Silent {
+		t.Error("want silent probe")
+	}
+}
+
+func TestCollectorForgetsProbes(t *testing.T) {
+	var (
+		clock = time.Now()
+		c     = xfer.NewCollector(time.Minute)
+		rpt   = makeReport("foo")
+	)
+	defer mockNow(&clock)()
+	rpt.Window = time.Second
+	mustAdd(t, c, xfer.Envelope{ProbeID: "gone", Sequence: 1, Report: rpt})
+	mustAdd(t, c, xfer.Envelope{ProbeID: "connected", Sequence: 1, Report: rpt})
+	c.SetConnected("connected", true)
+
+	// Long after the probe went quiet, it's forgotten, base report and all,
+	// unless it's still connected.
+	clock = clock.Add(time.Hour)
+	mustAdd(t, c, xfer.Envelope{ProbeID: "new", Sequence: 1, Report: rpt})
+	var ids []string
+	for _, p := range c.Probes() {
+		ids = append(ids, p.ID)
+	}
+	if want, have := []string{"connected", "new"}, ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	delta := report.MakeDelta(rpt, rpt)
+	if _, err := c.AddEnvelope(xfer.Envelope{ProbeID: "gone", Sequence: 2, Base: 1, Delta: &delta}); err != xfer.ErrResync {
+		t.Errorf("want %v, have %v", xfer.ErrResync, err)
+	}
+}
+
+func TestCollectorDeltas(t *testing.T) {
+	var (
+		c  = xfer.NewCollector(time.Minute)
+		r1 = makeReport("foo")
+		r2 = makeReport("bar")
+		d  = report.MakeDelta(r1, r2)
+	)
+
+	// Without the base report, the delta can't be applied.
+	if _, err := c.AddEnvelope(xfer.Envelope{ProbeID: "probe1", Sequence: 2, Base: 1, Delta: &d}); err != xfer.ErrResync {
+		t.Errorf("want %v, have %v", xfer.ErrResync, err)
+	}
+
+	mustAdd(t, c, xfer.Envelope{ProbeID: "probe1", Sequence: 1, Report: r1})
+	mustAdd(t, c, xfer.Envelope{ProbeID: "probe1", Sequence: 2, Base: 1, Delta: &d})
+	if want, have := merged(r1, r2), c.Report(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// The base is now 2, so a delta relative to 1 needs a resync.
+	if _, err := c.AddEnvelope(xfer.Envelope{ProbeID: "probe1", Sequence: 3, Base: 1, Delta: &d}); err != xfer.ErrResync {
+		t.Errorf("want %v, have %v", xfer.ErrResync, err)
+	}
+}
+
+func mustAdd(t *testing.T, c *xfer.Collector, env xfer.Envelope) bool {
+	added, err := c.AddEnvelope(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return added
+}
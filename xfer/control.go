@@ -0,0 +1,48 @@
+package xfer
+
+// ControlRequest asks a probe to perform an action, e.g. to fetch details of
+// a process. Apps send control requests to probes over their persistent
+// connections, so only probes publishing over websockets can be controlled.
+//
+// A request with a PipeID also opens a pipe between the app and the probe,
+// e.g. to stream a container's logs.
+type ControlRequest struct {
+	ID     uint64            `json:"id"`
+	Action string            `json:"action"`
+	Args   map[string]string `json:"args,omitempty"`
+	PipeID string            `json:"pipe_id,omitempty"`
+
+	// Pipe is the probe's end of the pipe named by PipeID. It's set by the
+	// WebsocketPublisher when the request arrives.
+	Pipe *Pipe `json:"-"`
+}
+
+// ControlResponse is a probe's answer to a ControlRequest with the same ID.
+// Value is the result of the action, and is only meaningful if Error is
+// empty.
+type ControlResponse struct {
+	ID    uint64      `json:"id"`
+	Value interface{} `json:"value,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// ControlHandler performs an action, given its arguments, and yields a
+// result which can be encoded as JSON.
+type ControlHandler func(args map[string]string) (interface{}, error)
+
+// PipeHandler starts an action which streams data over a pipe, e.g.
+// following a container's logs. It returns once the action has started,
+// perhaps with a result, and closes the pipe once the action has finished.
+// If it returns an error, the pipe is closed for it.
+type PipeHandler func(args map[string]string, pipe *Pipe) (interface{}, error)
+
+// WebsocketMessage is a text message sent over a persistent report
+// connection. Exactly one of its fields is set: apps send acks and control
+// requests to probes, probes send control responses to apps, and both send
+// data along pipes.
+type WebsocketMessage struct {
+	Ack      *ReportAck       `json:"ack,omitempty"`
+	Request  *ControlRequest  `json:"control_request,omitempty"`
+	Response *ControlResponse `json:"control_response,omitempty"`
+	Pipe     *PipeMessage     `json:"pipe,omitempty"`
+}
@@ -0,0 +1,182 @@
+package xfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// PipeMessage carries data along a pipe. EOF means the sender has closed its
+// end of the pipe.
+type PipeMessage struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data,omitempty"`
+	EOF  bool   `json:"eof,omitempty"`
+}
+
+// PipeBufferSize is how many bytes a pipe holds for its reader. If data from
+// the other end doesn't fit, the pipe is closed with ErrPipeOverflow, rather
+// than have a slow reader hold up the connection the pipe shares with reports
+// and acks. Data is never dropped from the middle of the stream.
+const PipeBufferSize = 1 << 20
+
+// ErrPipeOverflow is returned by Read once the reader has fallen more than
+// PipeBufferSize bytes behind the other end.
+var ErrPipeOverflow = errors.New("pipe overflowed")
+
+// Pipe is one end of a bidirectional stream of bytes between an app and a
+// probe, e.g. a container's logs, carried over the probe's persistent
+// connection. The app opens a pipe by sending a control request with a pipe
+// ID; the probe's control handler then owns the other end, and must close it
+// when done. Data written to one end can be read from the other.
+type Pipe struct {
+	id      string
+	send    func(PipeMessage) error
+	onClose func()
+
+	mtx          sync.Mutex
+	cond         *sync.Cond
+	buf          bytes.Buffer
+	err          error // returned by Read, once the buffer is drained
+	closed       bool  // by this end
+	remoteClosed bool  // by the other end
+}
+
+// NewPipe returns one end of the pipe with the given ID. Data written to the
+// pipe is sent with send; data from the other end must be given to Deliver.
+// onClose, if not nil, is called once the pipe is closed, so the connection
+// can forget about it.
+func NewPipe(id string, send func(PipeMessage) error, onClose func()) *Pipe {
+	p := &Pipe{id: id, send: send, onClose: onClose}
+	p.cond = sync.NewCond(&p.mtx)
+	return p
+}
+
+// ID returns the pipe's ID.
+func (p *Pipe) ID() string {
+	return p.id
+}
+
+// Read reads data sent from the other end. It returns io.EOF once the other
+// end has closed the pipe, and all its data has been read.
+func (p *Pipe) Read(b []byte) (int, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for p.buf.Len() == 0 && p.err == nil && !p.closed && !p.remoteClosed {
+		p.cond.Wait()
+	}
+	if p.buf.Len() > 0 {
+		return p.buf.Read(b)
+	}
+	if p.err != nil {
+		return 0, p.err
+	}
+	return 0, io.EOF
+}
+
+// Write sends data to the other end. It fails once either end has closed the
+// pipe.
+func (p *Pipe) Write(b []byte) (int, error) {
+	p.mtx.Lock()
+	closed := p.closed || p.remoteClosed
+	p.mtx.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	if err := p.send(PipeMessage{ID: p.id, Data: data}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes this end of the pipe, and tells the other end.
+func (p *Pipe) Close() error {
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return nil
+	}
+	p.closed = true
+	remoteClosed := p.remoteClosed
+	p.cond.Broadcast()
+	p.mtx.Unlock()
+
+	if p.onClose != nil {
+		p.onClose()
+	}
+	if remoteClosed {
+		return nil
+	}
+	return p.send(PipeMessage{ID: p.id, EOF: true})
+}
+
+// Deliver accepts a message from the other end. It never blocks: if the
+// reader has fallen PipeBufferSize bytes behind, the pipe is closed instead.
+func (p *Pipe) Deliver(msg PipeMessage) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.err != nil {
+		return
+	}
+	if p.buf.Len()+len(msg.Data) > PipeBufferSize {
+		p.err = ErrPipeOverflow
+		p.cond.Broadcast()
+		go p.Close() // sending may block, and needs the lock
+		return
+	}
+	p.buf.Write(msg.Data)
+	if msg.EOF {
+		p.remoteClosed = true
+	}
+	p.cond.Broadcast()
+}
+
+// Pipes tracks the open pipes on a connection. The zero value is ready for
+// use.
+type Pipes struct {
+	mtx sync.Mutex
+	m   map[string]*Pipe
+}
+
+// Open returns a new pipe, tracked until it's closed.
+func (ps *Pipes) Open(id string, send func(PipeMessage) error) *Pipe {
+	p := NewPipe(id, send, func() {
+		ps.mtx.Lock()
+		defer ps.mtx.Unlock()
+		delete(ps.m, id)
+	})
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	if ps.m == nil {
+		ps.m = map[string]*Pipe{}
+	}
+	ps.m[id] = p
+	return p
+}
+
+// Deliver passes the message to the pipe it's for, if that's still open.
+func (ps *Pipes) Deliver(msg PipeMessage) {
+	ps.mtx.Lock()
+	p, ok := ps.m[msg.ID]
+	ps.mtx.Unlock()
+	if ok {
+		p.Deliver(msg)
+	}
+}
+
+// CloseAll tells every pipe that the other end is gone, e.g. because the
+// connection has failed.
+func (ps *Pipes) CloseAll() {
+	ps.mtx.Lock()
+	all := make([]*Pipe, 0, len(ps.m))
+	for _, p := range ps.m {
+		all = append(all, p)
+	}
+	ps.mtx.Unlock()
+	for _, p := range all {
+		p.Deliver(PipeMessage{ID: p.id, EOF: true})
+	}
+}
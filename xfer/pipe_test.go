@@ -0,0 +1,103 @@
+package xfer_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/xfer"
+)
+
+func TestPipe(t *testing.T) {
+	// Join two ends, as if over a connection.
+	var a, b *xfer.Pipe
+	a = xfer.NewPipe("pipe", func(msg xfer.PipeMessage) error { b.Deliver(msg); return nil }, nil)
+	b = xfer.NewPipe("pipe", func(msg xfer.PipeMessage) error { a.Deliver(msg); return nil }, nil)
+
+	if _, err := a.Write([]byte("hello ")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := ioutil.ReadAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello world"; want != string(have) {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if _, err := b.Write([]byte("too late")); err != io.ErrClosedPipe {
+		t.Errorf("want %v, have %v", io.ErrClosedPipe, err)
+	}
+
+	// Data sent before the close can still be read.
+	have, err = ioutil.ReadAll(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ping"; want != string(have) {
+		t.Errorf("want %q, have %q", want, have)
+	}
+}
+
+func TestPipeOverflow(t *testing.T) {
+	var (
+		eof   = make(chan struct{})
+		p     = xfer.NewPipe("pipe", func(msg xfer.PipeMessage) error { close(eof); return nil }, nil)
+		chunk = bytes.Repeat([]byte("x"), xfer.PipeBufferSize/4)
+	)
+
+	// Nobody is reading, so the fifth chunk doesn't fit. Rather than lose
+	// it, the pipe fails, once what was buffered has been read, and the
+	// other end is told.
+	for i := 0; i < 6; i++ {
+		p.Deliver(xfer.PipeMessage{ID: "pipe", Data: chunk})
+	}
+	have, err := ioutil.ReadAll(p)
+	if err != xfer.ErrPipeOverflow {
+		t.Errorf("want %v, have %v", xfer.ErrPipeOverflow, err)
+	}
+	if want, have := 4*len(chunk), len(have); want != have {
+		t.Errorf("want %d bytes, have %d", want, have)
+	}
+	select {
+	case <-eof:
+	case <-time.After(time.Second):
+		t.Error("other end not closed")
+	}
+	if _, err := p.Write([]byte("too late")); err != io.ErrClosedPipe {
+		t.Errorf("want %v, have %v", io.ErrClosedPipe, err)
+	}
+}
+
+func TestPipesCloseAll(t *testing.T) {
+	var (
+		ps     xfer.Pipes
+		closed bool
+		p      = ps.Open("pipe", func(msg xfer.PipeMessage) error { closed = closed || msg.EOF; return nil })
+	)
+	ps.Deliver(xfer.PipeMessage{ID: "pipe", Data: []byte("foo")})
+	ps.CloseAll()
+
+	have, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "foo"; want != string(have) {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	p.Close()
+	if closed {
+		t.Error("didn't expect EOF to be sent once the other end had gone")
+	}
+}
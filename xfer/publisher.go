@@ -3,13 +3,18 @@ package xfer
 import (
 	"bytes"
 	"compress/gzip"
-	"encoding/gob"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/weaveworks/scope/report"
 )
@@ -19,11 +24,22 @@ type Publisher interface {
 	Publish(report.Report) error
 }
 
+// EnvelopePublisher is a Publisher which can also send a report with
+// metadata, such as a sequence number, supplied by the caller. The
+// MultiPublisher uses it, when available, so that every target receives the
+// same sequence number for the same report.
+type EnvelopePublisher interface {
+	Publisher
+	PublishEnvelope(Envelope) error
+}
+
 // HTTPPublisher publishes reports by POST to a fixed endpoint.
 type HTTPPublisher struct {
-	url   string
-	token string
-	id    string
+	url    string
+	token  string
+	id     string
+	codec  Codec
+	client *http.Client
 }
 
 // ScopeProbeIDHeader is the header we use to carry the probe's unique ID. The
@@ -32,10 +48,23 @@ type HTTPPublisher struct {
 // configured to publish to multiple receivers that resolve to the same app.
 const ScopeProbeIDHeader = "X-Scope-Probe-ID"
 
-// NewHTTPPublisher returns an HTTPPublisher ready for use.
-func NewHTTPPublisher(target, token, id string) (*HTTPPublisher, error) {
+// HTTPPublishTimeout is how long an HTTPPublisher waits for a publish to
+// complete.
+const HTTPPublishTimeout = 15 * time.Second
+
+// NewHTTPPublisher returns an HTTPPublisher ready for use. If tlsConfig is
+// non-nil, targets without a scheme are published to over HTTPS, using it.
+func NewHTTPPublisher(target, token, id string, tlsConfig *tls.Config) (*HTTPPublisher, error) {
+	client := &http.Client{Timeout: HTTPPublishTimeout}
+	if tlsConfig != nil {
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
 	if !strings.HasPrefix(target, "http") {
-		target = "http://" + target
+		if tlsConfig != nil {
+			target = "https://" + target
+		} else {
+			target = "http://" + target
+		}
 	}
 	u, err := url.Parse(target)
 	if err != nil {
@@ -45,18 +74,30 @@ func NewHTTPPublisher(target, token, id string) (*HTTPPublisher, error) {
 		u.Path = "/api/report"
 	}
 	return &HTTPPublisher{
-		url:   u.String(),
-		token: token,
-		id:    id,
+		url:    u.String(),
+		token:  token,
+		id:     id,
+		codec:  BinaryCodec,
+		client: client,
 	}, nil
 }
 
 // Publish publishes the report to the URL.
 func (p HTTPPublisher) Publish(rpt report.Report) error {
+	return p.PublishEnvelope(Envelope{Report: rpt})
+}
+
+// PublishEnvelope publishes the envelope to the URL. The probe ID is always
+// that of the publisher; the timestamp defaults to now.
+func (p HTTPPublisher) PublishEnvelope(env Envelope) error {
 	gzbuf := bytes.Buffer{}
 	gzwriter := gzip.NewWriter(&gzbuf)
 
-	if err := gob.NewEncoder(gzwriter).Encode(rpt); err != nil {
+	env.ProbeID = p.id
+	if env.Timestamp.IsZero() {
+		env.Timestamp = time.Now()
+	}
+	if err := p.codec.Encode(gzwriter, env); err != nil {
 		return err
 	}
 	gzwriter.Close() // otherwise the content won't get flushed to the output stream
@@ -69,18 +110,22 @@ func (p HTTPPublisher) Publish(rpt report.Report) error {
 	req.Header.Set("Authorization", AuthorizationHeader(p.token))
 	req.Header.Set(ScopeProbeIDHeader, p.id)
 	req.Header.Set("Content-Encoding", "gzip")
-	// req.Header.Set("Content-Type", "application/binary") // TODO: we should use http.DetectContentType(..) on the gob'ed
+	req.Header.Set("Content-Type", p.codec.ContentType())
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		return ErrResync
+	default:
 		return fmt.Errorf(resp.Status)
 	}
-	return nil
 }
 
 // AuthorizationHeader returns a value suitable for an HTTP Authorization
@@ -89,29 +134,104 @@ func AuthorizationHeader(token string) string {
 	return fmt.Sprintf("Scope-Probe token=%s", token)
 }
 
-// MultiPublisher implements Publisher over a set of publishers.
+// ParseAuthorizationHeader returns the token from an HTTP Authorization
+// header produced by AuthorizationHeader.
+func ParseAuthorizationHeader(header string) (string, bool) {
+	const prefix = "Scope-Probe token="
+	if !strings.HasPrefix(header, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(header, prefix), true
+}
+
+// MultiPublisher implements Publisher over a set of publishers. Each target
+// has its own queue and retry state, so a failing target doesn't hold up the
+// others.
 type MultiPublisher struct {
-	mtx     sync.RWMutex
-	factory func(string) (Publisher, error)
-	m       map[string]Publisher
+	mtx      sync.Mutex
+	factory  func(string) (Publisher, error)
+	config   QueueConfig
+	m        map[string]*publishTarget
+	bootID   string
+	sequence uint64
+	flushes  sync.WaitGroup
 }
 
 // NewMultiPublisher returns a new MultiPublisher ready for use. The factory
 // should be e.g. NewHTTPPublisher, except you need to curry it over the
 // probe token.
 func NewMultiPublisher(factory func(string) (Publisher, error)) *MultiPublisher {
+	return NewBufferedMultiPublisher(factory, DefaultQueueConfig)
+}
+
+// NewBufferedMultiPublisher returns a new MultiPublisher ready for use, which
+// queues reports per target as described by the config.
+func NewBufferedMultiPublisher(factory func(string) (Publisher, error), config QueueConfig) *MultiPublisher {
 	return &MultiPublisher{
 		factory: factory,
-		m:       map[string]Publisher{},
+		config:  config,
+		m:       map[string]*publishTarget{},
+		bootID:  newBootID(),
+	}
+}
+
+// newBootID returns a random ID for this run of the process, which tells
+// collectors that the sequence numbers have started again.
+func newBootID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
 	}
+	return hex.EncodeToString(b[:])
 }
 
 // Add allows additional targets to be added dynamically. It will dedupe
-// identical targets. TODO we have no good mechanism to remove.
+// identical targets.
 func (p *MultiPublisher) Add(target string) {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
+	p.add(target)
+}
+
+// Remove removes a target, discarding any reports queued for it.
+func (p *MultiPublisher) Remove(target string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.remove(target)
+}
+
+// Set reconciles the targets with the given set, adding new targets and
+// removing those not in the set.
+func (p *MultiPublisher) Set(targets []string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	want := map[string]struct{}{}
+	for _, target := range targets {
+		want[target] = struct{}{}
+		p.add(target)
+	}
+	for target := range p.m {
+		if _, ok := want[target]; !ok {
+			p.remove(target)
+		}
+	}
+}
+
+// Targets returns the status of every target, ordered by target.
+func (p *MultiPublisher) Targets() []TargetStatus {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	statuses := make([]TargetStatus, 0, len(p.m))
+	for _, target := range p.m {
+		statuses = append(statuses, target.status())
+	}
+	sort.Sort(targetStatusesByTarget(statuses))
+	return statuses
+}
 
+func (p *MultiPublisher) add(target string) {
 	if _, ok := p.m[target]; ok {
 		return
 	}
@@ -122,19 +242,43 @@ func (p *MultiPublisher) Add(target string) {
 		return
 	}
 
-	p.m[target] = publisher
+	p.m[target] = newPublishTarget(target, publisher, p.config)
 }
 
-// Publish implements Publisher by emitting the report to all publishers.
+func (p *MultiPublisher) remove(target string) {
+	if t, ok := p.m[target]; ok {
+		t.close()
+		delete(p.m, target)
+	}
+}
+
+// Publish implements Publisher by queueing the report for all publishers.
+// See PublishEnvelope.
 func (p *MultiPublisher) Publish(rpt report.Report) error {
-	p.mtx.RLock()
-	defer p.mtx.RUnlock()
+	return p.PublishEnvelope(Envelope{Report: rpt})
+}
+
+// PublishEnvelope queues the envelope for all publishers, and has each of
+// them send what it has queued in the background, unless it's backing off
+// after a failure. It returns the errors from each publisher's last attempt.
+// Each envelope is given the publisher's boot ID and the next sequence
+// number, so that collectors receiving it from several targets can
+// deduplicate it, and a timestamp of now.
+func (p *MultiPublisher) PublishEnvelope(env Envelope) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.sequence++
+	env.BootID, env.Sequence = p.bootID, p.sequence
+	env.Timestamp = Now()
 
 	var errs []string
-	for _, publisher := range p.m {
-		if err := publisher.Publish(rpt); err != nil {
+	for _, target := range p.m {
+		target.enqueue(env)
+		if err := target.err(); err != nil {
 			errs = append(errs, err.Error())
 		}
+		target.kick(&p.flushes)
 	}
 
 	if len(errs) > 0 {
@@ -142,3 +286,15 @@ func (p *MultiPublisher) Publish(rpt report.Report) error {
 	}
 	return nil
 }
+
+// Wait blocks until the targets have finished sending what they had queued,
+// or have given up for now.
+func (p *MultiPublisher) Wait() {
+	p.flushes.Wait()
+}
+
+type targetStatusesByTarget []TargetStatus
+
+func (s targetStatusesByTarget) Len() int           { return len(s) }
+func (s targetStatusesByTarget) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s targetStatusesByTarget) Less(i, j int) bool { return s[i].Target < s[j].Target }
@@ -1,12 +1,17 @@
 package xfer_test
 
 import (
+	"bytes"
 	"compress/gzip"
-	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -32,7 +37,9 @@ func TestHTTPPublisher(t *testing.T) {
 		if want, have := id, r.Header.Get(xfer.ScopeProbeIDHeader); want != have {
 			t.Errorf("want %q, have %q", want, have)
 		}
-		var have report.Report
+		if want, have := xfer.BinaryContentType, r.Header.Get("Content-Type"); want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
 
 		reader := r.Body
 		var err error
@@ -45,11 +52,15 @@ func TestHTTPPublisher(t *testing.T) {
 			defer reader.Close()
 		}
 
-		if err := gob.NewDecoder(reader).Decode(&have); err != nil {
+		env, err := xfer.DecodeEnvelope(r.Header.Get("Content-Type"), reader)
+		if err != nil {
 			t.Error(err)
 			return
 		}
-		if want := rpt; !reflect.DeepEqual(want, have) {
+		if want, have := id, env.ProbeID; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+		if want, have := rpt, env.Report; !reflect.DeepEqual(want, have) {
 			t.Error(test.Diff(want, have))
 			return
 		}
@@ -60,7 +71,7 @@ func TestHTTPPublisher(t *testing.T) {
 	s := httptest.NewServer(handlers.CompressHandler(handler))
 	defer s.Close()
 
-	p, err := xfer.NewHTTPPublisher(s.URL, token, id)
+	p, err := xfer.NewHTTPPublisher(s.URL, token, id, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,6 +86,36 @@ func TestHTTPPublisher(t *testing.T) {
 	}
 }
 
+func TestHTTPPublisherIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	done := make(chan struct{})
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		close(done)
+	}))
+	s.Listener = listener
+	s.Start()
+	defer s.Close()
+
+	// Targets are given as by the resolver, without a scheme.
+	p, err := xfer.NewHTTPPublisher(listener.Addr().String(), "token", "id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(report.MakeReport()); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	}
+}
+
 func TestMultiPublisher(t *testing.T) {
 	var (
 		p              = &mockPublisher{}
@@ -86,7 +127,8 @@ func TestMultiPublisher(t *testing.T) {
 	if err := multiPublisher.Publish(report.MakeReport()); err != nil {
 		t.Error(err)
 	}
-	if want, have := 1, p.count; want != have {
+	multiPublisher.Wait()
+	if want, have := 1, p.published(); want != have {
 		t.Errorf("want %d, have %d", want, have)
 	}
 
@@ -94,11 +136,374 @@ func TestMultiPublisher(t *testing.T) {
 	if err := multiPublisher.Publish(report.MakeReport()); err != nil {
 		t.Error(err)
 	}
-	if want, have := 3, p.count; want != have {
+	multiPublisher.Wait()
+	if want, have := 3, p.published(); want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestMultiPublisherQueue(t *testing.T) {
+	var (
+		p              = &recordingPublisher{fail: true}
+		factory        = func(string) (xfer.Publisher, error) { return p, nil }
+		multiPublisher = xfer.NewBufferedMultiPublisher(factory, xfer.QueueConfig{MaxReports: 2})
+	)
+	multiPublisher.Add("first")
+
+	for _, id := range []string{"a", "b", "c"} {
+		multiPublisher.Publish(makeReport(id))
+		multiPublisher.Wait()
+	}
+	if multiPublisher.Targets()[0].Healthy {
+		t.Error("want unhealthy")
+	}
+
+	// a and b were dropped to make room; c is retried before d. The error
+	// from the last attempt is returned by the next publish.
+	p.fail = false
+	if err := multiPublisher.Publish(makeReport("d")); err == nil {
+		t.Error("expected error")
+	}
+	multiPublisher.Wait()
+	if want, have := []string{"c", "d"}, p.ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if err := multiPublisher.Publish(makeReport("e")); err != nil {
+		t.Error(err)
+	}
+	multiPublisher.Wait()
+}
+
+func TestMultiPublisherBatch(t *testing.T) {
+	var (
+		p              = &recordingPublisher{fail: true}
+		factory        = func(string) (xfer.Publisher, error) { return p, nil }
+		multiPublisher = xfer.NewBufferedMultiPublisher(factory, xfer.QueueConfig{MaxReports: 10, MaxBatch: 2})
+	)
+	multiPublisher.Add("first")
+
+	for _, id := range []string{"a", "b", "c"} {
+		multiPublisher.Publish(makeReport(id))
+		multiPublisher.Wait()
+	}
+
+	// The backlog is sent a couple of reports at a time.
+	p.fail = false
+	multiPublisher.Publish(makeReport("d"))
+	multiPublisher.Wait()
+	if want, have := []string{"a", "b"}, p.ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	multiPublisher.Publish(makeReport("e"))
+	multiPublisher.Wait()
+	if want, have := []string{"a", "b", "c", "d"}, p.ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestMultiPublisherSlowTarget(t *testing.T) {
+	var (
+		slow    = &blockingPublisher{release: make(chan struct{})}
+		fast    = chanPublisher(make(chan string, 10))
+		factory = func(target string) (xfer.Publisher, error) {
+			if target == "slow" {
+				return slow, nil
+			}
+			return fast, nil
+		}
+		multiPublisher = xfer.NewBufferedMultiPublisher(factory, xfer.QueueConfig{MaxReports: 10})
+	)
+	multiPublisher.Set([]string{"slow", "fast"})
+	defer func() {
+		close(slow.release)
+		multiPublisher.Wait()
+	}()
+
+	// A target which never answers holds up neither publishing, nor the
+	// other targets.
+	done := make(chan struct{})
+	go func() {
+		multiPublisher.Publish(makeReport("a"))
+		multiPublisher.Publish(makeReport("b"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked")
+	}
+	for _, want := range []string{"a", "b"} {
+		select {
+		case have := <-fast:
+			if want != have {
+				t.Errorf("want %q, have %q", want, have)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %q", want)
+		}
+	}
+}
+
+// blockingPublisher doesn't return until released.
+type blockingPublisher struct{ release chan struct{} }
+
+func (p *blockingPublisher) Publish(report.Report) error { <-p.release; return nil }
+
+// chanPublisher sends the IDs of the (single-node) reports it publishes down
+// the channel.
+type chanPublisher chan string
+
+func (p chanPublisher) Publish(rpt report.Report) error {
+	for id := range rpt.Endpoint.Nodes {
+		p <- id
+	}
+	return nil
+}
+
+func TestMultiPublisherBackoff(t *testing.T) {
+	var (
+		p              = &recordingPublisher{fail: true}
+		factory        = func(string) (xfer.Publisher, error) { return p, nil }
+		multiPublisher = xfer.NewBufferedMultiPublisher(factory, xfer.QueueConfig{
+			MaxReports: 10,
+			MinBackoff: time.Hour,
+			MaxBackoff: time.Hour,
+		})
+	)
+	multiPublisher.Add("first")
+
+	multiPublisher.Publish(makeReport("a"))
+	multiPublisher.Wait()
+	p.fail = false
+	multiPublisher.Publish(makeReport("b"))
+	multiPublisher.Wait()
+	if want, have := 1, p.attempts; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+}
+
+func TestMultiPublisherSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		p       = &recordingPublisher{fail: true}
+		factory = func(string) (xfer.Publisher, error) { return p, nil }
+		config  = xfer.QueueConfig{MaxReports: 1, SpillDir: dir, MaxSpilled: 2}
+	)
+	multiPublisher := xfer.NewBufferedMultiPublisher(factory, config)
+	multiPublisher.Add("localhost:4040")
+	for _, id := range []string{"a", "b", "c", "d"} {
+		multiPublisher.Publish(makeReport(id))
+		multiPublisher.Wait()
+	}
+
+	// Spilled reports survive a restart; those in memory don't.
+	p.fail = false
+	multiPublisher = xfer.NewBufferedMultiPublisher(factory, config)
+	multiPublisher.Add("localhost:4040")
+	if err := multiPublisher.Publish(makeReport("e")); err != nil {
+		t.Error(err)
+	}
+	multiPublisher.Wait()
+	if want, have := []string{"b", "c", "e"}, p.ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+type mockPublisher struct {
+	mtx   sync.Mutex
+	count int
+}
+
+func (p *mockPublisher) Publish(report.Report) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.count++
+	return nil
+}
+
+func (p *mockPublisher) published() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.count
+}
+
+// recordingPublisher records the IDs of the (single-node) reports it
+// successfully publishes.
+type recordingPublisher struct {
+	fail     bool
+	attempts int
+	ids      []string
+}
+
+func (p *recordingPublisher) Publish(rpt report.Report) error {
+	p.attempts++
+	if p.fail {
+		return fmt.Errorf("unavailable")
+	}
+	for id := range rpt.Endpoint.Nodes {
+		p.ids = append(p.ids, id)
+	}
+	return nil
+}
+
+func TestMultiPublisherSet(t *testing.T) {
+	var (
+		publishers = map[string]*recordingPublisher{}
+		factory    = func(target string) (xfer.Publisher, error) {
+			p := &recordingPublisher{}
+			publishers[target] = p
+			return p, nil
+		}
+		multiPublisher = xfer.NewMultiPublisher(factory)
+	)
+
+	multiPublisher.Set([]string{"first", "second"})
+	publishers["second"].fail = true
+	multiPublisher.Publish(makeReport("a"))
+	multiPublisher.Wait()
+
+	statuses := multiPublisher.Targets()
+	if want, have := 2, len(statuses); want != have {
+		t.Fatalf("want %d, have %d", want, have)
+	}
+	if want, have := "first", statuses[0].Target; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if !statuses[0].Healthy || statuses[0].LastSuccess.IsZero() {
+		t.Errorf("first: want healthy, have %+v", statuses[0])
+	}
+	if statuses[1].Healthy || statuses[1].LastError != "unavailable" || statuses[1].Queued != 1 {
+		t.Errorf("second: want unhealthy, have %+v", statuses[1])
+	}
+
+	multiPublisher.Set([]string{"second", "third"})
+	multiPublisher.Publish(makeReport("b"))
+	multiPublisher.Wait()
+	if want, have := []string{"a"}, publishers["first"].ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if want, have := []string{"b"}, publishers["third"].ids; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	multiPublisher.Remove("second")
+	if want, have := 1, len(multiPublisher.Targets()); want != have {
 		t.Errorf("want %d, have %d", want, have)
 	}
 }
 
-type mockPublisher struct{ count int }
+func TestMultiPublisherSequence(t *testing.T) {
+	var (
+		publishers = map[string]*envelopePublisher{}
+		factory    = func(target string) (xfer.Publisher, error) {
+			p := &envelopePublisher{}
+			publishers[target] = p
+			return p, nil
+		}
+		multiPublisher = xfer.NewMultiPublisher(factory)
+	)
+	multiPublisher.Set([]string{"first", "second"})
+	multiPublisher.Publish(makeReport("a"))
+	multiPublisher.Wait()
+	multiPublisher.Publish(makeReport("b"))
+	multiPublisher.Wait()
+
+	for target, p := range publishers {
+		if want, have := []uint64{1, 2}, p.sequences; !reflect.DeepEqual(want, have) {
+			t.Errorf("%s: %s", target, test.Diff(want, have))
+		}
+		if len(p.bootIDs) != 2 || p.bootIDs[0] == "" || p.bootIDs[0] != p.bootIDs[1] {
+			t.Errorf("%s: want a single boot ID, have %v", target, p.bootIDs)
+		}
+	}
+
+	// Another run of the probe has another boot ID.
+	restarted := xfer.NewMultiPublisher(factory)
+	restarted.Add("third")
+	restarted.Publish(makeReport("c"))
+	restarted.Wait()
+	if publishers["third"].bootIDs[0] == publishers["first"].bootIDs[0] {
+		t.Errorf("want a new boot ID, have %v", publishers["third"].bootIDs)
+	}
+}
+
+type envelopePublisher struct {
+	sequences []uint64
+	bootIDs   []string
+}
+
+func (p *envelopePublisher) Publish(report.Report) error { return fmt.Errorf("not implemented") }
+
+func (p *envelopePublisher) PublishEnvelope(env xfer.Envelope) error {
+	p.sequences = append(p.sequences, env.Sequence)
+	p.bootIDs = append(p.bootIDs, env.BootID)
+	return nil
+}
 
-func (p *mockPublisher) Publish(report.Report) error { p.count++; return nil }
+func TestMultiPublisherDeltas(t *testing.T) {
+	var (
+		p              = &collectorPublisher{c: xfer.NewCollector(time.Minute)}
+		factory        = func(string) (xfer.Publisher, error) { return p, nil }
+		multiPublisher = xfer.NewBufferedMultiPublisher(factory, xfer.QueueConfig{MaxReports: 1, SnapshotEvery: 3})
+		rpts           []report.Report
+	)
+	multiPublisher.Add("first")
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		rpt := makeReport(id)
+		rpts = append(rpts, rpt)
+		if err := multiPublisher.Publish(rpt); err != nil {
+			t.Fatal(err)
+		}
+		multiPublisher.Wait()
+	}
+	if want, have := []bool{false, true, true, false}, p.deltas; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if want, have := merged(rpts...), p.c.Report(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// The app restarts, and loses the base report; the delta is rejected,
+	// and the report resent in full.
+	p.c, p.deltas = xfer.NewCollector(time.Minute), nil
+	if err := multiPublisher.Publish(makeReport("e")); err != nil {
+		t.Fatal(err)
+	}
+	multiPublisher.Wait()
+	if want, have := []bool{true, false}, p.deltas; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+	if want, have := makeReport("e"), p.c.Report(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+// collectorPublisher publishes envelopes straight to a collector, via the
+// JSON codec, and records whether each was a delta.
+type collectorPublisher struct {
+	c      *xfer.Collector
+	deltas []bool
+}
+
+func (p *collectorPublisher) Publish(report.Report) error { return fmt.Errorf("not implemented") }
+
+func (p *collectorPublisher) PublishEnvelope(env xfer.Envelope) error {
+	p.deltas = append(p.deltas, env.Delta != nil)
+	env.ProbeID = "probe"
+	var buf bytes.Buffer
+	if err := xfer.JSONCodec.Encode(&buf, env); err != nil {
+		return err
+	}
+	decoded, err := xfer.JSONCodec.Decode(&buf)
+	if err != nil {
+		return err
+	}
+	_, err = p.c.AddEnvelope(decoded)
+	return err
+}
@@ -0,0 +1,439 @@
+package xfer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// QueueConfig configures the per-target queues of a MultiPublisher. Reports
+// that can't be published to a target are queued, and retried with
+// exponential backoff. When a queue is full, the oldest report is spilled to
+// disk if SpillDir is set, or dropped otherwise.
+//
+// At most MaxBatch reports are sent to a target per publish, so a target
+// which has been down for a while catches up gradually.
+//
+// If SnapshotEvery is greater than 1, and the target's publisher is an
+// EnvelopePublisher, only every SnapshotEvery'th report is sent in full.
+// The others are sent as deltas, relative to the last report the target
+// accepted.
+type QueueConfig struct {
+	MaxReports    int           // reports held in memory, per target
+	SpillDir      string        // if non-empty, overflowing reports are written here
+	MaxSpilled    int           // reports held on disk, per target
+	MinBackoff    time.Duration // delay before the first retry
+	MaxBackoff    time.Duration // upper bound on the delay between retries
+	SnapshotEvery int           // full reports are sent this often; 0 or 1 for always
+	MaxBatch      int           // reports sent per target per publish; 0 for defaultMaxBatch
+}
+
+// DefaultQueueConfig holds a single report per target, which is retried at
+// every publish until a newer report replaces it.
+var DefaultQueueConfig = QueueConfig{MaxReports: 1}
+
+// defaultMaxBatch is the number of reports sent per target per publish, if
+// the config doesn't say.
+const defaultMaxBatch = 10
+
+const spillSuffix = ".rpt"
+
+var (
+	// PublishQueueLength is an exported prometheus metric.
+	PublishQueueLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "scope",
+			Subsystem: "probe",
+			Name:      "publish_queue_length",
+			Help:      "Number of reports queued for publishing, in memory and on disk.",
+		},
+		[]string{"target"},
+	)
+
+	// PublishDropped is an exported prometheus metric.
+	PublishDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "scope",
+			Subsystem: "probe",
+			Name:      "publish_dropped_reports",
+			Help:      "Number of reports dropped because a publish queue was full.",
+		},
+		[]string{"target"},
+	)
+)
+
+// publishTarget holds a publisher, and the reports waiting to be sent to it.
+// Spilled reports are always older than those in memory, so they're sent
+// first. Reports are sent by a goroutine of the target's own, so a slow
+// target doesn't hold up the others.
+type publishTarget struct {
+	name      string
+	publisher Publisher
+	config    QueueConfig
+
+	mtx         sync.Mutex // guards the fields below, except base and deltas
+	flushing    bool       // a goroutine is flushing the target
+	again       bool       // and should flush again when it's done
+	closed      bool
+	queue       []Envelope
+	spill       *spillQueue
+	failures    int
+	retryAt     time.Time
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastError   error
+
+	// base is the last full report the target accepted, and deltas is how
+	// many deltas have been sent since the last full report. They're only
+	// used by the flushing goroutine.
+	base   *Envelope
+	deltas int
+}
+
+// TargetStatus describes the health of a MultiPublisher target.
+type TargetStatus struct {
+	Target      string    `json:"target"`
+	Healthy     bool      `json:"healthy"`
+	Queued      int       `json:"queued"`
+	Failures    int       `json:"failures"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	RetryAt     time.Time `json:"retry_at"`
+}
+
+func newPublishTarget(name string, publisher Publisher, config QueueConfig) *publishTarget {
+	if config.MaxReports < 1 {
+		config.MaxReports = 1
+	}
+	if config.MaxBatch < 1 {
+		config.MaxBatch = defaultMaxBatch
+	}
+	t := &publishTarget{
+		name:      name,
+		publisher: publisher,
+		config:    config,
+	}
+	if config.SpillDir != "" {
+		spill, err := newSpillQueue(filepath.Join(config.SpillDir, sanitizeTarget(name)), config.MaxSpilled)
+		if err != nil {
+			log.Printf("publish queue: %s: not spilling to disk: %v", name, err)
+		} else {
+			t.spill = spill
+		}
+	}
+	t.updateMetrics()
+	return t
+}
+
+// enqueue adds the report to the back of the queue, making room if necessary.
+func (t *publishTarget) enqueue(env Envelope) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.closed {
+		return
+	}
+	t.queue = append(t.queue, env)
+	for len(t.queue) > t.config.MaxReports {
+		oldest := t.queue[0]
+		t.queue = t.queue[1:]
+		if t.spill == nil {
+			PublishDropped.WithLabelValues(t.name).Add(1)
+			continue
+		}
+		dropped, err := t.spill.push(oldest)
+		if err != nil {
+			log.Printf("publish queue: %s: %v", t.name, err)
+			dropped++
+		}
+		PublishDropped.WithLabelValues(t.name).Add(float64(dropped))
+	}
+	t.updateMetrics()
+}
+
+// kick starts a goroutine, tracked by wg, to flush the target. If one is
+// already running, it flushes again when it's done.
+func (t *publishTarget) kick(wg *sync.WaitGroup) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.flushing {
+		t.again = true
+		return
+	}
+	t.flushing = true
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			t.flush(Now())
+			t.mtx.Lock()
+			if !t.again || t.closed {
+				t.flushing, t.again = false, false
+				t.mtx.Unlock()
+				return
+			}
+			t.again = false
+			t.mtx.Unlock()
+		}
+	}()
+}
+
+// flush sends queued reports in order, until MaxBatch have been sent, the
+// queue is empty, or a publish fails. After a failure, it does nothing until
+// the backoff has elapsed. The lock isn't held while publishing, so reports
+// can be queued meanwhile.
+func (t *publishTarget) flush(now time.Time) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if now.Before(t.retryAt) {
+		return nil
+	}
+	defer t.updateMetrics()
+	for sent := 0; sent < t.config.MaxBatch && !t.closed; {
+		var (
+			env     Envelope
+			spilled bool
+			head    uint64
+		)
+		switch {
+		case t.spill != nil && t.spill.len() > 0:
+			var err error
+			if env, err = t.spill.peek(); err != nil {
+				log.Printf("publish queue: %s: discarding spilled report: %v", t.name, err)
+				t.spill.pop()
+				continue
+			}
+			spilled, head = true, t.spill.head
+		case len(t.queue) > 0:
+			env = t.queue[0]
+		default:
+			return nil
+		}
+
+		t.lastAttempt = now
+		t.mtx.Unlock()
+		err := t.publish(env)
+		t.mtx.Lock()
+		if err == ErrResync && t.base != nil {
+			t.base = nil // send it again, in full
+			continue
+		}
+		if err != nil {
+			t.failures++
+			t.retryAt = now.Add(t.backoff())
+			t.lastError = err
+			return err
+		}
+		t.failures = 0
+		t.retryAt = time.Time{}
+		t.lastSuccess = now
+		t.lastError = nil
+		sent++
+
+		// Meanwhile, enqueue may have spilled or dropped the report.
+		if spilled {
+			if t.spill != nil && t.spill.head == head {
+				t.spill.pop()
+			}
+		} else if len(t.queue) > 0 && t.queue[0].Sequence == env.Sequence {
+			t.queue = t.queue[1:]
+		}
+	}
+	return nil
+}
+
+// publish sends the envelope, as a delta if possible.
+func (t *publishTarget) publish(env Envelope) error {
+	p, ok := t.publisher.(EnvelopePublisher)
+	if !ok {
+		return t.publisher.Publish(env.Report)
+	}
+
+	send, delta := env, false
+	if t.base != nil && t.deltas+1 < t.config.SnapshotEvery {
+		d := report.MakeDelta(t.base.Report, env.Report)
+		send.Report = report.MakeReport()
+		send.Base, send.Delta = t.base.Sequence, &d
+		delta = true
+	}
+	if err := p.PublishEnvelope(send); err != nil {
+		return err
+	}
+
+	t.base = &env
+	if delta {
+		t.deltas++
+	} else {
+		t.deltas = 0
+	}
+	return nil
+}
+
+func (t *publishTarget) backoff() time.Duration {
+	backoff := t.config.MinBackoff
+	for i := 1; i < t.failures && i < 32; i++ {
+		if t.config.MaxBackoff > 0 && backoff >= t.config.MaxBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	if t.config.MaxBackoff > 0 && backoff > t.config.MaxBackoff {
+		backoff = t.config.MaxBackoff
+	}
+	return backoff
+}
+
+func (t *publishTarget) len() int {
+	n := len(t.queue)
+	if t.spill != nil {
+		n += t.spill.len()
+	}
+	return n
+}
+
+// err returns the error from the last attempt to publish, if it failed.
+func (t *publishTarget) err() error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.lastError
+}
+
+func (t *publishTarget) status() TargetStatus {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	status := TargetStatus{
+		Target:      t.name,
+		Healthy:     t.failures == 0,
+		Queued:      t.len(),
+		Failures:    t.failures,
+		LastAttempt: t.lastAttempt,
+		LastSuccess: t.lastSuccess,
+		RetryAt:     t.retryAt,
+	}
+	if t.lastError != nil {
+		status.LastError = t.lastError.Error()
+	}
+	return status
+}
+
+// close discards everything queued for the target.
+func (t *publishTarget) close() {
+	if s, ok := t.publisher.(interface {
+		Stop()
+	}); ok {
+		s.Stop()
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.closed = true
+	t.queue = nil
+	if t.spill != nil {
+		if err := os.RemoveAll(t.spill.dir); err != nil {
+			log.Printf("publish queue: %s: %v", t.name, err)
+		}
+		t.spill = nil
+	}
+	t.updateMetrics()
+}
+
+func (t *publishTarget) updateMetrics() {
+	PublishQueueLength.WithLabelValues(t.name).Set(float64(t.len()))
+}
+
+// spillQueue is a FIFO of reports on disk, one file per report, named by
+// sequence number. It survives probe restarts.
+type spillQueue struct {
+	dir        string
+	max        int
+	head, tail uint64 // files [head, tail) exist
+}
+
+func newSpillQueue(dir string, max int) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &spillQueue{dir: dir, max: max}
+	first := true
+	for _, info := range infos {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(info.Name(), spillSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(info.Name(), spillSuffix) {
+			continue
+		}
+		if first || seq < q.head {
+			q.head = seq
+		}
+		if first || seq >= q.tail {
+			q.tail = seq + 1
+		}
+		first = false
+	}
+	return q, nil
+}
+
+func (q *spillQueue) len() int {
+	return int(q.tail - q.head)
+}
+
+func (q *spillQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, spillSuffix))
+}
+
+// push writes the report to the back of the queue, and returns the number of
+// reports dropped from the front to stay within the maximum.
+func (q *spillQueue) push(env Envelope) (int, error) {
+	buf, err := encodeRecord(env)
+	if err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(q.path(q.tail), buf, 0644); err != nil {
+		return 0, err
+	}
+	q.tail++
+	dropped := 0
+	for q.len() > q.max {
+		q.pop()
+		dropped++
+	}
+	return dropped, nil
+}
+
+func (q *spillQueue) peek() (Envelope, error) {
+	buf, err := ioutil.ReadFile(q.path(q.head))
+	if err != nil {
+		return Envelope{}, err
+	}
+	return decodeRecord(bytes.NewReader(buf))
+}
+
+func (q *spillQueue) pop() {
+	if err := os.Remove(q.path(q.head)); err != nil && !os.IsNotExist(err) {
+		log.Printf("publish queue: %v", err)
+	}
+	q.head++
+}
+
+// sanitizeTarget makes a target usable as a directory name.
+func sanitizeTarget(target string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, target)
+}
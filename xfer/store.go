@@ -0,0 +1,341 @@
+package xfer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// Store is something that persists timestamped reports, and can yield a
+// single merged report over an arbitrary range of time.
+type Store interface {
+	Put(time.Time, report.Report) error
+	Range(from, to time.Time) (report.Report, error)
+	Close() error
+}
+
+// RangeReporter is something that can produce merged reports for arbitrary
+// ranges of (past) time. It's implemented by the Collector.
+type RangeReporter interface {
+	ReportRange(from, to time.Time) (report.Report, error)
+}
+
+const (
+	segmentSuffix = ".seg"
+	headerLen     = 8 + 4 // timestamp (UnixNano) + payload length
+)
+
+// DiskStore is a Store which keeps reports in append-only segment files in a
+// directory. Each segment is written to for a bounded period of time, by the
+// app's clock. Whole segments are deleted when they fall out of the retention
+// period, or when the store grows beyond its maximum size. Segments are
+// rotated and pruned by the app's clock, not by the timestamps of the reports
+// in them, so a report with a wild timestamp can't expire the others.
+//
+// Each record in a segment is a fixed-size header, holding the timestamp
+// and the payload length, followed by the gzipped report, in an envelope
+// encoded with the BinaryCodec.
+type DiskStore struct {
+	mtx             sync.RWMutex
+	dir             string
+	segmentDuration time.Duration
+	retention       time.Duration
+	maxBytes        int64
+	segments        []*segment // ordered by creation
+	active          *os.File   // the last segment, open for appending
+}
+
+type segment struct {
+	path        string
+	created     time.Time // by the app's clock; names the file
+	first, last time.Time // the earliest and latest report timestamps
+	size        int64
+}
+
+// NewDiskStore returns a DiskStore using dir, which is created if it doesn't
+// exist. Any segments already in dir are recovered. A zero retention or
+// maxBytes means no limit of that kind.
+func NewDiskStore(dir string, segmentDuration, retention time.Duration, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &DiskStore{
+		dir:             dir,
+		segmentDuration: segmentDuration,
+		retention:       retention,
+		maxBytes:        maxBytes,
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put implements Store.
+func (s *DiskStore) Put(ts time.Time, rpt report.Report) error {
+	payload, err := encodeRecord(Envelope{Timestamp: ts, Report: rpt})
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := Now()
+	if s.active == nil || now.Sub(s.segments[len(s.segments)-1].created) >= s.segmentDuration {
+		if err := s.rotate(now, ts); err != nil {
+			return err
+		}
+	}
+
+	var header [headerLen]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(payload)))
+	if _, err := s.active.Write(append(header[:], payload...)); err != nil {
+		return err
+	}
+
+	current := s.segments[len(s.segments)-1]
+	current.extend(ts)
+	current.size += int64(headerLen + len(payload))
+
+	s.prune(now)
+	return nil
+}
+
+// Range implements Store. It returns the merge of all reports with a
+// timestamp in [from, to]. Segments are read without the lock, so Puts
+// aren't held up; only complete records are read, and a segment pruned
+// meanwhile is skipped.
+func (s *DiskStore) Range(from, to time.Time) (report.Report, error) {
+	s.mtx.RLock()
+	segments := make([]segment, 0, len(s.segments))
+	for _, seg := range s.segments {
+		if seg.last.Before(from) || seg.first.After(to) {
+			continue
+		}
+		segments = append(segments, *seg)
+	}
+	s.mtx.RUnlock()
+
+	rpt := report.MakeReport()
+	for _, seg := range segments {
+		if _, err := readSegment(seg.path, func(ts time.Time, r io.Reader) error {
+			if ts.Before(from) || ts.After(to) {
+				return nil
+			}
+			env, err := decodeRecord(r)
+			if err != nil {
+				return err
+			}
+			rpt = rpt.Merge(env.Report)
+			return nil
+		}); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return rpt, err
+		}
+	}
+	return rpt, nil
+}
+
+// Close implements Store.
+func (s *DiskStore) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
+
+// rotate closes the active segment, and starts a new one, created at now, for
+// a report at ts.
+func (s *DiskStore) rotate(now, ts time.Time) error {
+	if s.active != nil {
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		s.active = nil
+	}
+	path := filepath.Join(s.dir, fmt.Sprintf("%020d%s", now.UnixNano(), segmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{path: path, created: now, first: ts, last: ts})
+	return nil
+}
+
+// prune deletes the oldest segments, until the store is within its retention
+// and size limits. A segment has expired once it has been closed for longer
+// than the retention period. The active segment is never deleted. A segment which can't
+// be deleted is forgotten anyway, so it doesn't stop the others going.
+func (s *DiskStore) prune(now time.Time) {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.size
+	}
+	for len(s.segments) > 1 {
+		oldest, closed := s.segments[0], s.segments[1].created
+		expired := s.retention > 0 && closed.Before(now.Add(-s.retention))
+		oversize := s.maxBytes > 0 && total > s.maxBytes
+		if !expired && !oversize {
+			break
+		}
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("store: %v", err)
+		}
+		total -= oldest.size
+		s.segments = s.segments[1:]
+	}
+}
+
+// recover scans the directory for existing segments. Any partially-written
+// record at the end of the last segment (e.g. from a crash) is truncated,
+// and that segment is reopened for appending.
+func (s *DiskStore) recover() error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		created := time.Unix(0, nanos)
+		seg := &segment{
+			path:    filepath.Join(s.dir, name),
+			created: created,
+			first:   created,
+			last:    created,
+		}
+		if seg.size, err = readSegment(seg.path, func(ts time.Time, _ io.Reader) error {
+			seg.extend(ts)
+			return nil
+		}); err != nil {
+			return err
+		}
+		s.segments = append(s.segments, seg)
+	}
+	sort.Sort(segmentsByCreation(s.segments))
+	if len(s.segments) == 0 {
+		return nil
+	}
+
+	last := s.segments[len(s.segments)-1]
+	if err := os.Truncate(last.path, last.size); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	return nil
+}
+
+// extend makes the segment cover ts.
+func (seg *segment) extend(ts time.Time) {
+	if ts.Before(seg.first) {
+		seg.first = ts
+	}
+	if ts.After(seg.last) {
+		seg.last = ts
+	}
+}
+
+type segmentsByCreation []*segment
+
+func (s segmentsByCreation) Len() int           { return len(s) }
+func (s segmentsByCreation) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s segmentsByCreation) Less(i, j int) bool { return s[i].created.Before(s[j].created) }
+
+// readSegment calls f with the timestamp and payload of each complete record
+// in the segment at path, in order. Any part of the payload not consumed by f
+// is skipped. It returns the length of the segment up to the end of the last
+// complete record.
+func readSegment(path string, f func(time.Time, io.Reader) error) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		r      = bufio.NewReader(file)
+		offset int64
+	)
+	for {
+		var header [headerLen]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return offset, nil // EOF, or a truncated header
+		}
+		var (
+			ts     = time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8])))
+			length = int64(binary.BigEndian.Uint32(header[8:12]))
+			next   = offset + headerLen + length
+		)
+		if next > info.Size() {
+			return offset, nil // truncated payload
+		}
+		payload := io.LimitReader(r, length)
+		if err := f(ts, payload); err != nil {
+			return offset, err
+		}
+		if _, err := io.Copy(ioutil.Discard, payload); err != nil {
+			return offset, err
+		}
+		offset = next
+	}
+}
+
+// encodeRecord encodes an envelope for storage on disk.
+func encodeRecord(env Envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	gzwriter := gzip.NewWriter(&buf)
+	if err := BinaryCodec.Encode(gzwriter, env); err != nil {
+		return nil, err
+	}
+	if err := gzwriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeRecord decodes an envelope encoded by encodeRecord.
+func decodeRecord(r io.Reader) (Envelope, error) {
+	gzreader, err := gzip.NewReader(r)
+	if err != nil {
+		return Envelope{}, err
+	}
+	defer gzreader.Close()
+	return BinaryCodec.Decode(gzreader)
+}
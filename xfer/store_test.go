@@ -0,0 +1,204 @@
+package xfer_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/test"
+	"github.com/weaveworks/scope/xfer"
+)
+
+func makeReport(nodeID string) report.Report {
+	r := report.MakeReport()
+	r.Endpoint.Nodes[nodeID] = report.MakeNodeWith(map[string]string{"id": nodeID})
+	return r
+}
+
+func merged(rpts ...report.Report) report.Report {
+	result := report.MakeReport()
+	for _, r := range rpts {
+		result = result.Merge(r)
+	}
+	return result
+}
+
+// mockNow makes xfer.Now return whatever clock is set to, and returns a
+// function to undo the mocking.
+func mockNow(clock *time.Time) func() {
+	oldNow := xfer.Now
+	xfer.Now = func() time.Time { return *clock }
+	return func() { xfer.Now = oldNow }
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := xfer.NewDiskStore(dir, time.Minute, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		base  = time.Now()
+		clock = base
+		r1    = makeReport("foo")
+		r2    = makeReport("bar")
+		r3    = makeReport("baz")
+	)
+	defer mockNow(&clock)()
+	for _, tr := range []struct {
+		ts  time.Time
+		rpt report.Report
+	}{
+		{base, r1},
+		{base.Add(30 * time.Second), r2},
+		{base.Add(2 * time.Minute), r3}, // new segment
+	} {
+		clock = tr.ts
+		if err := store.Put(tr.ts, tr.rpt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(from, to time.Time, want report.Report) {
+		have, err := store.Range(from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, have) {
+			t.Error(test.Diff(want, have))
+		}
+	}
+	check(base, base.Add(30*time.Second), merged(r1, r2))
+	check(base.Add(time.Second), base.Add(3*time.Minute), merged(r2, r3))
+	check(base.Add(-time.Hour), base.Add(-time.Minute), merged())
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if want, have := 2, len(segments); want != have {
+		t.Errorf("want %d segments, have %d", want, have)
+	}
+
+	// Simulate a crash in the middle of a write; the partial record should be
+	// ignored, and the store should still be appendable.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(segments[1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 1, 2, 3, 4, 5})
+	f.Close()
+
+	store, err = xfer.NewDiskStore(dir, time.Minute, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	r4 := makeReport("qux")
+	clock = base.Add(150 * time.Second)
+	if err := store.Put(clock, r4); err != nil {
+		t.Fatal(err)
+	}
+	check(base, base.Add(time.Hour), merged(r1, r2, r3, r4))
+}
+
+func TestDiskStoreRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := xfer.NewDiskStore(dir, time.Minute, 10*time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.Now()
+	clock := base
+	defer mockNow(&clock)()
+	for i := 0; i < 30; i++ {
+		clock = base.Add(time.Duration(i) * time.Minute)
+		if err := store.Put(clock, makeReport("foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if want, have := 12, len(segments); want != have { // closed within the last 10 minutes, and the active one
+		t.Errorf("want %d segments, have %d", want, have)
+	}
+	have, err := store.Range(base, base.Add(15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := merged(); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestDiskStoreConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := xfer.NewDiskStore(dir, time.Minute, 5*time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Segments are pruned while they're being read. Only Put reads the
+	// clock, so only the goroutine putting reports sets it.
+	var (
+		base  = time.Now()
+		clock = base
+		done  = make(chan struct{})
+	)
+	defer mockNow(&clock)()
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			clock = base.Add(time.Duration(i) * time.Minute)
+			if err := store.Put(clock, makeReport("foo")); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		if _, err := store.Range(base, base.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A report which arrives late is still found.
+	late := makeReport("bar")
+	if err := store.Put(base.Add(30*time.Minute), late); err != nil {
+		t.Fatal(err)
+	}
+	have, err := store.Range(base.Add(29*time.Minute), base.Add(31*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := merged(late); !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
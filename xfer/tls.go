@@ -0,0 +1,64 @@
+package xfer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// ClientTLSConfig returns a TLS config for publishers. If caFile is
+// non-empty, the app's certificate is verified against the CA bundle it
+// contains, rather than the system roots. If certFile and keyFile are
+// non-empty, the client certificate they contain is presented to the app.
+func ClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// ServerTLSConfig returns a TLS config for the app. If clientCAFile is
+// non-empty, client certificates are requested, and verified against the CA
+// bundle it contains if they're presented. It's up to the handlers to decide
+// whether a verified certificate is required; see http.Request.TLS.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,124 @@
+package xfer_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/weaveworks/scope/report"
+	"github.com/weaveworks/scope/xfer"
+)
+
+func TestHTTPPublisherTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scope-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := makeCert(t, dir, "ca", nil, nil)
+	makeCert(t, dir, "server", ca, caKey)
+	makeCert(t, dir, "client", ca, caKey)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverConfig, err := xfer.ServerTLSConfig(path("server.crt"), path("server.key"), path("ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified := make(chan bool, 1)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified <- len(r.TLS.VerifiedChains) > 0
+	}))
+	s.TLS = serverConfig
+	s.StartTLS()
+	defer s.Close()
+
+	for _, tc := range []struct {
+		certFile, keyFile string
+		verified          bool
+	}{
+		{path("client.crt"), path("client.key"), true},
+		{"", "", false},
+	} {
+		clientConfig, err := xfer.ClientTLSConfig(path("ca.crt"), tc.certFile, tc.keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := xfer.NewHTTPPublisher(s.Listener.Addr().String(), "token", "id", clientConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Publish(report.MakeReport()); err != nil {
+			t.Fatal(err)
+		}
+		if want, have := tc.verified, <-verified; want != have {
+			t.Errorf("want %v, have %v", want, have)
+		}
+	}
+
+	// Without the CA, the server's certificate can't be verified.
+	clientConfig, err := xfer.ClientTLSConfig("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := xfer.NewHTTPPublisher(s.Listener.Addr().String(), "token", "id", clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(report.MakeReport()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// makeCert writes name.crt and name.key to dir. The certificate is signed by
+// parent, or is a self-signed CA if parent is nil.
+func makeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, block := range map[string]*pem.Block{
+		name + ".crt": {Type: "CERTIFICATE", Bytes: der},
+		name + ".key": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cert, key
+}
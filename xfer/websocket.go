@@ -0,0 +1,382 @@
+package xfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/scope/report"
+)
+
+// ReportWebsocketPath is where apps accept persistent report connections.
+//
+// Each report is sent as a binary message: an 8-byte, big-endian message ID,
+// followed by the gzipped envelope, encoded with the BinaryCodec. The app
+// answers each with a ReportAck, in a WebsocketMessage, as JSON text. Many
+// reports may be awaiting acknowledgement at once. The app may also send
+// control requests to the probe, which answers each with a control response,
+// in the same way. The probe pings the app every heartbeat; either side
+// closes the connection if it hears nothing for a few heartbeats.
+const ReportWebsocketPath = "/api/report/ws"
+
+const (
+	// WebsocketHeartbeat is the interval between pings from the probe.
+	WebsocketHeartbeat = 5 * time.Second
+
+	// WebsocketTimeout is how long either side waits to hear something
+	// before considering the connection dead.
+	WebsocketTimeout = 3 * WebsocketHeartbeat
+
+	websocketWriteTimeout = 10 * time.Second
+
+	// websocketRetryInterval is how long a WebsocketPublisher publishes by
+	// HTTP POST, after finding an app without websocket support, before
+	// trying a websocket again.
+	websocketRetryInterval = time.Minute
+)
+
+// ReportAck acknowledges a report received over a websocket. Status has the
+// meaning of an HTTP status code, for the equivalent POST.
+type ReportAck struct {
+	ID     uint64 `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// EncodeReportMessage encodes a report message, as sent by probes.
+func EncodeReportMessage(id uint64, env Envelope) ([]byte, error) {
+	var buf bytes.Buffer
+	var header [8]byte
+	binary.BigEndian.PutUint64(header[:], id)
+	buf.Write(header[:])
+	gzwriter := gzip.NewWriter(&buf)
+	if err := BinaryCodec.Encode(gzwriter, env); err != nil {
+		return nil, err
+	}
+	if err := gzwriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeReportMessage decodes a report message, as received by apps. If the
+// ID could be read, it's returned even if the envelope couldn't.
+func DecodeReportMessage(r io.Reader) (uint64, Envelope, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, Envelope{}, err
+	}
+	id := binary.BigEndian.Uint64(header[:])
+	gzreader, err := gzip.NewReader(r)
+	if err != nil {
+		return id, Envelope{}, err
+	}
+	defer gzreader.Close()
+	env, err := DecodeEnvelope(BinaryContentType, gzreader)
+	return id, env, err
+}
+
+var (
+	errConnectionClosed = errors.New("connection closed")
+	errNoWebsocket      = errors.New("app doesn't accept websockets")
+)
+
+// WebsocketPublisher publishes reports over a single, persistent websocket
+// connection to an app. If the connection fails, the publish fails, and the
+// next publish reconnects. Control requests from the app are passed to the
+// control handler, if any. Apps from before websocket support answer the
+// handshake with a 404; reports are published to them by HTTP POST instead,
+// without controls.
+type WebsocketPublisher struct {
+	url      string
+	id       string
+	header   http.Header
+	dialer   *websocket.Dialer
+	fallback *HTTPPublisher
+
+	mtx           sync.Mutex
+	conn          *wsConnection
+	nextID        uint64
+	control       func(ControlRequest) ControlResponse
+	fallbackUntil time.Time // publish by POST until then
+}
+
+// wsConnection is a single websocket connection, and the reports awaiting
+// acknowledgement on it.
+type wsConnection struct {
+	conn     *websocket.Conn
+	writeMtx sync.Mutex
+	mtx      sync.Mutex
+	pending  map[uint64]chan ReportAck
+	pipes    Pipes
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// NewWebsocketPublisher returns a WebsocketPublisher ready for use. Targets
+// are interpreted as for NewHTTPPublisher. No connection is made until the
+// first publish.
+func NewWebsocketPublisher(target, token, id string, tlsConfig *tls.Config) (*WebsocketPublisher, error) {
+	httpTarget := target
+	if strings.HasPrefix(target, "ws") {
+		httpTarget = "http" + strings.TrimPrefix(target, "ws") // and wss to https
+	}
+	fallback, err := NewHTTPPublisher(httpTarget, token, id, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(target, "http") && !strings.HasPrefix(target, "ws") {
+		if tlsConfig != nil {
+			target = "wss://" + target
+		} else {
+			target = "ws://" + target
+		}
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	if u.Path == "" {
+		u.Path = ReportWebsocketPath
+	}
+	header := http.Header{}
+	header.Set("Authorization", AuthorizationHeader(token))
+	header.Set(ScopeProbeIDHeader, id)
+	return &WebsocketPublisher{
+		url:      u.String(),
+		id:       id,
+		header:   header,
+		dialer:   &websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: websocketWriteTimeout},
+		fallback: fallback,
+	}, nil
+}
+
+// HandleControl sets the function which answers control requests from the
+// app. Without one, control requests are answered with an error.
+func (p *WebsocketPublisher) HandleControl(f func(ControlRequest) ControlResponse) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.control = f
+}
+
+// Publish implements Publisher.
+func (p *WebsocketPublisher) Publish(rpt report.Report) error {
+	return p.PublishEnvelope(Envelope{Report: rpt})
+}
+
+// PublishEnvelope implements EnvelopePublisher. It waits for the app to
+// acknowledge the report.
+func (p *WebsocketPublisher) PublishEnvelope(env Envelope) error {
+	env.ProbeID = p.id
+	if env.Timestamp.IsZero() {
+		env.Timestamp = time.Now()
+	}
+
+	c, id, err := p.connect()
+	if err == errNoWebsocket {
+		return p.fallback.PublishEnvelope(env)
+	} else if err != nil {
+		return err
+	}
+	msg, err := EncodeReportMessage(id, env)
+	if err != nil {
+		return err
+	}
+
+	acks := make(chan ReportAck, 1)
+	c.mtx.Lock()
+	c.pending[id] = acks
+	c.mtx.Unlock()
+	defer func() {
+		c.mtx.Lock()
+		delete(c.pending, id)
+		c.mtx.Unlock()
+	}()
+
+	if err := c.write(websocket.BinaryMessage, msg); err != nil {
+		p.disconnect(c)
+		return err
+	}
+
+	select {
+	case ack := <-acks:
+		switch ack.Status {
+		case http.StatusOK:
+			return nil
+		case http.StatusConflict:
+			return ErrResync
+		default:
+			return fmt.Errorf("%d %s", ack.Status, ack.Error)
+		}
+	case <-c.closed:
+		return errConnectionClosed
+	case <-time.After(WebsocketTimeout):
+		p.disconnect(c)
+		return fmt.Errorf("timeout waiting for acknowledgement")
+	}
+}
+
+// Stop closes the connection, if any.
+func (p *WebsocketPublisher) Stop() {
+	p.mtx.Lock()
+	c := p.conn
+	p.mtx.Unlock()
+	if c != nil {
+		p.disconnect(c)
+	}
+}
+
+// connect returns the current connection, dialing a new one if necessary,
+// and the next message ID.
+func (p *WebsocketPublisher) connect() (*wsConnection, uint64, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.nextID++
+
+	if p.conn != nil {
+		return p.conn, p.nextID, nil
+	}
+	if time.Now().Before(p.fallbackUntil) {
+		return nil, 0, errNoWebsocket
+	}
+	conn, resp, err := p.dialer.Dial(p.url, p.header)
+	if err == websocket.ErrBadHandshake && resp != nil && resp.StatusCode == http.StatusNotFound {
+		if p.fallbackUntil.IsZero() {
+			log.Printf("publish: %s doesn't accept websockets; publishing by HTTP POST, without controls", p.url)
+		}
+		p.fallbackUntil = time.Now().Add(websocketRetryInterval)
+		return nil, 0, errNoWebsocket
+	} else if err != nil {
+		return nil, 0, err
+	}
+	c := &wsConnection{
+		conn:    conn,
+		pending: map[uint64]chan ReportAck{},
+		closed:  make(chan struct{}),
+	}
+	p.conn = c
+	go p.readLoop(c)
+	go p.heartbeat(c)
+	return c, p.nextID, nil
+}
+
+func (p *WebsocketPublisher) disconnect(c *wsConnection) {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+		c.pipes.CloseAll()
+	})
+	p.mtx.Lock()
+	if p.conn == c {
+		p.conn = nil
+	}
+	p.mtx.Unlock()
+}
+
+// readLoop delivers acknowledgements, and answers control requests, until
+// the connection fails.
+func (p *WebsocketPublisher) readLoop(c *wsConnection) {
+	defer p.disconnect(c)
+	c.conn.SetReadDeadline(time.Now().Add(WebsocketTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(WebsocketTimeout))
+	})
+	for {
+		var msg WebsocketMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(WebsocketTimeout))
+		switch {
+		case msg.Ack != nil:
+			c.mtx.Lock()
+			if acks, ok := c.pending[msg.Ack.ID]; ok {
+				select {
+				case acks <- *msg.Ack:
+				default:
+				}
+			}
+			c.mtx.Unlock()
+		case msg.Request != nil:
+			go p.handleControl(c, *msg.Request)
+		case msg.Pipe != nil:
+			c.pipes.Deliver(*msg.Pipe)
+		}
+	}
+}
+
+func (p *WebsocketPublisher) handleControl(c *wsConnection, req ControlRequest) {
+	p.mtx.Lock()
+	control := p.control
+	p.mtx.Unlock()
+
+	if req.PipeID != "" {
+		req.Pipe = c.pipes.Open(req.PipeID, func(msg PipeMessage) error {
+			return c.writeJSON(WebsocketMessage{Pipe: &msg})
+		})
+	}
+	res := ControlResponse{Error: "probe doesn't accept control requests"}
+	if control != nil {
+		res = control(req)
+	}
+	if res.Error != "" && req.Pipe != nil {
+		req.Pipe.Close()
+	}
+	res.ID = req.ID
+	if err := c.writeJSON(WebsocketMessage{Response: &res}); err != nil {
+		p.disconnect(c)
+	}
+}
+
+// heartbeat pings the app until the connection fails.
+func (p *WebsocketPublisher) heartbeat(c *wsConnection) {
+	ticker := time.NewTicker(WebsocketHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteTimeout)); err != nil {
+				p.disconnect(c)
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *wsConnection) writeJSON(msg WebsocketMessage) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.TextMessage, buf)
+}
+
+func (c *wsConnection) write(messageType int, data []byte) error {
+	c.writeMtx.Lock()
+	defer c.writeMtx.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout)); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
@@ -0,0 +1,177 @@
+package xfer_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/weaveworks/scope/test"
+	"github.com/weaveworks/scope/xfer"
+)
+
+// websocketReceiver accepts reports over websockets, like the app does, and
+// acknowledges them with the given status.
+type websocketReceiver struct {
+	mtx    sync.Mutex
+	status int
+	envs   []xfer.Envelope
+	conns  []*websocket.Conn
+}
+
+func (r *websocketReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	r.mtx.Lock()
+	r.conns = append(r.conns, conn)
+	r.mtx.Unlock()
+	for {
+		_, reader, err := conn.NextReader()
+		if err != nil {
+			return
+		}
+		id, env, err := xfer.DecodeReportMessage(reader)
+		if err != nil {
+			return
+		}
+		r.mtx.Lock()
+		r.envs = append(r.envs, env)
+		status := r.status
+		r.mtx.Unlock()
+		if err := conn.WriteJSON(xfer.WebsocketMessage{Ack: &xfer.ReportAck{ID: id, Status: status}}); err != nil {
+			return
+		}
+	}
+}
+
+func (r *websocketReceiver) dropAll() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+}
+
+func (r *websocketReceiver) received() ([]xfer.Envelope, int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.envs, len(r.conns)
+}
+
+func TestReportMessage(t *testing.T) {
+	want := xfer.Envelope{
+		Version:   xfer.WireVersion,
+		ProbeID:   "probe",
+		Sequence:  3,
+		Timestamp: time.Date(2015, 8, 20, 12, 0, 0, 0, time.UTC),
+		Report:    makeReport("foo"),
+	}
+	msg, err := xfer.EncodeReportMessage(42, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, have, err := xfer.DecodeReportMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 {
+		t.Errorf("want 42, have %d", id)
+	}
+	want.Encoding = xfer.BinaryCodec.Name()
+	have.Size = 0
+	if !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+}
+
+func TestWebsocketPublisher(t *testing.T) {
+	receiver := &websocketReceiver{status: http.StatusOK}
+	s := httptest.NewServer(receiver)
+	defer s.Close()
+
+	p, err := xfer.NewWebsocketPublisher(s.URL, "token", "probe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if err := p.Publish(makeReport("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PublishEnvelope(xfer.Envelope{Sequence: 2, Report: makeReport("bar")}); err != nil {
+		t.Fatal(err)
+	}
+	envs, conns := receiver.received()
+	if want, have := 1, conns; want != have {
+		t.Errorf("want %d connection(s), have %d", want, have)
+	}
+	if want, have := 2, len(envs); want != have {
+		t.Fatalf("want %d report(s), have %d", want, have)
+	}
+	if want, have := "probe", envs[1].ProbeID; want != have {
+		t.Errorf("want %q, have %q", want, have)
+	}
+	if want, have := uint64(2), envs[1].Sequence; want != have {
+		t.Errorf("want %d, have %d", want, have)
+	}
+	if want, have := makeReport("bar"), envs[1].Report; !reflect.DeepEqual(want, have) {
+		t.Error(test.Diff(want, have))
+	}
+
+	// Publishes fail while the connection is down, then reconnect.
+	receiver.dropAll()
+	var published bool
+	for i := 0; i < 3 && !published; i++ {
+		published = p.Publish(makeReport("baz")) == nil
+	}
+	if !published {
+		t.Fatal("publisher didn't reconnect")
+	}
+	if _, conns := receiver.received(); conns != 2 {
+		t.Errorf("want 2 connections, have %d", conns)
+	}
+
+	receiver.mtx.Lock()
+	receiver.status = http.StatusConflict
+	receiver.mtx.Unlock()
+	if want, have := xfer.ErrResync, p.Publish(makeReport("foo")); want != have {
+		t.Errorf("want %v, have %v", want, have)
+	}
+}
+
+func TestWebsocketPublisherFallback(t *testing.T) {
+	// An app from before websocket support only accepts POSTs.
+	posted := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/report", func(w http.ResponseWriter, r *http.Request) {
+		posted <- r.Header.Get(xfer.ScopeProbeIDHeader)
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	p, err := xfer.NewWebsocketPublisher(s.URL, "token", "probe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if err := p.Publish(makeReport("foo")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-posted:
+		if want, have := "probe", id; want != have {
+			t.Errorf("want %q, have %q", want, have)
+		}
+	default:
+		t.Error("report not posted")
+	}
+}